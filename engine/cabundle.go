@@ -0,0 +1,52 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+// CABundlePath is the path at which the custom certificate
+// authority bundle is mounted in the step container. A
+// dedicated directory is used so that the mount does not
+// shadow the certificates shipped with the image.
+const CABundlePath = "/etc/drone/ca/ca-certificates.crt"
+
+// caBundleEnv lists the environment variables that point
+// common tooling (openssl, go, curl, git, node) at the
+// certificate authority bundle.
+var caBundleEnv = []string{
+	"SSL_CERT_FILE",
+	"NODE_EXTRA_CA_CERTS",
+	"GIT_SSL_CAINFO",
+}
+
+// MountCABundle is a helper function that mounts the
+// certificate authority bundle in every pipeline step at
+// the CABundlePath, and sets the environment variables
+// used by common tooling to locate the bundle. The file is
+// added to the specification if it does not already exist.
+// Variables already defined by the step are not changed.
+//
+// Note that SSL_CERT_FILE and GIT_SSL_CAINFO replace the
+// system certificate roots, so the bundle should include
+// the public root certificates in addition to the custom
+// certificate authority.
+func MountCABundle(spec *Spec, file *File) {
+	if _, ok := LookupFile(spec, file.Metadata.Name); !ok {
+		spec.Files = append(spec.Files, file)
+	}
+	for _, step := range spec.Steps {
+		step.Files = append(step.Files, &FileMount{
+			Name: file.Metadata.Name,
+			Path: CABundlePath,
+			Mode: 0644,
+		})
+		if step.Envs == nil {
+			step.Envs = map[string]string{}
+		}
+		for _, key := range caBundleEnv {
+			if _, ok := step.Envs[key]; !ok {
+				step.Envs[key] = CABundlePath
+			}
+		}
+	}
+}
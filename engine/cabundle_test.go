@@ -0,0 +1,52 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestMountCABundle(t *testing.T) {
+	file := &File{
+		Metadata: Metadata{UID: "uid_ca", Name: "ca-bundle"},
+		Data:     []byte("-----BEGIN CERTIFICATE-----"),
+	}
+	spec := &Spec{
+		Steps: []*Step{
+			{Metadata: Metadata{Name: "build"}},
+			{
+				Metadata: Metadata{Name: "test"},
+				Envs:     map[string]string{"GIT_SSL_CAINFO": "/custom.crt"},
+			},
+		},
+	}
+	MountCABundle(spec, file)
+	MountCABundle(&Spec{Files: spec.Files}, file)
+
+	if got, want := len(spec.Files), 1; got != want {
+		t.Errorf("Want %d files, got %d", want, got)
+	}
+	for _, step := range spec.Steps {
+		if got, want := len(step.Files), 1; got != want {
+			t.Errorf("Want %d file mounts, got %d", want, got)
+			continue
+		}
+		mount := step.Files[0]
+		if got, want := mount.Name, file.Metadata.Name; got != want {
+			t.Errorf("Want file mount name %s, got %s", want, got)
+		}
+		if got, want := mount.Path, CABundlePath; got != want {
+			t.Errorf("Want file mount path %s, got %s", want, got)
+		}
+	}
+
+	build := spec.Steps[0].Envs
+	for _, key := range []string{"SSL_CERT_FILE", "NODE_EXTRA_CA_CERTS", "GIT_SSL_CAINFO"} {
+		if got, want := build[key], CABundlePath; got != want {
+			t.Errorf("Want %s %s, got %s", key, want, got)
+		}
+	}
+	if got, want := spec.Steps[1].Envs["GIT_SSL_CAINFO"], "/custom.crt"; got != want {
+		t.Errorf("Want existing GIT_SSL_CAINFO %s preserved, got %s", want, got)
+	}
+}
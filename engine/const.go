@@ -116,3 +116,108 @@ func (r *RunPolicy) UnmarshalJSON(b []byte) error {
 	*r = runPolicyName[s]
 	return nil
 }
+
+// RestartPolicy defines the container restart policy.
+type RestartPolicy int
+
+// RestartPolicy enumeration.
+const (
+	RestartNo RestartPolicy = iota
+	RestartOnFailure
+	RestartUnlessStopped
+)
+
+func (r RestartPolicy) String() string {
+	return restartPolicyID[r]
+}
+
+var restartPolicyID = map[RestartPolicy]string{
+	RestartNo:            "no",
+	RestartOnFailure:     "on-failure",
+	RestartUnlessStopped: "unless-stopped",
+}
+
+var restartPolicyName = map[string]RestartPolicy{
+	"":               RestartNo,
+	"no":             RestartNo,
+	"on-failure":     RestartOnFailure,
+	"unless-stopped": RestartUnlessStopped,
+}
+
+// MarshalJSON marshals the string representation of the
+// restart type to JSON.
+func (r *RestartPolicy) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	buffer.WriteString(restartPolicyID[*r])
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalJSON unmarshals the json representation of the
+// restart type from a string value.
+func (r *RestartPolicy) UnmarshalJSON(b []byte) error {
+	// unmarshal as string
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	// lookup value
+	*r = restartPolicyName[s]
+	return nil
+}
+
+// QoSClass defines the quality of service class of a
+// step, which determines the order in which containers
+// are evicted when the node runs out of resources.
+type QoSClass int
+
+// QoSClass enumeration.
+const (
+	QoSDefault QoSClass = iota
+	QoSGuaranteed
+	QoSBurstable
+	QoSBestEffort
+)
+
+func (q QoSClass) String() string {
+	return qosClassID[q]
+}
+
+var qosClassID = map[QoSClass]string{
+	QoSDefault:    "default",
+	QoSGuaranteed: "guaranteed",
+	QoSBurstable:  "burstable",
+	QoSBestEffort: "best-effort",
+}
+
+var qosClassName = map[string]QoSClass{
+	"":            QoSDefault,
+	"default":     QoSDefault,
+	"guaranteed":  QoSGuaranteed,
+	"burstable":   QoSBurstable,
+	"best-effort": QoSBestEffort,
+}
+
+// MarshalJSON marshals the string representation of the
+// qos class to JSON.
+func (q *QoSClass) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	buffer.WriteString(qosClassID[*q])
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalJSON unmarshals the json representation of the
+// qos class from a string value.
+func (q *QoSClass) UnmarshalJSON(b []byte) error {
+	// unmarshal as string
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	// lookup value
+	*q = qosClassName[s]
+	return nil
+}
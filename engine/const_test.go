@@ -235,3 +235,121 @@ func TestPullPolicy_String(t *testing.T) {
 		}
 	}
 }
+
+//
+// restart policy unit tests.
+//
+
+func TestRestartPolicy_Marshal(t *testing.T) {
+	tests := []struct {
+		policy RestartPolicy
+		data   string
+	}{
+		{
+			policy: RestartNo,
+			data:   `"no"`,
+		},
+		{
+			policy: RestartOnFailure,
+			data:   `"on-failure"`,
+		},
+		{
+			policy: RestartUnlessStopped,
+			data:   `"unless-stopped"`,
+		},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(&test.policy)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if bytes.Equal([]byte(test.data), data) == false {
+			t.Errorf("Failed to marshal policy %s", test.policy)
+		}
+	}
+}
+
+func TestRestartPolicy_Unmarshal(t *testing.T) {
+	tests := []struct {
+		policy RestartPolicy
+		data   string
+	}{
+		{
+			policy: RestartNo,
+			data:   `"no"`,
+		},
+		{
+			policy: RestartOnFailure,
+			data:   `"on-failure"`,
+		},
+		{
+			policy: RestartUnlessStopped,
+			data:   `"unless-stopped"`,
+		},
+		{
+			// no policy should default to no
+			policy: RestartNo,
+			data:   `""`,
+		},
+	}
+	for _, test := range tests {
+		var policy RestartPolicy
+		err := json.Unmarshal([]byte(test.data), &policy)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got, want := policy, test.policy; got != want {
+			t.Errorf("Want policy %q, got %q", want, got)
+		}
+	}
+}
+
+func TestRestartPolicy_UnmarshalTypeError(t *testing.T) {
+	var policy RestartPolicy
+	err := json.Unmarshal([]byte("[]"), &policy)
+	if _, ok := err.(*json.UnmarshalTypeError); !ok {
+		t.Errorf("Expect unmarshal error return when JSON invalid")
+	}
+}
+
+//
+// qos class unit tests.
+//
+
+func TestQoSClass_Unmarshal(t *testing.T) {
+	tests := []struct {
+		class QoSClass
+		data  string
+	}{
+		{
+			class: QoSGuaranteed,
+			data:  `"guaranteed"`,
+		},
+		{
+			class: QoSBurstable,
+			data:  `"burstable"`,
+		},
+		{
+			class: QoSBestEffort,
+			data:  `"best-effort"`,
+		},
+		{
+			// no class should default to default
+			class: QoSDefault,
+			data:  `""`,
+		},
+	}
+	for _, test := range tests {
+		var class QoSClass
+		err := json.Unmarshal([]byte(test.data), &class)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got, want := class, test.class; got != want {
+			t.Errorf("Want qos class %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DigestResolver resolves the manifest digest of an image,
+// which is used to pin the step images for reproducible
+// builds.
+type DigestResolver interface {
+	// Resolve returns the manifest digest of the image,
+	// for example sha256:4bc4... The credentials are nil
+	// if no credentials are configured for the registry.
+	Resolve(ctx context.Context, image string, auth *DockerAuth) (string, error)
+}
+
+// PinImages rewrites the image of each step to reference
+// the manifest digest of the image tag, for example
+// alpine@sha256:4bc4... Images that already reference a
+// digest are not changed. Each image is resolved once.
+func PinImages(ctx context.Context, spec *Spec, resolver DigestResolver) error {
+	cache := map[string]string{}
+	for _, step := range spec.Steps {
+		if step.Docker == nil || step.Docker.Image == "" {
+			continue
+		}
+		image := step.Docker.Image
+		if strings.Contains(image, "@") {
+			continue
+		}
+		if pinned, ok := cache[image]; ok {
+			step.Docker.Image = pinned
+			continue
+		}
+		domain, _, _ := splitImage(image)
+		auth, _ := LookupAuth(spec, domain)
+		digest, err := resolver.Resolve(ctx, image, auth)
+		if err != nil {
+			return fmt.Errorf("engine: cannot resolve image %s: %s", image, err)
+		}
+		pinned := trimTag(image) + "@" + digest
+		cache[image] = pinned
+		step.Docker.Image = pinned
+	}
+	return nil
+}
+
+// helper function returns the image name without the tag.
+func trimTag(image string) string {
+	i := strings.LastIndex(image, ":")
+	if i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// helper function splits the image into the registry
+// domain, repository path and tag. The tag defaults to
+// latest.
+func splitImage(image string) (domain, path, tag string) {
+	qualified := qualifyImage(trimTag(image))
+	parts := strings.SplitN(qualified, "/", 2)
+	domain, path, tag = parts[0], parts[1], "latest"
+	if trimmed := trimTag(image); trimmed != image {
+		tag = image[len(trimmed)+1:]
+	}
+	return
+}
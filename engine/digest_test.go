@@ -0,0 +1,66 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPinImages(t *testing.T) {
+	spec := &Spec{
+		Docker: &DockerConfig{
+			Auths: []*DockerAuth{
+				{Address: "gcr.io", Username: "octocat", Password: "correct-horse-battery-staple"},
+			},
+		},
+		Steps: []*Step{
+			{Docker: &DockerStep{Image: "alpine:3.6"}},
+			{Docker: &DockerStep{Image: "alpine:3.6"}},
+			{Docker: &DockerStep{Image: "gcr.io/octocat/hello-world"}},
+			{Docker: &DockerStep{Image: "golang@sha256:0a9e1b"}},
+		},
+	}
+	resolver := &mockDigestResolver{
+		digests: map[string]string{
+			"alpine:3.6":                 "sha256:7df6db",
+			"gcr.io/octocat/hello-world": "sha256:c1a7e2",
+		},
+		auths: map[string]*DockerAuth{},
+	}
+	if err := PinImages(context.Background(), spec, resolver); err != nil {
+		t.Error(err)
+		return
+	}
+	want := []string{
+		"alpine@sha256:7df6db",
+		"alpine@sha256:7df6db",
+		"gcr.io/octocat/hello-world@sha256:c1a7e2",
+		"golang@sha256:0a9e1b",
+	}
+	for i, step := range spec.Steps {
+		if got := step.Docker.Image; got != want[i] {
+			t.Errorf("Want image %s, got %s", want[i], got)
+		}
+	}
+	if got, want := resolver.calls, 2; got != want {
+		t.Errorf("Want %d resolutions with caching, got %d", want, got)
+	}
+	if resolver.auths["gcr.io/octocat/hello-world"] == nil {
+		t.Errorf("Expect registry credentials passed to resolver")
+	}
+}
+
+type mockDigestResolver struct {
+	digests map[string]string
+	auths   map[string]*DockerAuth
+	calls   int
+}
+
+func (r *mockDigestResolver) Resolve(_ context.Context, image string, auth *DockerAuth) (string, error) {
+	r.calls++
+	r.auths[image] = auth
+	return r.digests[image], nil
+}
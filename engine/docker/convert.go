@@ -16,18 +16,20 @@ package docker
 
 import (
 	"strings"
+	"time"
 
 	"github.com/drone/drone-runtime/engine"
 
 	"docker.io/go-docker/api/types/container"
 	"docker.io/go-docker/api/types/mount"
 	"docker.io/go-docker/api/types/network"
+	"github.com/docker/go-units"
 )
 
 // returns a container configuration.
 func toConfig(spec *engine.Spec, step *engine.Step) *container.Config {
 	config := &container.Config{
-		Image:        step.Docker.Image,
+		Image:        engine.MirrorImage(spec, step.Docker.Image),
 		Labels:       step.Metadata.Labels,
 		WorkingDir:   step.WorkingDir,
 		User:         step.Docker.User,
@@ -43,6 +45,9 @@ func toConfig(spec *engine.Spec, step *engine.Step) *container.Config {
 	if len(step.Envs) != 0 {
 		config.Env = toEnv(step.Envs)
 	}
+	if _, ok := step.Envs["TZ"]; !ok && step.Timezone != "" {
+		config.Env = append(config.Env, "TZ="+step.Timezone)
+	}
 	for _, sec := range step.Secrets {
 		secret, ok := engine.LookupSecret(spec, sec.Name)
 		if ok {
@@ -55,6 +60,26 @@ func toConfig(spec *engine.Spec, step *engine.Step) *container.Config {
 	if len(step.Docker.Command) != 0 {
 		config.Entrypoint = step.Docker.Command
 	}
+	if step.Docker.Healthcheck != nil {
+		config.Healthcheck = toHealthConfig(step.Docker.Healthcheck)
+	}
+	if step.Docker.TTY {
+		config.Tty = true
+	}
+	// a terminal requires the container input to be open,
+	// consistent with the kubernetes engine.
+	if step.Docker.Stdin || step.Docker.TTY {
+		config.OpenStdin = true
+		config.AttachStdin = true
+		config.StdinOnce = true
+	}
+	if step.Docker.StopSignal != "" {
+		config.StopSignal = step.Docker.StopSignal
+	}
+	if step.Docker.StopTimeout > 0 {
+		timeout := toStopTimeout(step.Docker.StopTimeout)
+		config.StopTimeout = &timeout
+	}
 
 	// NOTE it appears this is no longer required,
 	// however this could cause incompatibility with
@@ -66,6 +91,18 @@ func toConfig(spec *engine.Spec, step *engine.Step) *container.Config {
 	return config
 }
 
+// helper function converts the healthcheck declaration
+// to the docker healthcheck configuration.
+func toHealthConfig(from *engine.Healthcheck) *container.HealthConfig {
+	return &container.HealthConfig{
+		Test:        from.Test,
+		Interval:    from.Interval,
+		Timeout:     from.Timeout,
+		StartPeriod: from.StartPeriod,
+		Retries:     from.Retries,
+	}
+}
+
 // returns a container host configuration.
 func toHostConfig(spec *engine.Spec, step *engine.Step) *container.HostConfig {
 	config := &container.HostConfig{
@@ -75,6 +112,17 @@ func toHostConfig(spec *engine.Spec, step *engine.Step) *container.HostConfig {
 		Privileged: step.Docker.Privileged,
 		// TODO(bradrydzewski) set ShmSize
 	}
+	if spec.Docker != nil && spec.Docker.LogConfig != nil && spec.Docker.LogConfig.Type != "" {
+		config.LogConfig = container.LogConfig{
+			Type:   spec.Docker.LogConfig.Type,
+			Config: spec.Docker.LogConfig.Options,
+		}
+	}
+	if step.Docker.Restart != engine.RestartNo {
+		config.RestartPolicy = container.RestartPolicy{
+			Name: step.Docker.Restart.String(),
+		}
+	}
 	// windows does not support privileged so we hard-code
 	// this value to false.
 	if spec.Platform.OS == "windows" {
@@ -89,22 +137,24 @@ func toHostConfig(spec *engine.Spec, step *engine.Step) *container.HostConfig {
 	if len(step.Docker.ExtraHosts) > 0 {
 		config.ExtraHosts = step.Docker.ExtraHosts
 	}
+	for _, alias := range step.HostAliases {
+		for _, hostname := range alias.Hostnames {
+			config.ExtraHosts = append(config.ExtraHosts, hostname+":"+alias.IP)
+		}
+	}
 	if step.Resources != nil {
 		config.Resources = container.Resources{}
 		if limits := step.Resources.Limits; limits != nil {
 			config.Resources.Memory = limits.Memory
-			// TODO(bradrydewski) set config.Resources.CPUPercent
-
-			// IMPORTANT docker and kubernetes use
-			// different units of measure for cpu limits.
-			// we need to figure out how to convert from
-			// the kubernetes unit of measure to the docker
-			// unit of measure.
+			config.Resources.NanoCPUs = toNanoCPUs(limits.CPU)
 		}
 	}
+	if len(step.Docker.Ulimits) != 0 {
+		config.Resources.Ulimits = toUlimits(step.Docker.Ulimits)
+	}
 
+	config.Devices = toDeviceSlice(spec, step)
 	if len(step.Volumes) != 0 {
-		config.Devices = toDeviceSlice(spec, step)
 		config.Binds = toVolumeSlice(spec, step)
 		config.Mounts = toVolumeMounts(spec, step)
 	}
@@ -116,29 +166,50 @@ func toNetConfig(spec *engine.Spec, proc *engine.Step) *network.NetworkingConfig
 	endpoints := map[string]*network.EndpointSettings{}
 	endpoints[spec.Metadata.UID] = &network.EndpointSettings{
 		NetworkID: spec.Metadata.UID,
-		Aliases:   []string{proc.Metadata.Name},
+		Aliases:   toAliases(proc),
 	}
 	return &network.NetworkingConfig{
 		EndpointsConfig: endpoints,
 	}
 }
 
+// helper function returns the network aliases for the
+// step. The step name is sanitized to a valid hostname,
+// mirroring the kubernetes service name, so that service
+// containers are reachable by name on either engine.
+func toAliases(step *engine.Step) []string {
+	aliases := []string{step.Metadata.Name}
+	if dns := toDNS(step.Metadata.Name); dns != step.Metadata.Name {
+		aliases = append(aliases, dns)
+	}
+	return aliases
+}
+
+// helper function converts the name to a valid dns name.
+func toDNS(name string) string {
+	return strings.Replace(name, "_", "-", -1)
+}
+
 // helper function that converts a slice of device paths to a slice of
 // container.DeviceMapping.
 func toDeviceSlice(spec *engine.Spec, step *engine.Step) []container.DeviceMapping {
 	var to []container.DeviceMapping
 	for _, mount := range step.Devices {
-		device, ok := engine.LookupVolume(spec, mount.Name)
+		device, ok := engine.LookupStepVolume(spec, step, mount.Name)
 		if !ok {
 			continue
 		}
 		if isDevice(device) == false {
 			continue
 		}
+		permissions := mount.CgroupPermissions
+		if permissions == "" {
+			permissions = "rwm"
+		}
 		to = append(to, container.DeviceMapping{
 			PathOnHost:        device.HostPath.Path,
 			PathInContainer:   mount.DevicePath,
-			CgroupPermissions: "rwm",
+			CgroupPermissions: permissions,
 		})
 	}
 	if len(to) == 0 {
@@ -154,7 +225,7 @@ func toVolumeSlice(spec *engine.Spec, step *engine.Step) []string {
 	// to get it working with data volumes.
 	var to []string
 	for _, mount := range step.Volumes {
-		volume, ok := engine.LookupVolume(spec, mount.Name)
+		volume, ok := engine.LookupStepVolume(spec, step, mount.Name)
 		if !ok {
 			continue
 		}
@@ -175,7 +246,7 @@ func toVolumeSlice(spec *engine.Spec, step *engine.Step) []string {
 func toVolumeMounts(spec *engine.Spec, step *engine.Step) []mount.Mount {
 	var mounts []mount.Mount
 	for _, target := range step.Volumes {
-		source, ok := engine.LookupVolume(spec, target.Name)
+		source, ok := engine.LookupStepVolume(spec, step, target.Name)
 		if !ok {
 			continue
 		}
@@ -228,6 +299,34 @@ func toVolumeType(from *engine.Volume) mount.Type {
 	}
 }
 
+// helper function converts the stop timeout to seconds,
+// rounded up, so that a sub-second timeout does not kill
+// the container immediately.
+func toStopTimeout(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// helper function converts the ulimit declarations to
+// the docker ulimit structure.
+func toUlimits(from []*engine.Ulimit) []*units.Ulimit {
+	var to []*units.Ulimit
+	for _, ulimit := range from {
+		to = append(to, &units.Ulimit{
+			Name: ulimit.Name,
+			Soft: ulimit.Soft,
+			Hard: ulimit.Hard,
+		})
+	}
+	return to
+}
+
+// helper function converts the cpu limit, expressed in
+// millicores for parity with kubernetes, to the docker
+// unit of measure (billionths of a cpu).
+func toNanoCPUs(millicores int64) int64 {
+	return millicores * 1000000
+}
+
 // helper function that converts a key value map of
 // environment variables to a string slice in key=value
 // format.
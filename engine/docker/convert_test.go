@@ -7,12 +7,14 @@ package docker
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/drone/drone-runtime/engine"
 
 	"docker.io/go-docker/api/types/container"
 	"docker.io/go-docker/api/types/mount"
 	"docker.io/go-docker/api/types/network"
+	"github.com/docker/go-units"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -76,6 +78,158 @@ func TestToConfig(t *testing.T) {
 	}
 }
 
+func TestToConfig_User(t *testing.T) {
+	// the docker engine resolves both numeric users and
+	// user names in the image.
+	for _, user := range []string{"1000:1000", "nobody"} {
+		step := &engine.Step{
+			Docker: &engine.DockerStep{Image: "alpine:3.8", User: user},
+		}
+		spec := &engine.Spec{Steps: []*engine.Step{step}}
+		if got, want := toConfig(spec, step).User, user; got != want {
+			t.Errorf("Want user %q, got %q", want, got)
+		}
+	}
+}
+
+func TestToConfig_Timezone(t *testing.T) {
+	step := &engine.Step{
+		Docker:   &engine.DockerStep{Image: "alpine:3.8"},
+		Timezone: "Europe/Madrid",
+	}
+	spec := &engine.Spec{Steps: []*engine.Step{step}}
+	config := toConfig(spec, step)
+	if diff := cmp.Diff(config.Env, []string{"TZ=Europe/Madrid"}); diff != "" {
+		t.Errorf("Unexpected timezone environment")
+		t.Log(diff)
+	}
+
+	// the timezone does not override an explicit TZ
+	// environment variable.
+	step.Envs = map[string]string{"TZ": "UTC"}
+	config = toConfig(spec, step)
+	if diff := cmp.Diff(config.Env, []string{"TZ=UTC"}); diff != "" {
+		t.Errorf("Unexpected timezone environment")
+		t.Log(diff)
+	}
+}
+
+func TestToConfig_StopSignal(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image:       "redis:5",
+			StopSignal:  "SIGINT",
+			StopTimeout: time.Minute,
+		},
+	}
+	spec := &engine.Spec{Steps: []*engine.Step{step}}
+	config := toConfig(spec, step)
+	if got, want := config.StopSignal, "SIGINT"; got != want {
+		t.Errorf("Want stop signal %s, got %s", want, got)
+	}
+	if config.StopTimeout == nil {
+		t.Errorf("Want stop timeout")
+		return
+	}
+	if got, want := *config.StopTimeout, 60; got != want {
+		t.Errorf("Want stop timeout %d, got %d", want, got)
+	}
+}
+
+func TestToConfig_StopTimeoutRounding(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int
+	}{
+		{timeout: 500 * time.Millisecond, want: 1},
+		{timeout: 1500 * time.Millisecond, want: 2},
+		{timeout: 2 * time.Second, want: 2},
+	}
+	for _, test := range tests {
+		step := &engine.Step{
+			Docker: &engine.DockerStep{
+				Image:       "redis:5",
+				StopTimeout: test.timeout,
+			},
+		}
+		spec := &engine.Spec{Steps: []*engine.Step{step}}
+		config := toConfig(spec, step)
+		if config.StopTimeout == nil {
+			t.Errorf("Want stop timeout for %s", test.timeout)
+			continue
+		}
+		if got := *config.StopTimeout; got != test.want {
+			t.Errorf("Want stop timeout %d for %s, got %d", test.want, test.timeout, got)
+		}
+	}
+}
+
+func TestToConfig_TTY(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image: "alpine:3.8",
+			TTY:   true,
+			Stdin: true,
+		},
+	}
+	spec := &engine.Spec{Steps: []*engine.Step{step}}
+	config := toConfig(spec, step)
+	if !config.Tty {
+		t.Errorf("Want container tty")
+	}
+	if !config.OpenStdin || !config.AttachStdin || !config.StdinOnce {
+		t.Errorf("Want container stdin open and attached")
+	}
+}
+
+func TestToConfig_TTYWithoutStdin(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image: "alpine:3.8",
+			TTY:   true,
+		},
+	}
+	spec := &engine.Spec{Steps: []*engine.Step{step}}
+	config := toConfig(spec, step)
+	if !config.Tty {
+		t.Errorf("Want container tty")
+	}
+	// a terminal requires the container input to be open.
+	if !config.OpenStdin || !config.AttachStdin || !config.StdinOnce {
+		t.Errorf("Want container stdin with tty")
+	}
+}
+
+func TestToConfig_Healthcheck(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image: "postgres:9",
+			Healthcheck: &engine.Healthcheck{
+				Test:        []string{"CMD", "pg_isready"},
+				Interval:    time.Second,
+				Timeout:     5 * time.Second,
+				StartPeriod: 10 * time.Second,
+				Retries:     3,
+			},
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+	}
+	a := toConfig(spec, step).Healthcheck
+	b := &container.HealthConfig{
+		Test:        []string{"CMD", "pg_isready"},
+		Interval:    time.Second,
+		Timeout:     5 * time.Second,
+		StartPeriod: 10 * time.Second,
+		Retries:     3,
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("Unexpected healthcheck")
+		t.Log(diff)
+	}
+}
+
 func TestToHostConfig(t *testing.T) {
 	step := &engine.Step{
 		Metadata: engine.Metadata{
@@ -156,6 +310,219 @@ func TestToHostConfig(t *testing.T) {
 	}
 }
 
+func TestToHostConfig_Resources(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image: "golang:latest",
+		},
+		Resources: &engine.Resources{
+			Limits: &engine.ResourceObject{
+				Memory: 536870912, // 512Mi
+				CPU:    500,       // 500m
+			},
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+	}
+	config := toHostConfig(spec, step)
+	if got, want := config.Resources.Memory, int64(536870912); got != want {
+		t.Errorf("Want memory limit %d, got %d", want, got)
+	}
+	if got, want := config.Resources.NanoCPUs, int64(500000000); got != want {
+		t.Errorf("Want nano cpus %d, got %d", want, got)
+	}
+}
+
+func TestToHostConfig_Ulimits(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image: "golang:latest",
+			Ulimits: []*engine.Ulimit{
+				{Name: "nofile", Soft: 65536, Hard: 65536},
+			},
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+	}
+	a := toHostConfig(spec, step).Resources.Ulimits
+	b := []*units.Ulimit{
+		{Name: "nofile", Soft: 65536, Hard: 65536},
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("Unexpected ulimits")
+		t.Log(diff)
+	}
+}
+
+func TestToHostConfig_Devices(t *testing.T) {
+	step := &engine.Step{
+		Devices: []*engine.VolumeDevice{
+			{Name: "kvm", DevicePath: "/dev/kvm"},
+			{Name: "card0", DevicePath: "/dev/dri/card1", CgroupPermissions: "rw"},
+		},
+		Docker: &engine.DockerStep{
+			Image: "golang:latest",
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+		Docker: &engine.DockerConfig{
+			Volumes: []*engine.Volume{
+				{
+					Metadata: engine.Metadata{Name: "kvm"},
+					HostPath: &engine.VolumeHostPath{Path: "/dev/kvm"},
+				},
+				{
+					Metadata: engine.Metadata{Name: "card0"},
+					HostPath: &engine.VolumeHostPath{Path: "/dev/dri/card0"},
+				},
+			},
+		},
+	}
+	a := toHostConfig(spec, step).Devices
+	b := []container.DeviceMapping{
+		{PathOnHost: "/dev/kvm", PathInContainer: "/dev/kvm", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/dri/card0", PathInContainer: "/dev/dri/card1", CgroupPermissions: "rw"},
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("Unexpected device mappings")
+		t.Log(diff)
+	}
+}
+
+func TestToHostConfig_LogConfig(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image: "golang:latest",
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+		Docker: &engine.DockerConfig{
+			LogConfig: &engine.LogConfig{
+				Type: "json-file",
+				Options: map[string]string{
+					"max-size": "10m",
+					"max-file": "3",
+				},
+			},
+		},
+	}
+	a := toHostConfig(spec, step).LogConfig
+	b := container.LogConfig{
+		Type: "json-file",
+		Config: map[string]string{
+			"max-size": "10m",
+			"max-file": "3",
+		},
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("Unexpected log config")
+		t.Log(diff)
+	}
+}
+
+func TestToHostConfig_RestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy engine.RestartPolicy
+		result container.RestartPolicy
+	}{
+		{
+			policy: engine.RestartNo,
+			result: container.RestartPolicy{},
+		},
+		{
+			policy: engine.RestartOnFailure,
+			result: container.RestartPolicy{Name: "on-failure"},
+		},
+		{
+			policy: engine.RestartUnlessStopped,
+			result: container.RestartPolicy{Name: "unless-stopped"},
+		},
+	}
+	for _, test := range tests {
+		step := &engine.Step{
+			Docker: &engine.DockerStep{
+				Image:   "redis",
+				Restart: test.policy,
+			},
+		}
+		spec := &engine.Spec{
+			Steps: []*engine.Step{step},
+		}
+		if got, want := toHostConfig(spec, step).RestartPolicy, test.result; got != want {
+			t.Errorf("Want restart policy %v, got %v", want, got)
+		}
+	}
+}
+
+func TestToHostConfig_HostAliases(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image:      "golang:latest",
+			ExtraHosts: []string{"host.company.com:10.0.0.1"},
+		},
+		HostAliases: []*engine.HostAlias{
+			{IP: "10.0.0.2", Hostnames: []string{"foo.local", "bar.local"}},
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+	}
+	a := toHostConfig(spec, step).ExtraHosts
+	b := []string{
+		"host.company.com:10.0.0.1",
+		"foo.local:10.0.0.2",
+		"bar.local:10.0.0.2",
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("Unexpected extra hosts")
+		t.Log(diff)
+	}
+}
+
+func TestToHostConfig_Tmpfs(t *testing.T) {
+	step := &engine.Step{
+		Docker: &engine.DockerStep{
+			Image: "golang:latest",
+		},
+		Volumes: []*engine.VolumeMount{
+			{Name: "cache", Path: "/cache"},
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+		Docker: &engine.DockerConfig{
+			Volumes: []*engine.Volume{
+				{
+					Metadata: engine.Metadata{Name: "cache", UID: "1"},
+					EmptyDir: &engine.VolumeEmptyDir{
+						Medium:    "memory",
+						SizeLimit: 67108864,
+					},
+				},
+			},
+		},
+	}
+	a := toHostConfig(spec, step).Mounts
+	b := []mount.Mount{
+		{
+			Type:   mount.TypeTmpfs,
+			Target: "/cache",
+			TmpfsOptions: &mount.TmpfsOptions{
+				SizeBytes: 67108864,
+				Mode:      0700,
+			},
+		},
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("Unexpected tmpfs mount")
+		t.Log(diff)
+	}
+}
+
 func TestToNetConfig(t *testing.T) {
 	step := &engine.Step{
 		Metadata: engine.Metadata{
@@ -182,6 +549,26 @@ func TestToNetConfig(t *testing.T) {
 	}
 }
 
+func TestToNetConfig_Aliases(t *testing.T) {
+	step := &engine.Step{
+		Metadata: engine.Metadata{
+			Name: "redis_cache",
+		},
+	}
+	spec := &engine.Spec{
+		Metadata: engine.Metadata{
+			UID: "abc123",
+		},
+		Steps: []*engine.Step{step},
+	}
+	a := toNetConfig(spec, step).EndpointsConfig["abc123"].Aliases
+	b := []string{"redis_cache", "redis-cache"}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("Unexpected network aliases")
+		t.Log(diff)
+	}
+}
+
 func TestToVolumeSlice(t *testing.T) {
 	step := &engine.Step{
 		Volumes: []*engine.VolumeMount{
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"github.com/drone/drone-runtime/engine"
 	"github.com/drone/drone-runtime/engine/docker/auth"
@@ -32,6 +33,10 @@ import (
 	"docker.io/go-docker/api/types/volume"
 )
 
+// healthcheckInterval defines the interval at which the
+// container health status is polled.
+var healthcheckInterval = time.Second
+
 type dockerEngine struct {
 	client docker.APIClient
 }
@@ -47,15 +52,36 @@ func NewEnv() (engine.Engine, error) {
 
 // Ping attempts to ping the Docker daemon. An error is returned
 // if the ping attempt fails.
-func Ping(ctx context.Context, engine engine.Engine) error {
-	eng, ok := engine.(*dockerEngine)
+func Ping(ctx context.Context, eng engine.Engine) error {
+	e, ok := engine.Unwrap(eng).(*dockerEngine)
 	if !ok {
 		return fmt.Errorf("Not a valid Engine type")
 	}
-	_, err := eng.client.Ping(ctx)
+	_, err := e.client.Ping(ctx)
 	return err
 }
 
+// Inspect returns the configured entrypoint and command of
+// the image. The image must exist on the host, which is the
+// case once the step container is created.
+func Inspect(ctx context.Context, eng engine.Engine, spec *engine.Spec, image string) (*engine.ImageConfig, error) {
+	e, ok := engine.Unwrap(eng).(*dockerEngine)
+	if !ok {
+		return nil, fmt.Errorf("Not a valid Engine type")
+	}
+	info, _, err := e.client.ImageInspectWithRaw(ctx, engine.MirrorImage(spec, image))
+	if err != nil {
+		return nil, err
+	}
+	if info.Config == nil {
+		return &engine.ImageConfig{}, nil
+	}
+	return &engine.ImageConfig{
+		Entrypoint: info.Config.Entrypoint,
+		Cmd:        info.Config.Cmd,
+	}, nil
+}
+
 // New returns a new Engine using the Docker API Client.
 func New(client docker.APIClient) engine.Engine {
 	return &dockerEngine{
@@ -105,13 +131,27 @@ func (e *dockerEngine) Create(ctx context.Context, spec *engine.Spec, step *engi
 	// parse the docker image name. We need to extract the
 	// image domain name and match to registry credentials
 	// stored in the .docker/config.json object.
-	_, domain, latest, err := parseImage(step.Docker.Image)
+	// the image is rewritten to use the registry mirror,
+	// if configured, before matching credentials.
+	image := engine.MirrorImage(spec, step.Docker.Image)
+	_, domain, latest, err := parseImage(image)
 	if err != nil {
 		return err
 	}
 
 	// create pull options with encoded authorization credentials.
 	pullopts := types.ImagePullOptions{}
+
+	// the platform is forwarded to the pull request so that
+	// the daemon pulls the requested variant of a multi-arch
+	// image. note that this version of the docker api does
+	// not accept a platform when creating the container.
+	if step.Docker.Platform != "" {
+		if err := validatePlatform(step.Docker.Platform); err != nil {
+			return err
+		}
+		pullopts.Platform = step.Docker.Platform
+	}
 	auths, ok := engine.LookupAuth(spec, domain)
 	if ok {
 		pullopts.RegistryAuth = auth.Encode(auths.Username, auths.Password)
@@ -123,13 +163,13 @@ func (e *dockerEngine) Create(ctx context.Context, spec *engine.Spec, step *engi
 		(step.Docker.PullPolicy == engine.PullDefault && latest) {
 		// TODO(bradrydzewski) implement the PullDefault strategy to pull
 		// the image if the tag is :latest
-		rc, perr := e.client.ImagePull(ctx, step.Docker.Image, pullopts)
+		rc, perr := e.client.ImagePull(ctx, image, pullopts)
 		if perr == nil {
 			io.Copy(ioutil.Discard, rc)
 			rc.Close()
 		}
 		if perr != nil {
-			return perr
+			return engine.WrapError(engine.ErrImagePull, perr)
 		}
 	}
 
@@ -140,12 +180,19 @@ func (e *dockerEngine) Create(ctx context.Context, spec *engine.Spec, step *engi
 		step.Metadata.UID,
 	)
 
+	// if the image does not exist and the pull policy
+	// prevents pulling, we return a descriptive error.
+	if docker.IsErrImageNotFound(err) && step.Docker.PullPolicy == engine.PullNever {
+		return engine.WrapError(engine.ErrImagePull,
+			fmt.Errorf("engine: image %s not found and pull policy is never", image))
+	}
+
 	// automatically pull and try to re-create the image if the
 	// failure is caused because the image does not exist.
 	if docker.IsErrImageNotFound(err) && step.Docker.PullPolicy != engine.PullNever {
-		rc, perr := e.client.ImagePull(ctx, step.Docker.Image, pullopts)
+		rc, perr := e.client.ImagePull(ctx, image, pullopts)
 		if perr != nil {
-			return perr
+			return engine.WrapError(engine.ErrImagePull, perr)
 		}
 		io.Copy(ioutil.Discard, rc)
 		rc.Close()
@@ -192,7 +239,47 @@ func (e *dockerEngine) Create(ctx context.Context, spec *engine.Spec, step *engi
 }
 
 func (e *dockerEngine) Start(ctx context.Context, spec *engine.Spec, step *engine.Step) error {
-	return e.client.ContainerStart(ctx, step.Metadata.UID, types.ContainerStartOptions{})
+	err := e.client.ContainerStart(ctx, step.Metadata.UID, types.ContainerStartOptions{})
+	if err != nil {
+		return err
+	}
+	// if the container defines a healthcheck we block until
+	// the container is healthy, so that dependent steps do
+	// not start before the service is ready.
+	if step.Docker.Healthcheck != nil {
+		return e.waitHealthy(ctx, step)
+	}
+	return nil
+}
+
+// helper function blocks until the container reports a
+// healthy status. An error is returned if the container
+// is unhealthy or exits.
+func (e *dockerEngine) waitHealthy(ctx context.Context, step *engine.Step) error {
+	for {
+		info, err := e.client.ContainerInspect(ctx, step.Metadata.UID)
+		if err != nil {
+			return err
+		}
+		if info.State != nil {
+			if !info.State.Running {
+				return fmt.Errorf("engine: %s exited before it was healthy", step.Metadata.Name)
+			}
+			if info.State.Health != nil {
+				switch info.State.Health.Status {
+				case types.Healthy:
+					return nil
+				case types.Unhealthy:
+					return fmt.Errorf("engine: %s is unhealthy", step.Metadata.Name)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthcheckInterval):
+		}
+	}
 }
 
 func (e *dockerEngine) Wait(ctx context.Context, spec *engine.Spec, step *engine.Step) (*engine.State, error) {
@@ -201,6 +288,9 @@ func (e *dockerEngine) Wait(ctx context.Context, spec *engine.Spec, step *engine
 	case <-wait:
 	case <-errc:
 	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, engine.WrapError(engine.ErrTimeout, ctx.Err())
+	}
 
 	info, err := e.client.ContainerInspect(ctx, step.Metadata.UID)
 	if err != nil {
@@ -234,12 +324,18 @@ func (e *dockerEngine) Tail(ctx context.Context, spec *engine.Spec, step *engine
 	rc, wc := io.Pipe()
 
 	go func() {
-		stdcopy.StdCopy(wc, wc, logs)
+		// the output of a container with a terminal is
+		// not multiplexed, and is copied as-is.
+		if step.Docker != nil && step.Docker.TTY {
+			io.Copy(wc, logs)
+		} else {
+			stdcopy.StdCopy(wc, wc, logs)
+		}
 		logs.Close()
 		wc.Close()
 		rc.Close()
 	}()
-	return rc, nil
+	return engine.LimitLogs(rc, spec.LogLimitBytes), nil
 }
 
 func (e *dockerEngine) Destroy(ctx context.Context, spec *engine.Spec) error {
@@ -249,8 +345,19 @@ func (e *dockerEngine) Destroy(ctx context.Context, spec *engine.Spec) error {
 		RemoveVolumes: true,
 	}
 
-	// stop all containers
+	// stop all containers. containers with a custom stop
+	// signal or timeout are stopped gracefully, and killed
+	// once the timeout expires.
 	for _, step := range spec.Steps {
+		if step.Docker != nil && (step.Docker.StopSignal != "" || step.Docker.StopTimeout > 0) {
+			var timeout *time.Duration
+			if step.Docker.StopTimeout > 0 {
+				seconds := time.Duration(toStopTimeout(step.Docker.StopTimeout)) * time.Second
+				timeout = &seconds
+			}
+			e.client.ContainerStop(ctx, step.Metadata.UID, timeout)
+			continue
+		}
 		e.client.ContainerKill(ctx, step.Metadata.UID, "9")
 	}
 
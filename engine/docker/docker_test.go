@@ -3,3 +3,427 @@
 // that can be found in the LICENSE file.
 
 package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+	"github.com/drone/drone-runtime/engine/docker/auth"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
+	"docker.io/go-docker/api/types/network"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeClient implements a subset of the Docker client
+// for testing purposes. Calling any method that is not
+// overridden panics.
+type fakeClient struct {
+	docker.APIClient
+
+	networks        map[string]types.NetworkCreate
+	removedNetworks []string
+	pulls           []types.ImagePullOptions
+	created         []*container.Config
+	health          []string
+	missing         bool
+	inspected       []string
+	stopped         []string
+	killed          []string
+	oomKilled       bool
+}
+
+func (c *fakeClient) ContainerWait(ctx context.Context, id string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	wait := make(chan container.ContainerWaitOKBody, 1)
+	wait <- container.ContainerWaitOKBody{}
+	return wait, make(chan error)
+}
+
+func (c *fakeClient) ContainerStart(ctx context.Context, id string, options types.ContainerStartOptions) error {
+	return nil
+}
+
+// ContainerInspect returns a running container, reporting
+// the next queued health status on each invocation.
+func (c *fakeClient) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	state := &types.ContainerState{Running: true}
+	if c.oomKilled {
+		state = &types.ContainerState{ExitCode: 137, OOMKilled: true}
+	}
+	if len(c.health) != 0 {
+		state.Health = &types.Health{Status: c.health[0]}
+		c.health = c.health[1:]
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    id,
+			State: state,
+		},
+	}, nil
+}
+
+// errNotFound implements the docker not found error.
+type errNotFound struct{}
+
+func (errNotFound) Error() string  { return "No such image" }
+func (errNotFound) NotFound() bool { return true }
+
+func (c *fakeClient) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	c.pulls = append(c.pulls, options)
+	c.missing = false
+	return ioutil.NopCloser(new(bytes.Buffer)), nil
+}
+
+// ContainerCreate returns a not found error if the image
+// is missing and has not been pulled.
+func (c *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, name string) (container.ContainerCreateCreatedBody, error) {
+	if c.missing {
+		return container.ContainerCreateCreatedBody{}, errNotFound{}
+	}
+	c.created = append(c.created, config)
+	return container.ContainerCreateCreatedBody{ID: name}, nil
+}
+
+func (c *fakeClient) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	if c.networks == nil {
+		c.networks = map[string]types.NetworkCreate{}
+	}
+	c.networks[name] = options
+	return types.NetworkCreateResponse{ID: name}, nil
+}
+
+func (c *fakeClient) NetworkRemove(ctx context.Context, name string) error {
+	c.removedNetworks = append(c.removedNetworks, name)
+	return nil
+}
+
+func (c *fakeClient) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	c.inspected = append(c.inspected, image)
+	return types.ImageInspect{
+		Config: &container.Config{
+			Entrypoint: []string{"/bin/sh", "-c"},
+			Cmd:        []string{"echo hello"},
+		},
+	}, nil, nil
+}
+
+func (c *fakeClient) ContainerKill(ctx context.Context, id, signal string) error {
+	c.killed = append(c.killed, id)
+	return nil
+}
+
+func (c *fakeClient) ContainerStop(ctx context.Context, id string, timeout *time.Duration) error {
+	c.stopped = append(c.stopped, id)
+	return nil
+}
+
+func (c *fakeClient) ContainerRemove(ctx context.Context, id string, options types.ContainerRemoveOptions) error {
+	return nil
+}
+
+func TestSetup_Network(t *testing.T) {
+	client := new(fakeClient)
+	spec := &engine.Spec{
+		Metadata: engine.Metadata{UID: "abc123"},
+	}
+	err := New(client).Setup(context.Background(), spec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	network, ok := client.networks["abc123"]
+	if !ok {
+		t.Errorf("Expect network created for the build")
+		return
+	}
+	if got, want := network.Driver, "bridge"; got != want {
+		t.Errorf("Want network driver %s, got %s", want, got)
+	}
+}
+
+func TestDestroy_Network(t *testing.T) {
+	client := new(fakeClient)
+	spec := &engine.Spec{
+		Metadata: engine.Metadata{UID: "abc123"},
+	}
+	err := New(client).Destroy(context.Background(), spec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(client.removedNetworks) != 1 || client.removedNetworks[0] != "abc123" {
+		t.Errorf("Expect network removed, got %v", client.removedNetworks)
+	}
+}
+
+func TestDestroy_StopSignal(t *testing.T) {
+	client := new(fakeClient)
+	spec := &engine.Spec{
+		Metadata: engine.Metadata{UID: "abc123"},
+		Steps: []*engine.Step{
+			{
+				Metadata: engine.Metadata{UID: "redis"},
+				Docker: &engine.DockerStep{
+					StopSignal:  "SIGINT",
+					StopTimeout: time.Minute,
+				},
+			},
+			{
+				Metadata: engine.Metadata{UID: "build"},
+				Docker:   &engine.DockerStep{},
+			},
+		},
+	}
+	if err := New(client).Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(client.stopped, []string{"redis"}); diff != "" {
+		t.Errorf("Expect container with stop signal stopped gracefully")
+		t.Log(diff)
+	}
+	if diff := cmp.Diff(client.killed, []string{"build"}); diff != "" {
+		t.Errorf("Expect container without stop signal killed")
+		t.Log(diff)
+	}
+}
+
+func TestCreate_Platform(t *testing.T) {
+	client := new(fakeClient)
+	step := &engine.Step{
+		Metadata: engine.Metadata{UID: "123"},
+		Docker: &engine.DockerStep{
+			Image:      "golang:1.11",
+			Platform:   "linux/amd64",
+			PullPolicy: engine.PullAlways,
+		},
+	}
+	spec := &engine.Spec{
+		Metadata: engine.Metadata{UID: "abc123"},
+		Steps:    []*engine.Step{step},
+	}
+	err := New(client).Create(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(client.pulls) != 1 {
+		t.Errorf("Expect image pulled")
+		return
+	}
+	if got, want := client.pulls[0].Platform, "linux/amd64"; got != want {
+		t.Errorf("Want pull platform %s, got %s", want, got)
+	}
+}
+
+func TestCreate_InvalidPlatform(t *testing.T) {
+	client := new(fakeClient)
+	step := &engine.Step{
+		Metadata: engine.Metadata{UID: "123"},
+		Docker: &engine.DockerStep{
+			Image:    "golang:1.11",
+			Platform: "amd64",
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+	}
+	err := New(client).Create(context.Background(), spec, step)
+	if err == nil {
+		t.Errorf("Expect invalid platform error")
+	}
+	if len(client.created) != 0 {
+		t.Errorf("Expect container not created")
+	}
+}
+
+func TestStart_Healthy(t *testing.T) {
+	healthcheckInterval = time.Millisecond
+	defer func() {
+		healthcheckInterval = time.Second
+	}()
+
+	client := &fakeClient{
+		health: []string{types.Starting, types.Starting, types.Healthy},
+	}
+	step := &engine.Step{
+		Metadata: engine.Metadata{UID: "123", Name: "database"},
+		Docker: &engine.DockerStep{
+			Image: "postgres:9",
+			Healthcheck: &engine.Healthcheck{
+				Test: []string{"CMD", "pg_isready"},
+			},
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+	}
+	err := New(client).Start(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(client.health) != 0 {
+		t.Errorf("Expect engine to wait for healthy status")
+	}
+}
+
+func TestStart_Unhealthy(t *testing.T) {
+	healthcheckInterval = time.Millisecond
+	defer func() {
+		healthcheckInterval = time.Second
+	}()
+
+	client := &fakeClient{
+		health: []string{types.Starting, types.Unhealthy},
+	}
+	step := &engine.Step{
+		Metadata: engine.Metadata{UID: "123", Name: "database"},
+		Docker: &engine.DockerStep{
+			Image: "postgres:9",
+			Healthcheck: &engine.Healthcheck{
+				Test: []string{"CMD", "pg_isready"},
+			},
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+	}
+	err := New(client).Start(context.Background(), spec, step)
+	if err == nil {
+		t.Errorf("Expect unhealthy error")
+	}
+}
+
+func TestCreate_PullPolicy(t *testing.T) {
+	tests := []struct {
+		policy  engine.PullPolicy
+		image   string
+		missing bool
+		pulls   int
+		err     bool
+	}{
+		// always pull, even if the image exists
+		{policy: engine.PullAlways, image: "golang:1.11", pulls: 1},
+		// pull if the image does not exist
+		{policy: engine.PullIfNotExists, image: "golang:1.11", pulls: 0},
+		{policy: engine.PullIfNotExists, image: "golang:1.11", missing: true, pulls: 1},
+		// never pull, and error if the image does not exist
+		{policy: engine.PullNever, image: "golang:1.11", pulls: 0},
+		{policy: engine.PullNever, image: "golang:1.11", missing: true, pulls: 0, err: true},
+		// default pulls the image if the tag is latest
+		{policy: engine.PullDefault, image: "golang:latest", pulls: 1},
+		{policy: engine.PullDefault, image: "golang:1.11", pulls: 0},
+	}
+	for i, test := range tests {
+		client := &fakeClient{missing: test.missing}
+		step := &engine.Step{
+			Metadata: engine.Metadata{UID: "123"},
+			Docker: &engine.DockerStep{
+				Image:      test.image,
+				PullPolicy: test.policy,
+			},
+		}
+		spec := &engine.Spec{
+			Steps: []*engine.Step{step},
+		}
+		err := New(client).Create(context.Background(), spec, step)
+		if got, want := err != nil, test.err; got != want {
+			t.Errorf("Want error %v, got %v at index %d", want, err, i)
+		}
+		if test.err && !errors.Is(err, engine.ErrImagePull) {
+			t.Errorf("Want error %v, got %v at index %d", engine.ErrImagePull, err, i)
+		}
+		if got, want := len(client.pulls), test.pulls; got != want {
+			t.Errorf("Want %d image pulls, got %d at index %d", want, got, i)
+		}
+	}
+}
+
+func TestCreate_PullAuth(t *testing.T) {
+	client := new(fakeClient)
+	step := &engine.Step{
+		Metadata: engine.Metadata{UID: "123"},
+		Docker: &engine.DockerStep{
+			Image:      "octocat/hello-world",
+			PullPolicy: engine.PullAlways,
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{step},
+		Docker: &engine.DockerConfig{
+			Auths: []*engine.DockerAuth{
+				{
+					Address:  "https://index.docker.io/v1/",
+					Username: "octocat",
+					Password: "correct-horse-battery-staple",
+				},
+			},
+		},
+	}
+	err := New(client).Create(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(client.pulls) != 1 {
+		t.Errorf("Expect image pulled")
+		return
+	}
+	want := auth.Encode("octocat", "correct-horse-battery-staple")
+	if got := client.pulls[0].RegistryAuth; got != want {
+		t.Errorf("Want registry auth %s, got %s", want, got)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	client := new(fakeClient)
+	spec := &engine.Spec{
+		Docker: &engine.DockerConfig{
+			Mirrors: []*engine.Mirror{
+				{Prefix: "docker.io", Mirror: "mirror.company.com"},
+			},
+		},
+	}
+	config, err := Inspect(context.Background(), New(client), spec, "alpine:3.8")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := &engine.ImageConfig{
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Cmd:        []string{"echo hello"},
+	}
+	if diff := cmp.Diff(config, want); diff != "" {
+		t.Errorf("Unexpected image config")
+		t.Log(diff)
+	}
+	if got, want := client.inspected, []string{"mirror.company.com/library/alpine:3.8"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("Want inspected image %v, got %v", want, got)
+	}
+}
+
+func TestWait_OOMKilled(t *testing.T) {
+	client := &fakeClient{oomKilled: true}
+	step := &engine.Step{
+		Metadata: engine.Metadata{UID: "uid_8a7IJsL9zSJCCchd"},
+	}
+	state, err := New(client).Wait(context.Background(), new(engine.Spec), step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := state.ExitCode, 137; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if !errors.Is(state.Err(), engine.ErrOOMKilled) {
+		t.Errorf("Want error %v, got %v", engine.ErrOOMKilled, state.Err())
+	}
+}
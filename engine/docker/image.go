@@ -15,6 +15,7 @@
 package docker
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/docker/distribution/reference"
@@ -42,3 +43,18 @@ func parseImage(s string) (canonical, domain string, latest bool, err error) {
 		strings.HasSuffix(named.String(), ":latest"),
 		nil
 }
+
+// helper function validates the image platform is in
+// os/arch[/variant] format (e.g. linux/arm64/v8).
+func validatePlatform(s string) error {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("engine: invalid platform %q: expected os/arch[/variant]", s)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return fmt.Errorf("engine: invalid platform %q: expected os/arch[/variant]", s)
+		}
+	}
+	return nil
+}
@@ -54,3 +54,22 @@ func TestParseImage(t *testing.T) {
 		}
 	}
 }
+
+func TestValidatePlatform(t *testing.T) {
+	tests := []struct {
+		platform string
+		valid    bool
+	}{
+		{platform: "linux/amd64", valid: true},
+		{platform: "linux/arm64/v8", valid: true},
+		{platform: "linux", valid: false},
+		{platform: "linux/", valid: false},
+		{platform: "linux/arm/v7/extra", valid: false},
+	}
+	for _, test := range tests {
+		err := validatePlatform(test.platform)
+		if got, want := err == nil, test.valid; got != want {
+			t.Errorf("Want platform %s valid %v, got %v", test.platform, want, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "errors"
+
+// Error kinds returned by the engines, which can be tested
+// using errors.Is to distinguish failures without matching
+// the error message.
+var (
+	// ErrImagePull is returned when the step image cannot
+	// be pulled, or is not found.
+	ErrImagePull = errors.New("engine: cannot pull image")
+
+	// ErrForbidden is returned when the engine is not
+	// permitted to create a resource.
+	ErrForbidden = errors.New("engine: forbidden")
+
+	// ErrTimeout is returned when the step exceeds its
+	// timeout.
+	ErrTimeout = errors.New("engine: timeout")
+
+	// ErrOOMKilled is returned when the step container is
+	// killed because it ran out of memory.
+	ErrOOMKilled = errors.New("engine: out of memory")
+)
+
+// Error is an engine error of a known kind. The error
+// message is the message of the underlying error.
+type Error struct {
+	Kind error // Error kind, such as ErrTimeout
+	Err  error // Underlying error
+}
+
+// WrapError returns the error annotated with the error
+// kind. A nil error returns nil.
+func WrapError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// Error returns the error message in string format.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if the target is the error kind.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Err returns an ErrOOMKilled error if the container was
+// killed because it ran out of memory, else nil. A step
+// that is killed exits with a non-zero exit code, and is
+// therefore not reported as an error by Wait.
+func (s *State) Err() error {
+	if s.OOMKilled {
+		return WrapError(ErrOOMKilled, errors.New("engine: container killed: out of memory"))
+	}
+	return nil
+}
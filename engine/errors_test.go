@@ -0,0 +1,41 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWrapError(t *testing.T) {
+	err := WrapError(ErrTimeout, context.DeadlineExceeded)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expect error is ErrTimeout")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expect error wraps the underlying error")
+	}
+	if errors.Is(err, ErrForbidden) {
+		t.Errorf("Expect error is not ErrForbidden")
+	}
+	if got, want := err.Error(), context.DeadlineExceeded.Error(); got != want {
+		t.Errorf("Want error message %q, got %q", want, got)
+	}
+	if WrapError(ErrTimeout, nil) != nil {
+		t.Errorf("Expect nil error wraps to nil")
+	}
+}
+
+func TestState_Err(t *testing.T) {
+	state := &State{ExitCode: 137, Exited: true, OOMKilled: true}
+	if !errors.Is(state.Err(), ErrOOMKilled) {
+		t.Errorf("Expect oom killed state error is ErrOOMKilled")
+	}
+	state.OOMKilled = false
+	if state.Err() != nil {
+		t.Errorf("Expect no state error")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ImageConfig defines the configured entrypoint and
+// command of an image.
+type ImageConfig struct {
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+}
+
+// ImageInspector fetches the configuration of an image,
+// which is used to debug steps that define no command and
+// therefore rely on the image entrypoint.
+type ImageInspector interface {
+	// Inspect returns the configuration of the image. The
+	// credentials are nil if no credentials are configured
+	// for the registry.
+	Inspect(ctx context.Context, image string, auth *DockerAuth) (*ImageConfig, error)
+}
+
+// InspectImage returns the configuration of the image using
+// the registry credentials and mirrors defined in the spec.
+func InspectImage(ctx context.Context, spec *Spec, inspector ImageInspector, image string) (*ImageConfig, error) {
+	image = MirrorImage(spec, image)
+	domain, _, _ := splitImage(strings.SplitN(image, "@", 2)[0])
+	auth, _ := LookupAuth(spec, domain)
+	config, err := inspector.Inspect(ctx, image, auth)
+	if err != nil {
+		return nil, fmt.Errorf("engine: cannot inspect image %s: %s", image, err)
+	}
+	return config, nil
+}
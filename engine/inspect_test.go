@@ -0,0 +1,51 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInspectImage(t *testing.T) {
+	spec := &Spec{
+		Docker: &DockerConfig{
+			Auths: []*DockerAuth{
+				{Address: "mirror.company.com", Username: "octocat", Password: "correct-horse-battery-staple"},
+			},
+			Mirrors: []*Mirror{
+				{Prefix: "docker.io", Mirror: "mirror.company.com"},
+			},
+		},
+	}
+	inspector := &mockImageInspector{
+		config: &ImageConfig{Entrypoint: []string{"/bin/sh"}},
+	}
+	config, err := InspectImage(context.Background(), spec, inspector, "alpine:3.8")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(config.Entrypoint) != 1 || config.Entrypoint[0] != "/bin/sh" {
+		t.Errorf("Want entrypoint /bin/sh, got %v", config.Entrypoint)
+	}
+	if got, want := inspector.image, "mirror.company.com/library/alpine:3.8"; got != want {
+		t.Errorf("Want mirrored image %s, got %s", want, got)
+	}
+	if inspector.auth == nil || inspector.auth.Username != "octocat" {
+		t.Errorf("Expect registry credentials passed to inspector")
+	}
+}
+
+type mockImageInspector struct {
+	config *ImageConfig
+	image  string
+	auth   *DockerAuth
+}
+
+func (i *mockImageInspector) Inspect(_ context.Context, image string, auth *DockerAuth) (*ImageConfig, error) {
+	i.image, i.auth = image, auth
+	return i.config, nil
+}
@@ -9,8 +9,6 @@ import (
 
 	"github.com/drone/drone-runtime/engine"
 	"github.com/ghodss/yaml"
-	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -42,19 +40,23 @@ func Print(spec *engine.Spec) string {
 	//
 
 	for _, file := range spec.Files {
-		res := &v1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: file.Metadata.UID,
-			},
-			Data: map[string]string{
-				file.Metadata.UID: string(file.Data),
-			},
+		if file.Secret {
+			for _, res := range toFileSecrets(file) {
+				res.Namespace = spec.Metadata.Namespace
+				res.Kind = "Secret"
+				buf.WriteString(documentBegin)
+				raw, _ := yaml.Marshal(res)
+				buf.Write(raw)
+			}
+			continue
+		}
+		for _, res := range toConfigMaps(file) {
+			res.Namespace = spec.Metadata.Namespace
+			res.Kind = "ConfigMap"
+			buf.WriteString(documentBegin)
+			raw, _ := yaml.Marshal(res)
+			buf.Write(raw)
 		}
-		res.Namespace = spec.Metadata.Namespace
-		res.Kind = "ConfigMap"
-		buf.WriteString(documentBegin)
-		raw, _ := yaml.Marshal(res)
-		buf.Write(raw)
 	}
 
 	//
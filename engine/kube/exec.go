@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// executor executes a command in a pod container,
+// streaming the input and output.
+type executor func(namespace, pod string, opts *v1.PodExecOptions, streams remotecommand.StreamOptions) error
+
+// helper function returns an executor that streams the
+// command using the pod exec subresource.
+func newExecutor(client kubernetes.Interface, config *rest.Config) executor {
+	return func(namespace, pod string, opts *v1.PodExecOptions, streams remotecommand.StreamOptions) error {
+		req := client.CoreV1().RESTClient().Post().
+			Namespace(namespace).
+			Name(pod).
+			Resource("pods").
+			SubResource("exec").
+			VersionedParams(opts, scheme.ParameterCodec)
+		exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+		if err != nil {
+			return err
+		}
+		return exec.Stream(streams)
+	}
+}
+
+// Exec executes the command in the running step container,
+// attaching the provided input and output streams. This can
+// be used to debug a running step, and is independent of
+// the step lifecycle.
+func Exec(ctx context.Context, eng engine.Engine, spec *engine.Spec, step string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return fmt.Errorf("Not a valid Engine type")
+	}
+	if e.exec == nil {
+		return fmt.Errorf("kubernetes: exec is not supported")
+	}
+	pod, err := e.client.CoreV1().Pods(spec.Metadata.Namespace).Get(step, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pod.Status.Phase != v1.PodRunning {
+		return fmt.Errorf("kubernetes: step %s is not running", step)
+	}
+	opts := &v1.PodExecOptions{
+		Container: step,
+		Command:   command,
+		Stdin:     stdin != nil,
+		Stdout:    stdout != nil,
+		Stderr:    stderr != nil,
+	}
+	return e.exec(pod.Namespace, pod.Name, opts, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestExec(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status.Phase = v1.PodRunning
+
+	var namespace, name string
+	var opts *v1.PodExecOptions
+	e := &kubeEngine{
+		client: fake.NewSimpleClientset(pod),
+		exec: func(ns, pod string, o *v1.PodExecOptions, streams remotecommand.StreamOptions) error {
+			namespace, name, opts = ns, pod, o
+			streams.Stdout.Write([]byte("hello"))
+			return nil
+		},
+	}
+
+	stdout := new(bytes.Buffer)
+	err := Exec(context.Background(), e, spec, step.Metadata.UID, []string{"echo", "hello"}, nil, stdout, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := namespace, spec.Metadata.Namespace; got != want {
+		t.Errorf("Want exec namespace %s, got %s", want, got)
+	}
+	if got, want := name, step.Metadata.UID; got != want {
+		t.Errorf("Want exec pod %s, got %s", want, got)
+	}
+	want := &v1.PodExecOptions{
+		Container: step.Metadata.UID,
+		Command:   []string{"echo", "hello"},
+		Stdout:    true,
+	}
+	if diff := cmp.Diff(opts, want); diff != "" {
+		t.Errorf("Unexpected exec options")
+		t.Log(diff)
+	}
+	if got, want := stdout.String(), "hello"; got != want {
+		t.Errorf("Want stdout %q, got %q", want, got)
+	}
+}
+
+func TestExec_NotRunning(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status.Phase = v1.PodSucceeded
+
+	e := &kubeEngine{
+		client: fake.NewSimpleClientset(pod),
+		exec: func(string, string, *v1.PodExecOptions, remotecommand.StreamOptions) error {
+			t.Errorf("Expect exec not invoked")
+			return nil
+		},
+	}
+	err := Exec(context.Background(), e, spec, step.Metadata.UID, []string{"sh"}, nil, nil, nil)
+	if err == nil {
+		t.Errorf("Expect error when step is not running")
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// fileImage defines the image used by the init
+	// container that unpacks the step files.
+	fileImage = "busybox:1"
+
+	// filePackedPath defines the init container path at
+	// which the packed file volumes are mounted.
+	filePackedPath = "/drone/files/packed"
+
+	// fileUnpackedPath defines the init container path at
+	// which the unpacked file volumes are mounted.
+	fileUnpackedPath = "/drone/files/unpacked"
+
+	// fileChunkSize defines the maximum size of the file
+	// data stored in a single config map. Config maps are
+	// limited to 1MiB, including the object metadata.
+	fileChunkSize = 1000 * 1024
+)
+
+// helper function converts the engine file object to the
+// kubernetes config map objects. Compressed files are
+// stored as gzip-compressed binary data. Files that exceed
+// the config map size limit are split into chunks, which
+// are stored in separate config maps.
+func toConfigMaps(file *engine.File) []*v1.ConfigMap {
+	chunks := toChunks(file)
+	if len(chunks) == 1 && !file.Compress {
+		return []*v1.ConfigMap{{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: file.Metadata.UID,
+			},
+			Data: map[string]string{
+				file.Metadata.UID: string(file.Data),
+			},
+		}}
+	}
+	var to []*v1.ConfigMap
+	for i, chunk := range chunks {
+		to = append(to, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: chunkName(file, i, len(chunks)),
+			},
+			BinaryData: map[string][]byte{
+				file.Metadata.UID: chunk,
+			},
+		})
+	}
+	return to
+}
+
+// helper function converts the sensitive engine file
+// object to kubernetes secret objects. Files that exceed
+// the secret size limit are split into chunks, which are
+// stored in separate secrets.
+func toFileSecrets(file *engine.File) []*v1.Secret {
+	chunks := toChunks(file)
+	var to []*v1.Secret
+	for i, chunk := range chunks {
+		to = append(to, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: chunkName(file, i, len(chunks)),
+			},
+			Type: "Opaque",
+			Data: map[string][]byte{
+				file.Metadata.UID: chunk,
+			},
+		})
+	}
+	return to
+}
+
+// helper function returns the file data, compressed if
+// requested, split into chunks that do not exceed the
+// config map size limit.
+func toChunks(file *engine.File) [][]byte {
+	data := file.Data
+	if file.Compress {
+		data = compress(data)
+	}
+	chunks := [][]byte{}
+	for len(data) > fileChunkSize {
+		chunks = append(chunks, data[:fileChunkSize])
+		data = data[fileChunkSize:]
+	}
+	return append(chunks, data)
+}
+
+// helper function returns the name of the config map that
+// stores the chunk at index i. The file uid is used if the
+// file is stored in a single config map.
+func chunkName(file *engine.File, i, n int) string {
+	if n == 1 {
+		return file.Metadata.UID
+	}
+	return fmt.Sprintf("%s-%d", file.Metadata.UID, i)
+}
+
+// helper function returns the gzip-compressed data.
+func compress(data []byte) []byte {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// helper function returns true if the file must be
+// unpacked by an init container, because it is sensitive,
+// compressed or split across multiple config maps.
+func isPacked(file *engine.File) bool {
+	return file.Secret || file.Compress || len(file.Data) > fileChunkSize
+}
+
+// helper function returns the name of the volume from
+// which the packed file is read by the init container.
+func packedVolumeName(file *engine.File) string {
+	return file.Metadata.UID + "-packed"
+}
+
+// helper function returns the volume from which the packed
+// file is read by the init container. Chunked files are
+// projected into a single volume, with one file per chunk.
+func toPackedVolume(file *engine.File, mount *engine.FileMount) v1.Volume {
+	name := path.Base(mount.Path)
+	mode := int32(mount.Mode)
+	optional := false
+	n := len(toChunks(file))
+	if n == 1 && file.Secret {
+		return v1.Volume{
+			Name: packedVolumeName(file),
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: file.Metadata.UID,
+					Optional:   &optional,
+					Items: []v1.KeyToPath{
+						{
+							Key:  file.Metadata.UID,
+							Path: name,
+							Mode: &mode,
+						},
+					},
+				},
+			},
+		}
+	}
+	if n == 1 {
+		return v1.Volume{
+			Name: packedVolumeName(file),
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: file.Metadata.UID,
+					},
+					Optional: &optional,
+					Items: []v1.KeyToPath{
+						{
+							Key:  file.Metadata.UID,
+							Path: name,
+							Mode: &mode,
+						},
+					},
+				},
+			},
+		}
+	}
+	var sources []v1.VolumeProjection
+	for i := 0; i < n; i++ {
+		ref := v1.LocalObjectReference{
+			Name: chunkName(file, i, n),
+		}
+		items := []v1.KeyToPath{
+			{
+				Key:  file.Metadata.UID,
+				Path: fmt.Sprintf("%s.%d", name, i),
+			},
+		}
+		if file.Secret {
+			sources = append(sources, v1.VolumeProjection{
+				Secret: &v1.SecretProjection{
+					LocalObjectReference: ref,
+					Optional:             &optional,
+					Items:                items,
+				},
+			})
+		} else {
+			sources = append(sources, v1.VolumeProjection{
+				ConfigMap: &v1.ConfigMapProjection{
+					LocalObjectReference: ref,
+					Optional:             &optional,
+					Items:                items,
+				},
+			})
+		}
+	}
+	return v1.Volume{
+		Name: packedVolumeName(file),
+		VolumeSource: v1.VolumeSource{
+			Projected: &v1.ProjectedVolumeSource{
+				Sources: sources,
+			},
+		},
+	}
+}
+
+// helper function returns the init container that unpacks
+// the packed step files into empty directory volumes,
+// which are mounted in the step container in place of the
+// config maps or secrets. Chunks are concatenated in order before
+// the file is decompressed. Nil is returned if no files
+// are packed.
+func toFileInitContainers(spec *engine.Spec, step *engine.Step) []v1.Container {
+	var mounts []v1.VolumeMount
+	var script []string
+	for _, mount := range step.Files {
+		file, ok := engine.LookupFile(spec, mount.Name)
+		if !ok || !isPacked(file) {
+			continue
+		}
+		name := path.Base(mount.Path)
+		dst := path.Join(fileUnpackedPath, file.Metadata.UID, name)
+
+		var srcs []string
+		if n := len(toChunks(file)); n == 1 {
+			srcs = append(srcs, path.Join(filePackedPath, file.Metadata.UID, name))
+		} else {
+			for i := 0; i < n; i++ {
+				srcs = append(srcs, path.Join(filePackedPath, file.Metadata.UID, fmt.Sprintf("%s.%d", name, i)))
+			}
+		}
+		switch {
+		case file.Compress && len(srcs) == 1:
+			script = append(script, fmt.Sprintf("gunzip -c %s > %s", srcs[0], dst))
+		case file.Compress:
+			script = append(script, fmt.Sprintf("cat %s | gunzip -c > %s", strings.Join(srcs, " "), dst))
+		default:
+			script = append(script, fmt.Sprintf("cat %s > %s", strings.Join(srcs, " "), dst))
+		}
+		if mount.Mode != 0 {
+			script = append(script, fmt.Sprintf("chmod %o %s", mount.Mode, dst))
+		}
+		mounts = append(mounts,
+			v1.VolumeMount{
+				Name:      packedVolumeName(file),
+				MountPath: path.Join(filePackedPath, file.Metadata.UID),
+				ReadOnly:  true,
+			},
+			v1.VolumeMount{
+				Name:      file.Metadata.UID,
+				MountPath: path.Join(fileUnpackedPath, file.Metadata.UID),
+			},
+		)
+	}
+	if len(script) == 0 {
+		return nil
+	}
+	return []v1.Container{{
+		Name:            "files-" + step.Metadata.UID,
+		Image:           fileImage,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", strings.Join(script, " && ")},
+		VolumeMounts:    mounts,
+	}}
+}
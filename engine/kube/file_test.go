@@ -0,0 +1,383 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/drone/drone-runtime/engine"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+)
+
+func TestToConfigMap(t *testing.T) {
+	file := &engine.File{
+		Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK"},
+		Data:     []byte("hello world"),
+	}
+	configMaps := toConfigMaps(file)
+	if len(configMaps) != 1 {
+		t.Errorf("Want a single config map, got %d", len(configMaps))
+		return
+	}
+	configMap := configMaps[0]
+	if got, want := configMap.Name, file.Metadata.UID; got != want {
+		t.Errorf("Want config map name %s, got %s", want, got)
+	}
+	if got, want := configMap.Data[file.Metadata.UID], "hello world"; got != want {
+		t.Errorf("Want config map data %q, got %q", want, got)
+	}
+	if len(configMap.BinaryData) != 0 {
+		t.Errorf("Expect no binary data for uncompressed file")
+	}
+}
+
+func TestToConfigMap_Compress(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world\n"), 1000)
+	file := &engine.File{
+		Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK"},
+		Data:     data,
+		Compress: true,
+	}
+	configMaps := toConfigMaps(file)
+	if len(configMaps) != 1 {
+		t.Errorf("Want a single config map, got %d", len(configMaps))
+		return
+	}
+	configMap := configMaps[0]
+	if len(configMap.Data) != 0 {
+		t.Errorf("Expect no string data for compressed file")
+	}
+	packed := configMap.BinaryData[file.Metadata.UID]
+	if len(packed) >= len(data) {
+		t.Errorf("Expect compressed data smaller than %d bytes, got %d", len(data), len(packed))
+	}
+	r, err := gzip.NewReader(bytes.NewReader(packed))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	unpacked, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(unpacked, data) {
+		t.Errorf("Expect decompressed data to match the file data")
+	}
+}
+
+func TestToConfigMaps_Chunked(t *testing.T) {
+	data := make([]byte, 2*fileChunkSize+512)
+	rand.New(rand.NewSource(1)).Read(data)
+	file := &engine.File{
+		Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK"},
+		Data:     data,
+	}
+	configMaps := toConfigMaps(file)
+	if got, want := len(configMaps), 3; got != want {
+		t.Errorf("Want %d chunked config maps, got %d", want, got)
+		return
+	}
+	var total int
+	buf := new(bytes.Buffer)
+	for i, configMap := range configMaps {
+		if got, want := configMap.Name, fmt.Sprintf("uid_Ae5vGF6d2Qdqx1rK-%d", i); got != want {
+			t.Errorf("Want config map name %s, got %s", want, got)
+		}
+		chunk := configMap.BinaryData[file.Metadata.UID]
+		if len(chunk) > fileChunkSize {
+			t.Errorf("Want chunk size under %d bytes, got %d", fileChunkSize, len(chunk))
+		}
+		total += len(chunk)
+		buf.Write(chunk)
+	}
+	if got, want := total, len(data); got != want {
+		t.Errorf("Want total chunk size %d, got %d", want, got)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Expect chunks reassembled in order to match the file data")
+	}
+}
+
+func TestToPod_ChunkedFile(t *testing.T) {
+	spec, step := testSpec()
+	spec.Files = []*engine.File{
+		{
+			Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK", Name: "fixtures.json"},
+			Data:     make([]byte, fileChunkSize+1),
+		},
+	}
+	step.Files = []*engine.FileMount{
+		{Name: "fixtures.json", Path: "/data/fixtures.json"},
+	}
+
+	pod := toPod(spec, step)
+
+	optional := false
+	wantVolumes := []v1.Volume{
+		{
+			Name: "uid_Ae5vGF6d2Qdqx1rK-packed",
+			VolumeSource: v1.VolumeSource{
+				Projected: &v1.ProjectedVolumeSource{
+					Sources: []v1.VolumeProjection{
+						{
+							ConfigMap: &v1.ConfigMapProjection{
+								LocalObjectReference: v1.LocalObjectReference{Name: "uid_Ae5vGF6d2Qdqx1rK-0"},
+								Optional:             &optional,
+								Items:                []v1.KeyToPath{{Key: "uid_Ae5vGF6d2Qdqx1rK", Path: "fixtures.json.0"}},
+							},
+						},
+						{
+							ConfigMap: &v1.ConfigMapProjection{
+								LocalObjectReference: v1.LocalObjectReference{Name: "uid_Ae5vGF6d2Qdqx1rK-1"},
+								Optional:             &optional,
+								Items:                []v1.KeyToPath{{Key: "uid_Ae5vGF6d2Qdqx1rK", Path: "fixtures.json.1"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "uid_Ae5vGF6d2Qdqx1rK",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+	if diff := cmp.Diff(pod.Spec.Volumes, wantVolumes); diff != "" {
+		t.Errorf("Unexpected pod volumes")
+		t.Log(diff)
+	}
+
+	if len(pod.Spec.InitContainers) != 1 {
+		t.Errorf("Expect reassembly init container")
+		return
+	}
+	want := "cat /drone/files/packed/uid_Ae5vGF6d2Qdqx1rK/fixtures.json.0" +
+		" /drone/files/packed/uid_Ae5vGF6d2Qdqx1rK/fixtures.json.1" +
+		" > /drone/files/unpacked/uid_Ae5vGF6d2Qdqx1rK/fixtures.json"
+	if got := pod.Spec.InitContainers[0].Command[2]; got != want {
+		t.Errorf("Want reassembly script %q, got %q", want, got)
+	}
+}
+
+func TestToPod_CompressedFile(t *testing.T) {
+	spec, step := testSpec()
+	spec.Files = []*engine.File{
+		{
+			Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK", Name: "settings.xml"},
+			Data:     []byte("<settings/>"),
+			Compress: true,
+		},
+	}
+	step.Files = []*engine.FileMount{
+		{Name: "settings.xml", Path: "/root/.m2/settings.xml", Mode: 0600},
+	}
+
+	pod := toPod(spec, step)
+
+	mode := int32(0600)
+	optional := false
+	wantVolumes := []v1.Volume{
+		{
+			Name: "uid_Ae5vGF6d2Qdqx1rK-packed",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: "uid_Ae5vGF6d2Qdqx1rK"},
+					Optional:             &optional,
+					Items: []v1.KeyToPath{
+						{Key: "uid_Ae5vGF6d2Qdqx1rK", Path: "settings.xml", Mode: &mode},
+					},
+				},
+			},
+		},
+		{
+			Name: "uid_Ae5vGF6d2Qdqx1rK",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+	if diff := cmp.Diff(pod.Spec.Volumes, wantVolumes); diff != "" {
+		t.Errorf("Unexpected pod volumes")
+		t.Log(diff)
+	}
+
+	wantMounts := []v1.VolumeMount{
+		{Name: "uid_Ae5vGF6d2Qdqx1rK", MountPath: "/root/.m2/settings.xml", SubPath: "settings.xml"},
+	}
+	if diff := cmp.Diff(pod.Spec.Containers[0].VolumeMounts, wantMounts); diff != "" {
+		t.Errorf("Unexpected step volume mounts")
+		t.Log(diff)
+	}
+
+	wantInit := []v1.Container{
+		{
+			Name:            "files-uid_8a7IJsL9zSJCCchd",
+			Image:           "busybox:1",
+			ImagePullPolicy: v1.PullIfNotPresent,
+			Command: []string{"/bin/sh", "-c",
+				"gunzip -c /drone/files/packed/uid_Ae5vGF6d2Qdqx1rK/settings.xml > /drone/files/unpacked/uid_Ae5vGF6d2Qdqx1rK/settings.xml" +
+					" && chmod 600 /drone/files/unpacked/uid_Ae5vGF6d2Qdqx1rK/settings.xml",
+			},
+			VolumeMounts: []v1.VolumeMount{
+				{Name: "uid_Ae5vGF6d2Qdqx1rK-packed", MountPath: "/drone/files/packed/uid_Ae5vGF6d2Qdqx1rK", ReadOnly: true},
+				{Name: "uid_Ae5vGF6d2Qdqx1rK", MountPath: "/drone/files/unpacked/uid_Ae5vGF6d2Qdqx1rK"},
+			},
+		},
+	}
+	if diff := cmp.Diff(pod.Spec.InitContainers, wantInit); diff != "" {
+		t.Errorf("Unexpected decompression init container")
+		t.Log(diff)
+	}
+}
+
+func TestToPod_UncompressedFile(t *testing.T) {
+	spec, step := testSpec()
+	spec.Files = []*engine.File{
+		{
+			Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK", Name: "settings.xml"},
+			Data:     []byte("<settings/>"),
+		},
+	}
+	step.Files = []*engine.FileMount{
+		{Name: "settings.xml", Path: "/root/.m2/settings.xml"},
+	}
+	pod := toPod(spec, step)
+	if len(pod.Spec.InitContainers) != 0 {
+		t.Errorf("Expect no init containers for uncompressed files")
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].ConfigMap == nil {
+		t.Errorf("Expect config map volume for uncompressed file")
+	}
+}
+
+func TestToFileSecrets(t *testing.T) {
+	file := &engine.File{
+		Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK"},
+		Data:     []byte("-----BEGIN CERTIFICATE-----"),
+		Secret:   true,
+	}
+	secrets := toFileSecrets(file)
+	if len(secrets) != 1 {
+		t.Errorf("Want a single secret, got %d", len(secrets))
+		return
+	}
+	if got, want := secrets[0].Name, file.Metadata.UID; got != want {
+		t.Errorf("Want secret name %s, got %s", want, got)
+	}
+	if got, want := string(secrets[0].Data[file.Metadata.UID]), "-----BEGIN CERTIFICATE-----"; got != want {
+		t.Errorf("Want secret data %q, got %q", want, got)
+	}
+}
+
+func TestToPod_SecretFile(t *testing.T) {
+	spec, step := testSpec()
+	spec.Files = []*engine.File{
+		{
+			Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK", Name: "ca.crt"},
+			Data:     []byte("-----BEGIN CERTIFICATE-----"),
+			Secret:   true,
+		},
+	}
+	step.Files = []*engine.FileMount{
+		{Name: "ca.crt", Path: "/etc/ssl/custom/ca.crt", Mode: 0400},
+	}
+
+	pod := toPod(spec, step)
+
+	mode := int32(0400)
+	optional := false
+	wantVolumes := []v1.Volume{
+		{
+			Name: "uid_Ae5vGF6d2Qdqx1rK-packed",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: "uid_Ae5vGF6d2Qdqx1rK",
+					Optional:   &optional,
+					Items: []v1.KeyToPath{
+						{Key: "uid_Ae5vGF6d2Qdqx1rK", Path: "ca.crt", Mode: &mode},
+					},
+				},
+			},
+		},
+		{
+			Name: "uid_Ae5vGF6d2Qdqx1rK",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+	if diff := cmp.Diff(pod.Spec.Volumes, wantVolumes); diff != "" {
+		t.Errorf("Unexpected pod volumes")
+		t.Log(diff)
+	}
+
+	// the secret volume is only mounted by the init
+	// container, and the step container mounts the
+	// empty directory.
+	wantMounts := []v1.VolumeMount{
+		{Name: "uid_Ae5vGF6d2Qdqx1rK", MountPath: "/etc/ssl/custom/ca.crt", SubPath: "ca.crt"},
+	}
+	if diff := cmp.Diff(pod.Spec.Containers[0].VolumeMounts, wantMounts); diff != "" {
+		t.Errorf("Unexpected step volume mounts")
+		t.Log(diff)
+	}
+
+	wantInit := []v1.Container{
+		{
+			Name:            "files-uid_8a7IJsL9zSJCCchd",
+			Image:           "busybox:1",
+			ImagePullPolicy: v1.PullIfNotPresent,
+			Command: []string{"/bin/sh", "-c",
+				"cat /drone/files/packed/uid_Ae5vGF6d2Qdqx1rK/ca.crt > /drone/files/unpacked/uid_Ae5vGF6d2Qdqx1rK/ca.crt" +
+					" && chmod 400 /drone/files/unpacked/uid_Ae5vGF6d2Qdqx1rK/ca.crt",
+			},
+			VolumeMounts: []v1.VolumeMount{
+				{Name: "uid_Ae5vGF6d2Qdqx1rK-packed", MountPath: "/drone/files/packed/uid_Ae5vGF6d2Qdqx1rK", ReadOnly: true},
+				{Name: "uid_Ae5vGF6d2Qdqx1rK", MountPath: "/drone/files/unpacked/uid_Ae5vGF6d2Qdqx1rK"},
+			},
+		},
+	}
+	if diff := cmp.Diff(pod.Spec.InitContainers, wantInit); diff != "" {
+		t.Errorf("Unexpected file init container")
+		t.Log(diff)
+	}
+}
+
+// this test verifies that files mounted in the same directory
+// are mounted at their exact path, and do not shadow the
+// directory or each other.
+func TestToPod_FileSubPath(t *testing.T) {
+	spec, step := testSpec()
+	spec.Files = []*engine.File{
+		{Metadata: engine.Metadata{UID: "uid_Ae5vGF6d2Qdqx1rK", Name: "gitconfig"}, Data: []byte("[user]")},
+	}
+	step.Files = []*engine.FileMount{
+		{Name: "gitconfig", Path: "/root/.gitconfig", Mode: 0644},
+	}
+	engine.MountNetrc(spec, "uid_f9u2MKhxU6tFmPBE", "", &engine.Netrc{
+		Machine:  "github.com",
+		Login:    "octocat",
+		Password: "correct-horse-battery-staple",
+	})
+
+	pod := toPod(spec, step)
+	want := []v1.VolumeMount{
+		{Name: "uid_Ae5vGF6d2Qdqx1rK", MountPath: "/root/.gitconfig", SubPath: ".gitconfig"},
+		{Name: "uid_f9u2MKhxU6tFmPBE", MountPath: "/root/.netrc", SubPath: ".netrc"},
+	}
+	if diff := cmp.Diff(pod.Spec.Containers[0].VolumeMounts, want); diff != "" {
+		t.Errorf("Unexpected step volume mounts")
+		t.Log(diff)
+	}
+}
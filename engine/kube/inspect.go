@@ -0,0 +1,28 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone/drone-runtime/engine"
+)
+
+// Inspect returns the configured entrypoint and command of
+// the image, which is useful to debug steps that define no
+// command. The pod runtime does not expose the image
+// configuration, so the image manifest is fetched from the
+// registry using the registry credentials in the spec.
+func Inspect(ctx context.Context, eng engine.Engine, spec *engine.Spec, image string) (*engine.ImageConfig, error) {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return nil, fmt.Errorf("Not a valid Engine type")
+	}
+	if e.inspect == nil {
+		return nil, fmt.Errorf("kubernetes: image inspection is not supported")
+	}
+	return engine.InspectImage(ctx, spec, e.inspect, image)
+}
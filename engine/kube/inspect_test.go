@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drone/drone-runtime/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInspect(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/v2/octocat/hello-world/manifests/latest":
+			w.Write([]byte(`{"config":{"digest":"sha256:c3"}}`))
+		case "/v2/octocat/hello-world/blobs/sha256:c3":
+			w.Write([]byte(`{"config":{"Entrypoint":["/bin/hello"],"Cmd":["--world"]}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	// the registry inspector uses https, so requests
+	// are redirected to the http test server.
+	host := strings.TrimPrefix(server.URL, "http://")
+	client := server.Client()
+	client.Transport = rewriteScheme{client.Transport}
+
+	spec, _ := testSpec()
+	spec.Docker = &engine.DockerConfig{
+		Auths: []*engine.DockerAuth{
+			{Address: host, Username: "octocat", Password: "correct-horse-battery-staple"},
+		},
+	}
+	e := &kubeEngine{inspect: engine.NewRegistryInspector(client)}
+	config, err := Inspect(context.Background(), e, spec, host+"/octocat/hello-world")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := &engine.ImageConfig{
+		Entrypoint: []string{"/bin/hello"},
+		Cmd:        []string{"--world"},
+	}
+	if diff := cmp.Diff(config, want); diff != "" {
+		t.Errorf("Unexpected image config")
+		t.Log(diff)
+	}
+	if !strings.HasPrefix(auth, "Basic ") {
+		t.Errorf("Expect registry credentials sent to the registry")
+	}
+}
+
+// rewriteScheme is a round tripper that sends https
+// requests over http, for testing purposes.
+type rewriteScheme struct {
+	next http.RoundTripper
+}
+
+func (r rewriteScheme) RoundTrip(req *http.Request) (*http.Response, error) {
+	clone := *req
+	uri := *req.URL
+	uri.Scheme = "http"
+	clone.URL = &uri
+	next := r.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(&clone)
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"github.com/drone/drone-runtime/engine"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// jobLabel defines the label the job controller adds to
+// the pods of a job, which is used to find the step pods.
+const jobLabel = "job-name"
+
+// helper function returns true if the step is executed
+// as a job, which retries the step pod on failure.
+func isJob(step *engine.Step) bool {
+	return step.Docker != nil && step.Docker.BackoffLimit > 0
+}
+
+// helper function converts the step pod to a job that
+// runs the pod to completion once, retrying the pod up to
+// the step backoff limit. The pod is never restarted in
+// place, since each retry creates a new pod.
+func toJob(step *engine.Step, pod *v1.Pod) *batchv1.Job {
+	backoffLimit := int32(step.Docker.BackoffLimit)
+	completions := int32(1)
+
+	template := v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      pod.Labels,
+			Annotations: pod.Annotations,
+		},
+		Spec: pod.Spec,
+	}
+	template.Spec.RestartPolicy = v1.RestartPolicyNever
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Labels:    pod.Labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Completions:  &completions,
+			Template:     template,
+		},
+	}
+}
+
+// helper function returns true if the job has completed
+// or failed, and will not create further pods.
+func isJobDone(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		switch cond.Type {
+		case batchv1.JobComplete, batchv1.JobFailed:
+			if cond.Status == v1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helper function returns the most recently created pod
+// of the step job, or nil if the job has not yet created
+// a pod.
+func (e *kubeEngine) jobPod(spec *engine.Spec, step *engine.Step) (*v1.Pod, error) {
+	list, err := e.client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{
+		LabelSelector: jobLabel + "=" + step.Metadata.UID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var latest *v1.Pod
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestToJob(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.BackoffLimit = 3
+	pod := toPod(spec, step)
+	job := toJob(step, pod)
+
+	if got, want := job.Name, step.Metadata.UID; got != want {
+		t.Errorf("Want job name %s, got %s", want, got)
+	}
+	if got, want := *job.Spec.BackoffLimit, int32(3); got != want {
+		t.Errorf("Want backoff limit %d, got %d", want, got)
+	}
+	if got, want := *job.Spec.Completions, int32(1); got != want {
+		t.Errorf("Want completions %d, got %d", want, got)
+	}
+	if got, want := job.Spec.Template.Spec.RestartPolicy, v1.RestartPolicyNever; got != want {
+		t.Errorf("Want restart policy %s, got %s", want, got)
+	}
+	if diff := cmp.Diff(job.Spec.Template.Spec.Containers, pod.Spec.Containers); diff != "" {
+		t.Errorf("Unexpected job pod template containers")
+		t.Log(diff)
+	}
+}
+
+func TestStart_Job(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.BackoffLimit = 2
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+
+	if err := e.Start(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	job, err := client.BatchV1().Jobs(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := *job.Spec.BackoffLimit, int32(2); got != want {
+		t.Errorf("Want backoff limit %d, got %d", want, got)
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect job created instead of pod")
+	}
+}
+
+func TestWait_Job(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.BackoffLimit = 2
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      step.Metadata.UID,
+			Namespace: spec.Metadata.Namespace,
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: v1.ConditionTrue},
+			},
+		},
+	}
+	created := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	failed := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              step.Metadata.UID + "-abcde",
+			Namespace:         spec.Metadata.Namespace,
+			Labels:            map[string]string{jobLabel: step.Metadata.UID},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodFailed,
+			ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 1},
+				},
+			}},
+		},
+	}
+	retried := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              step.Metadata.UID + "-fghij",
+			Namespace:         spec.Metadata.Namespace,
+			Labels:            map[string]string{jobLabel: step.Metadata.UID},
+			CreationTimestamp: metav1.NewTime(created.Add(time.Minute)),
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodSucceeded,
+			ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 0},
+				},
+			}},
+		},
+	}
+
+	e := &kubeEngine{client: fake.NewSimpleClientset(job, failed, retried)}
+	state, err := e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := state.ExitCode, 0; got != want {
+		t.Errorf("Want exit code %d of the retried pod, got %d", want, got)
+	}
+}
@@ -6,59 +6,340 @@ package kube
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/drone/drone-runtime/engine"
 	"github.com/drone/drone-runtime/engine/docker/auth"
 
+	"golang.org/x/time/rate"
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	// defaultSchedulingGrace defines the amount of time a
+	// pod may remain unschedulable before the step fails.
+	defaultSchedulingGrace = time.Minute
+
+	// defaultPollInterval defines the interval at which
+	// the pod status is polled.
+	defaultPollInterval = time.Second
+
+	// defaultLocalSSDPath defines the node path at which
+	// local solid state storage is mounted.
+	defaultLocalSSDPath = "/mnt/disks/ssd0"
+
+	// terminationTimeout defines the maximum amount of
+	// time the engine waits for service pods to terminate
+	// before deleting the namespace.
+	terminationTimeout = time.Minute
+
+	// annotationExpires defines the namespace annotation
+	// that records the time after which the namespace may
+	// be reaped.
+	annotationExpires = "drone.io/expires-at"
+
+	// labelBuildID defines the label added to all objects
+	// created for the pipeline, which is used to find the
+	// objects of a build after the runner crashed.
+	labelBuildID = "drone.io/build-id"
+)
+
 type kubeEngine struct {
-	client *kubernetes.Clientset
-	node   string
+	client   kubernetes.Interface
+	node     string
+	grace    time.Duration
+	interval time.Duration
+	timeout  time.Duration
+	strict   bool
+	nodeDir  bool
+	quota    *Quota
+	limits   *LimitRange
+	policy   *NetworkPolicy
+	expand   bool
+	exec     executor
+	logs     logger
+	metrics  metrics
+	pull     time.Duration
+	keep     bool
+	ttl      time.Duration
+	deadline time.Duration
+	shell    Shell
+	trusted  bool
+	ssdPath  string
+	mkdir    bool
+	log      Logger
+	services int
+	inspect  engine.ImageInspector
+	zoneinfo bool
+	limiter  *rate.Limiter
+	qps      float32
+	burst    int
+	mutators []PodMutator
+	noNode   bool
+	cpuEnv   bool
+	success  []int
+	saPull   bool
+	gc       bool
+	prePull  bool
+
+	// finalizers are added to the pipeline namespace.
+	finalizers []string
+
+	// failed tracks the namespaces of pipelines with
+	// failed steps, which are kept for inspection.
+	failed sync.Map
+
+	// started tracks the service steps started during
+	// setup, which are not started again. The steps are
+	// removed when the pipeline is destroyed.
+	started sync.Map
+
+	// completed tracks the completed steps, and whether
+	// the step failed, until the step pod is deleted.
+	completed sync.Map
+
+	// deadlines tracks the build deadline timers of the
+	// pipeline namespaces, which are stopped on destroy.
+	deadlines sync.Map
 }
 
 // NewFile returns a new Kubernetes engine from a
 // Kubernetes configuration file (~/.kube/config).
-func NewFile(url, path, node string) (engine.Engine, error) {
+func NewFile(url, path, node string, opts ...Option) (engine.Engine, error) {
 	config, err := clientcmd.BuildConfigFromFlags(url, path)
 	if err != nil {
 		return nil, err
 	}
+	e := &kubeEngine{
+		node:     node,
+		grace:    defaultSchedulingGrace,
+		interval: defaultPollInterval,
+		shell:    defaultShell,
+		ssdPath:  defaultLocalSSDPath,
+		log:      nopLogger{},
+		inspect:  engine.NewRegistryInspector(nil),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.configure(config)
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
-	return &kubeEngine{client: client, node: node}, nil
+	e.client = client
+	e.exec = newExecutor(client, config)
+	e.logs = newLogger(client)
+	e.metrics = newMetrics(client)
+	return e, nil
+}
+
+// helper function applies the client options to the
+// rest configuration used to create the client.
+func (e *kubeEngine) configure(config *rest.Config) {
+	if e.qps > 0 {
+		config.QPS = e.qps
+	}
+	if e.burst > 0 {
+		config.Burst = e.burst
+	}
+}
+
+// Pod describes the state of a pipeline step pod.
+type Pod struct {
+	Name  string // Pod name, which is the step uid
+	UID   string // Kubernetes object uid
+	Phase string // Pod phase
+	IP    string // Pod ip address, once running
+	Node  string // Pod node name, once scheduled
+}
+
+// ListPods returns the step pods of the build in the
+// pipeline namespace. Helper pods, such as the image
+// pre-pull pod, are excluded. This can be used to reconcile
+// running steps after the runner is restarted, in order to
+// resume tailing the logs or clean up.
+func ListPods(ctx context.Context, eng engine.Engine, spec *engine.Spec) ([]*Pod, error) {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return nil, fmt.Errorf("Not a valid Engine type")
+	}
+	list, err := e.client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{
+		LabelSelector: labelBuildID + "=" + spec.Metadata.UID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	steps := map[string]bool{}
+	for _, step := range spec.Steps {
+		steps[step.Metadata.UID] = true
+	}
+	var pods []*Pod
+	for _, item := range list.Items {
+		// the pods of a step executed as a job are named
+		// by the job controller, and are labeled with the
+		// job name, which is the step uid.
+		name := item.Name
+		if job, ok := item.Labels[jobLabel]; ok {
+			name = job
+		}
+		if !steps[name] {
+			continue
+		}
+		pods = append(pods, &Pod{
+			Name:  name,
+			UID:   string(item.UID),
+			Phase: string(item.Status.Phase),
+			IP:    item.Status.PodIP,
+			Node:  item.Spec.NodeName,
+		})
+	}
+	return pods, nil
+}
+
+// ReapExpired deletes the pipeline namespaces that have
+// exceeded their time to live. This is a safety net for
+// namespaces that are orphaned when the runner crashes
+// before the pipeline is destroyed. It returns the names
+// of the deleted namespaces.
+func ReapExpired(ctx context.Context, eng engine.Engine) ([]string, error) {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return nil, fmt.Errorf("Not a valid Engine type")
+	}
+	list, err := e.client.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var reaped []string
+	now := time.Now()
+	for _, ns := range list.Items {
+		expires, ok := ns.Annotations[annotationExpires]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, expires)
+		if err != nil || t.After(now) {
+			continue
+		}
+		err = e.client.CoreV1().Namespaces().Delete(ns.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return reaped, err
+		}
+		reaped = append(reaped, ns.Name)
+	}
+	return reaped, nil
 }
 
 func (e *kubeEngine) Setup(ctx context.Context, spec *engine.Spec) error {
-	ns := toNamespace(spec)
+	ns := toNamespace(spec, e.finalizers)
+
+	// the build deadline shortens the namespace expiry, so
+	// that the namespace is reaped even if the runner stops
+	// before the deadline timer fires.
+	ttl := e.ttl
+	if e.deadline > 0 && (ttl == 0 || e.deadline < ttl) {
+		ttl = e.deadline
+	}
+	if ttl > 0 {
+		ns.Annotations = map[string]string{
+			annotationExpires: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+		}
+	}
 
 	// create the project namespace. all pods and
 	// containers are created within the namespace, and
 	// are removed when the pipeline execution completes.
+	//
+	// note that setup may be retried after a partial
+	// failure, in which case objects may already exist.
 	_, err := e.client.CoreV1().Namespaces().Create(ns)
-	if err != nil {
-		return err
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		e.logger().Error("cannot create namespace",
+			"namespace", ns.Name, "error", err)
+		return toError(err)
+	}
+	e.logger().Info("created namespace", "namespace", ns.Name)
+
+	if e.deadline > 0 {
+		e.startDeadline(spec.Metadata.Namespace)
+	}
+
+	// the namespace may already exist with a resource quota,
+	// in which case the engine does not create a conflicting
+	// quota, and verifies the steps fit within the remaining
+	// budget of the existing quota. Quotas are only listed
+	// when the quota option is set, since the engine may not
+	// be granted access to resource quotas otherwise.
+	if !e.quota.empty() {
+		quotas, err := e.client.CoreV1().ResourceQuotas(ns.Name).List(metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		var existing []v1.ResourceQuota
+		for _, quota := range quotas.Items {
+			if quota.Name != quotaName {
+				existing = append(existing, quota)
+			}
+		}
+		if err := checkQuotas(spec, existing); err != nil {
+			e.logger().Error("resource quota exceeded",
+				"namespace", ns.Name, "error", err)
+			return err
+		}
+
+		// create the resource quota, which caps the total
+		// resources consumed by the pipeline.
+		if len(existing) == 0 {
+			_, err := e.client.CoreV1().ResourceQuotas(ns.Name).Create(
+				toResourceQuota(spec, e.quota),
+			)
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				return err
+			}
+		}
+	}
+
+	// create the limit range, which applies default
+	// resources to containers that do not declare them.
+	if !e.limits.empty() {
+		_, err := e.client.CoreV1().LimitRanges(ns.Name).Create(
+			toLimitRange(spec, e.limits),
+		)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	// create the network policy, which isolates the
+	// pipeline pods from the cluster.
+	if e.policy != nil {
+		_, err := e.client.NetworkingV1().NetworkPolicies(ns.Name).Create(
+			toNetworkPolicy(spec, e.policy),
+		)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
 	}
 
 	// create all secrets
 	for _, secret := range spec.Secrets {
-		_, err := e.client.CoreV1().Secrets(ns.Name).Create(
-			toSecret(spec, secret),
-		)
+		err := e.createSecret(spec, toSecret(spec, secret))
 		if err != nil {
 			return err
 		}
@@ -70,7 +351,7 @@ func (e *kubeEngine) Setup(ctx context.Context, spec *engine.Spec) error {
 		if err != nil {
 			return err
 		}
-		_, err = e.client.CoreV1().Secrets(ns.Name).Create(
+		err = e.createSecret(spec,
 			&v1.Secret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "docker-auth-config",
@@ -84,22 +365,31 @@ func (e *kubeEngine) Setup(ctx context.Context, spec *engine.Spec) error {
 		if err != nil {
 			return err
 		}
+		if e.saPull {
+			if err := e.setPullSecret(ns.Name, "docker-auth-config"); err != nil {
+				return toError(err)
+			}
+		}
 	}
 
-	// create all files as config maps.
+	// create all files as config maps, or secrets if the
+	// files are sensitive. Large files are split across
+	// multiple objects.
 	for _, file := range spec.Files {
-		_, err := e.client.CoreV1().ConfigMaps(ns.Name).Create(
-			&v1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: file.Metadata.UID,
-				},
-				Data: map[string]string{
-					file.Metadata.UID: string(file.Data),
-				},
-			},
-		)
-		if err != nil {
-			return err
+		if file.Secret {
+			for _, secret := range toFileSecrets(file) {
+				err := e.createSecret(spec, secret)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, configMap := range toConfigMaps(file) {
+			err := e.createConfigMap(spec, configMap)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -115,17 +405,154 @@ func (e *kubeEngine) Setup(ctx context.Context, spec *engine.Spec) error {
 	// 	return err
 	// }
 
+	// pull the step images sequentially, before the step
+	// pods are scheduled, so that the node does not pull
+	// many large images at once.
+	if e.prePull {
+		if err := e.pullImages(ctx, spec); err != nil {
+			return err
+		}
+	}
+
+	// start the service pods concurrently, instead of
+	// sequentially as directed by the runtime.
+	if e.services > 0 {
+		return e.startServices(ctx, spec)
+	}
 	return nil
 }
 
+// helper function creates the secret, or updates the
+// secret if it already exists.
+func (e *kubeEngine) createSecret(spec *engine.Spec, secret *v1.Secret) error {
+	secret.Labels = toLabels(spec, secret.Labels)
+	secrets := e.client.CoreV1().Secrets(spec.Metadata.Namespace)
+	_, err := secrets.Create(secret)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(secret)
+	}
+	return err
+}
+
+// helper function adds the pull secret to the default
+// service account of the namespace, so that all pods in the
+// namespace inherit the secret. The service account is
+// created if the service account controller has not yet
+// created it.
+func (e *kubeEngine) setPullSecret(namespace, name string) error {
+	accounts := e.client.CoreV1().ServiceAccounts(namespace)
+	ref := v1.LocalObjectReference{Name: name}
+	account, err := accounts.Get("default", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = accounts.Create(&v1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default"},
+			ImagePullSecrets: []v1.LocalObjectReference{ref},
+		})
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		account, err = accounts.Get("default", metav1.GetOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	for _, secret := range account.ImagePullSecrets {
+		if secret.Name == name {
+			return nil
+		}
+	}
+	account.ImagePullSecrets = append(account.ImagePullSecrets, ref)
+	_, err = accounts.Update(account)
+	return err
+}
+
+// helper function creates the config map, or updates the
+// config map if it already exists.
+func (e *kubeEngine) createConfigMap(spec *engine.Spec, configMap *v1.ConfigMap) error {
+	configMap.Labels = toLabels(spec, configMap.Labels)
+	configMaps := e.client.CoreV1().ConfigMaps(spec.Metadata.Namespace)
+	_, err := configMaps.Create(configMap)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(configMap)
+	}
+	return err
+}
+
 func (e *kubeEngine) Create(_ context.Context, _ *engine.Spec, _ *engine.Step) error {
 	// no-op
 	return nil
 }
 
 func (e *kubeEngine) Start(ctx context.Context, spec *engine.Spec, step *engine.Step) error {
+	if _, ok := e.started.Load(step.Metadata.UID); ok {
+		return nil
+	}
+
+	if e.strict {
+		if err := checkReferences(spec, step); err != nil {
+			return err
+		}
+	}
+
+	// an external service step creates a service that
+	// resolves to the external host, and no pod.
+	if isExternal(step) {
+		if err := e.throttle(ctx); err != nil {
+			return err
+		}
+		service := toService(spec, step)
+		_, err := e.client.CoreV1().Services(spec.Metadata.Namespace).Create(service)
+		return toError(err)
+	}
+
+	// a pod that restarts its containers never completes,
+	// and therefore restart policies are not supported. The
+	// step is retried using a backoff limit instead.
+	if step.Docker.Restart != engine.RestartNo {
+		return fmt.Errorf("kubernetes: step %s: restart policy %s is not supported, use a backoff limit to retry the step",
+			step.Metadata.Name, step.Docker.Restart)
+	}
+
+	// kubernetes assigns the guaranteed class when the
+	// requests equal the limits, and a step without limits
+	// would silently be assigned the best effort class.
+	if err := checkGuaranteed(step); err != nil {
+		return err
+	}
+
+	// the kubelet cannot resolve user names in the image,
+	// which are only supported by the docker runtime driver.
+	if _, _, err := parseUser(step.Docker.User); err != nil {
+		return err
+	}
+
 	pod := toPod(spec, step)
+
+	for _, clamped := range toClamped(spec, step) {
+		e.logger().Warn("step resources exceed the maximum",
+			"namespace", spec.Metadata.Namespace,
+			"step", step.Metadata.Name,
+			"resource", clamped)
+	}
+
+	// mounting the docker socket grants root access to
+	// the host, and is therefore restricted to trusted
+	// pipelines.
+	if step.Docker.DockerSock {
+		if !e.trusted {
+			return fmt.Errorf("kubernetes: step %s is not trusted to mount the docker socket", step.Metadata.Name)
+		}
+		setDockerSock(pod)
+	}
+	if hasHostPort(step) {
+		e.logger().Warn("step binds a host port, which limits scheduling to nodes where the port is free",
+			"namespace", spec.Metadata.Namespace,
+			"step", step.Metadata.Name)
+	}
 	if len(step.Docker.Ports) != 0 {
+		if err := e.throttle(ctx); err != nil {
+			return err
+		}
 		service := toService(spec, step)
 		_, err := e.client.CoreV1().Services(spec.Metadata.Namespace).Create(service)
 		if err != nil {
@@ -133,6 +560,48 @@ func (e *kubeEngine) Start(ctx context.Context, spec *engine.Spec, step *engine.
 		}
 	}
 
+	if e.node != "" && e.nodeDir {
+		setNodeTempDir(pod, spec, e.node)
+	}
+
+	if len(step.Docker.Commands) != 0 {
+		shell := e.shell
+		if shell.Path == "" {
+			shell = defaultShell
+		}
+		setScript(pod, step, shell, toShellFlags(spec, shell))
+	}
+
+	if e.expand {
+		expandCommand(pod, step)
+	}
+
+	if e.mkdir {
+		setWorkingDir(pod, step)
+	}
+
+	if e.zoneinfo && step.Timezone != "" {
+		setZoneInfo(pod)
+	}
+
+	if hasLogSidecar(step) {
+		setLogSidecar(pod, step)
+	}
+
+	if e.noNode {
+		removeEnv(pod, "KUBERNETES_NODE")
+	}
+
+	// the pull secret is inherited from the default
+	// service account of the namespace.
+	if e.saPull {
+		pod.Spec.ImagePullSecrets = nil
+	}
+
+	if e.cpuEnv {
+		setCPUEnv(pod, step)
+	}
+
 	if e.node != "" {
 		pod.Spec.Affinity = &v1.Affinity{
 			NodeAffinity: &v1.NodeAffinity{
@@ -149,71 +618,267 @@ func (e *kubeEngine) Start(ctx context.Context, spec *engine.Spec, step *engine.
 		}
 	}
 
-	_, err := e.client.CoreV1().Pods(spec.Metadata.Namespace).Create(pod)
-	return err
+	// the local ssd requirement is added after the node
+	// affinity is configured, since the node affinity
+	// replaces the pod affinity.
+	if step.Docker.LocalSSD {
+		path := e.ssdPath
+		if path == "" {
+			path = defaultLocalSSDPath
+		}
+		setLocalSSD(pod, spec, path)
+	}
+
+	// the pod spec patch is applied last, so that it can
+	// override any field set by the engine. The patch can
+	// grant privileged access to the host (e.g. host paths,
+	// host network) and is therefore restricted to trusted
+	// pipelines.
+	if spec.Docker != nil && spec.Docker.PodSpecPatch != "" {
+		if !e.trusted {
+			return fmt.Errorf("kubernetes: pipeline %s is not trusted to patch the pod spec", spec.Metadata.Name)
+		}
+		if err := applyPodSpecPatch(pod, spec.Docker.PodSpecPatch); err != nil {
+			return err
+		}
+	}
+
+	for _, mutate := range e.mutators {
+		if err := mutate(pod); err != nil {
+			return err
+		}
+	}
+
+	if err := e.throttle(ctx); err != nil {
+		return err
+	}
+	var err error
+	if isJob(step) {
+		_, err = e.client.BatchV1().Jobs(spec.Metadata.Namespace).Create(toJob(step, pod))
+	} else {
+		_, err = e.client.CoreV1().Pods(spec.Metadata.Namespace).Create(pod)
+	}
+	if err != nil {
+		e.logger().Error("cannot create pod",
+			"namespace", spec.Metadata.Namespace,
+			"step", step.Metadata.Name,
+			"error", err)
+		return toError(err)
+	}
+	e.logger().Info("created pod",
+		"namespace", spec.Metadata.Namespace,
+		"step", step.Metadata.Name,
+		"pod", pod.Name)
+	return nil
+}
+
+// helper function returns true if the exit code is
+// configured as a successful exit code.
+func (e *kubeEngine) isSuccess(code int) bool {
+	for _, c := range e.success {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function blocks until the create rate limit
+// permits the next create call, if a rate limit is
+// configured, or the context is cancelled.
+func (e *kubeEngine) throttle(ctx context.Context) error {
+	if e.limiter == nil {
+		return nil
+	}
+	return e.limiter.Wait(ctx)
 }
 
 func (e *kubeEngine) Wait(ctx context.Context, spec *engine.Spec, step *engine.Step) (*engine.State, error) {
-	stopper := make(chan struct{})
-	updater := func(old interface{}, new interface{}) {
-		pod := new.(*v1.Pod)
-		// ignore events that do not come from the
-		// current pod namespace.
-		if pod.ObjectMeta.Namespace != step.Metadata.Namespace {
-			return
+	// the step timeout is applied on top of the parent
+	// context, so whichever deadline is shorter wins.
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	if isExternal(step) {
+		return &engine.State{Exited: true}, nil
+	}
+
+	for {
+		pod, done, err := e.stepPod(spec, step)
+		if err != nil {
+			return nil, err
 		}
-		if pod.Name == step.Metadata.UID {
-			switch pod.Status.Phase {
-			case v1.PodSucceeded, v1.PodFailed, v1.PodUnknown:
-				// TODO need to understand if this could be
-				// invoked multiple times.
-				select {
-				case stopper <- struct{}{}:
-				default:
+
+		// the pod completes once the log sidecar exits, which
+		// is signalled when the step container terminates.
+		if pod != nil && !done && hasLogSidecar(step) {
+			if status, ok := toStepStatus(pod); ok && status.State.Terminated != nil {
+				if err := e.stopLogs(pod, step); err != nil {
+					e.logger().Warn("cannot stop log sidecar",
+						"namespace", spec.Metadata.Namespace,
+						"step", step.Metadata.Name,
+						"error", err)
 				}
 			}
 		}
-	}
 
-	factory := informers.NewSharedInformerFactory(e.client, time.Second)
-	informer := factory.Core().V1().Pods().Informer()
-	informer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			UpdateFunc: updater,
-		},
-	)
-	factory.Start(wait.NeverStop)
+		if done {
+			// if an init container failed the step container
+			// never started, and the failure is reported as
+			// an error instead of a confusing exit code.
+			if err := checkInitContainers(pod); err != nil {
+				e.logger().Error("step init container failed",
+					"namespace", spec.Metadata.Namespace,
+					"step", step.Metadata.Name,
+					"error", err)
+				if e.keep && !step.IgnoreErr {
+					e.failed.Store(spec.Metadata.Namespace, true)
+				}
+				return nil, err
+			}
+			state := toState(pod)
+			e.logger().Info("step completed",
+				"namespace", spec.Metadata.Namespace,
+				"step", step.Metadata.Name,
+				"phase", pod.Status.Phase,
+				"exit_code", state.ExitCode)
 
-	// TODO Cancel on ctx.Done
-	<-stopper
+			// exit codes configured as successful are
+			// classified as such, so that the step passes
+			// while the exit code is reported unchanged.
+			state.Succeeded = state.ExitCode != 0 && e.isSuccess(state.ExitCode)
+			failed := state.ExitCode != 0 && !state.Succeeded
+			if e.keep && failed && !step.IgnoreErr {
+				e.failed.Store(spec.Metadata.Namespace, true)
+			}
+			if e.gc {
+				e.completed.Store(step.Metadata.UID, failed && !step.IgnoreErr)
+			}
+			return state, nil
+		}
 
-	pod, err := e.client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{
-		IncludeUninitialized: true,
-	})
+		// the pod is nil until the job controller creates
+		// the pod of a step executed as a job.
+		if pod != nil {
+			// if no node can satisfy the pod requirements the
+			// pod remains pending indefinitely. we fail fast,
+			// quoting the scheduler message, instead of waiting
+			// for the pipeline to timeout.
+			if err := checkSchedulable(pod, e.grace); err != nil {
+				e.logger().Error("step unschedulable",
+					"namespace", spec.Metadata.Namespace,
+					"step", step.Metadata.Name,
+					"error", err)
+				return nil, err
+			}
+
+			// if the image cannot be pulled, or cannot be pulled
+			// within the timeout, the pod is deleted to stop the
+			// kubelet from retrying.
+			if err := checkImagePull(pod); err != nil {
+				e.logger().Error("step image pull failed",
+					"namespace", spec.Metadata.Namespace,
+					"step", step.Metadata.Name,
+					"error", err)
+				e.deleteStep(spec, step)
+				return nil, err
+			}
+			if e.pull > 0 {
+				if err := checkPulling(pod, e.pull); err != nil {
+					e.deleteStep(spec, step)
+					return nil, err
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, engine.WrapError(engine.ErrTimeout, ctx.Err())
+			}
+			return nil, ctx.Err()
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// helper function returns the step pod, and whether the
+// step has completed. For steps executed as a job, the most
+// recent job pod is returned, which is nil until the job
+// creates a pod, and the step completes when the job
+// succeeds or exhausts its retries.
+func (e *kubeEngine) stepPod(spec *engine.Spec, step *engine.Step) (*v1.Pod, bool, error) {
+	if !isJob(step) {
+		pod, err := e.client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{
+			IncludeUninitialized: true,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		switch pod.Status.Phase {
+		case v1.PodSucceeded, v1.PodFailed, v1.PodUnknown:
+			return pod, true, nil
+		}
+		return pod, false, nil
+	}
+
+	job, err := e.client.BatchV1().Jobs(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	pod, err := e.jobPod(spec, step)
+	if err != nil {
+		return nil, false, err
+	}
+	done := isJobDone(job)
+	if done && pod == nil {
+		return nil, false, fmt.Errorf("kubernetes: job %s completed without a pod", step.Metadata.Name)
+	}
+	return pod, done, nil
+}
 
-	state := &engine.State{
-		ExitCode:  int(pod.Status.ContainerStatuses[0].State.Terminated.ExitCode),
-		Exited:    true,
-		OOMKilled: false,
+// helper function deletes the step pod, or the step job
+// and its pods, which stops the kubelet from retrying.
+func (e *kubeEngine) deleteStep(spec *engine.Spec, step *engine.Step) {
+	if !isJob(step) {
+		e.client.CoreV1().Pods(spec.Metadata.Namespace).Delete(
+			step.Metadata.UID,
+			&metav1.DeleteOptions{},
+		)
+		return
 	}
-	return state, nil
+	propagation := metav1.DeletePropagationBackground
+	e.client.BatchV1().Jobs(spec.Metadata.Namespace).Delete(
+		step.Metadata.UID,
+		&metav1.DeleteOptions{PropagationPolicy: &propagation},
+	)
 }
 
 func (e *kubeEngine) Tail(ctx context.Context, spec *engine.Spec, step *engine.Step) (io.ReadCloser, error) {
+	if isExternal(step) {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
 	ns := spec.Metadata.Namespace
 	podName := step.Metadata.UID
 
-	up := make(chan bool)
+	up := make(chan string, 1)
 
+	// the pods of a step executed as a job are named by
+	// the job controller, and are matched by label. Only
+	// the logs of the first pod are streamed.
 	var podUpdated = func(old interface{}, new interface{}) {
 		pod := new.(*v1.Pod)
-		if pod.Name == podName {
+		if pod.Name == podName || (isJob(step) && pod.Labels[jobLabel] == podName) {
 			switch pod.Status.Phase {
 			case v1.PodRunning, v1.PodSucceeded, v1.PodFailed:
-				up <- true
+				select {
+				case up <- pod.Name:
+				default:
+				}
 			}
 		}
 	}
@@ -227,24 +892,86 @@ func (e *kubeEngine) Tail(ctx context.Context, spec *engine.Spec, step *engine.S
 	si.Start(wait.NeverStop)
 
 	select {
-	case <-up:
+	case podName = <-up:
 	case <-ctx.Done():
 	}
 
 	opts := &v1.PodLogOptions{
 		Follow: true,
 	}
+	if hasLogSidecar(step) {
+		opts.Container = logContainerName(step)
+	}
 
-	return e.client.CoreV1().RESTClient().Get().
+	rc, err := e.client.CoreV1().RESTClient().Get().
 		Namespace(ns).
 		Name(podName).
 		Resource("pods").
 		SubResource("log").
 		VersionedParams(opts, scheme.ParameterCodec).
 		Stream()
+	if err != nil {
+		return nil, err
+	}
+	rc = engine.LimitLogs(rc, spec.LogLimitBytes)
+	if !e.gc {
+		return rc, nil
+	}
+	// the completed step pod is deleted once the log
+	// stream is closed, which ensures the logs are not
+	// truncated.
+	return &collectCloser{ReadCloser: rc, collect: func() {
+		e.collect(spec, step)
+	}}, nil
+}
+
+// collectCloser invokes the collect function when the log
+// stream is closed.
+type collectCloser struct {
+	io.ReadCloser
+	collect func()
+}
+
+func (c *collectCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.collect()
+	return err
+}
+
+// helper function deletes the pod of a completed step. The
+// pod of a failed step is kept if the engine is configured
+// to keep failed pipelines for inspection.
+func (e *kubeEngine) collect(spec *engine.Spec, step *engine.Step) {
+	failed, ok := e.completed.Load(step.Metadata.UID)
+	if !ok {
+		return
+	}
+	e.completed.Delete(step.Metadata.UID)
+	if e.keep && failed.(bool) {
+		return
+	}
+	e.deleteStep(spec, step)
+	e.logger().Info("deleted completed pod",
+		"namespace", spec.Metadata.Namespace,
+		"step", step.Metadata.Name)
 }
 
 func (e *kubeEngine) Destroy(ctx context.Context, spec *engine.Spec) error {
+	e.stopDeadline(spec.Metadata.Namespace)
+	for _, step := range spec.Steps {
+		e.started.Delete(step.Metadata.UID)
+	}
+
+	// if the pipeline failed, the namespace and pods are
+	// kept so that an operator can inspect the logs and
+	// exec into the containers.
+	if _, failed := e.failed.Load(spec.Metadata.Namespace); failed {
+		e.failed.Delete(spec.Metadata.Namespace)
+		e.logger().Warn("keeping namespace of failed pipeline",
+			"namespace", spec.Metadata.Namespace)
+		return nil
+	}
+
 	// err := e.client.CoreV1().PersistentVolumes().Delete(spec.Metadata.Namespace, nil)
 	// if err != nil {
 	// 	// TODO show error message
@@ -260,18 +987,150 @@ func (e *kubeEngine) Destroy(ctx context.Context, spec *engine.Spec) error {
 	// I am planning to switch to a persistent volume, but am
 	// leaving this in place as a temporary workaround in the short
 	// term.
-	os.RemoveAll(
-		filepath.Join(
-			"/tmp",
-			"drone",
-			spec.Metadata.Namespace,
-		),
-	)
+	os.RemoveAll(tempDir(spec, ""))
+	if e.node != "" && e.nodeDir {
+		os.RemoveAll(tempDir(spec, e.node))
+	}
+
+	// service pods are deleted, and given the chance to
+	// terminate gracefully, before the namespace is deleted.
+	e.stopServices(ctx, spec)
+
+	// the local ssd scratch directories are removed by a
+	// pod on each node, since the engine may not run on the
+	// nodes where the steps were scheduled.
+	if usesLocalSSD(spec) {
+		e.cleanLocalSSD(ctx, spec)
+	}
+
+	// note that destroy may be retried, in which case the
+	// objects may already be deleted.
+	if !e.quota.empty() {
+		err := e.client.CoreV1().ResourceQuotas(spec.Metadata.Namespace).Delete(
+			quotaName,
+			&metav1.DeleteOptions{},
+		)
+		if ignoreNotFound(err) != nil {
+			e.logger().Warn("cannot delete resource quota",
+				"namespace", spec.Metadata.Namespace, "error", err)
+		}
+	}
+
+	if e.policy != nil {
+		err := e.client.NetworkingV1().NetworkPolicies(spec.Metadata.Namespace).Delete(
+			policyName,
+			&metav1.DeleteOptions{},
+		)
+		if ignoreNotFound(err) != nil {
+			e.logger().Warn("cannot delete network policy",
+				"namespace", spec.Metadata.Namespace, "error", err)
+		}
+	}
 
 	// deleting the namespace should destroy all secrets,
-	// volumes, configuration files and more.
-	return e.client.CoreV1().Namespaces().Delete(
+	// volumes, configuration files and more. If the namespace
+	// has finalizers, the deletion is completed once the
+	// finalizers are removed by their controllers.
+	err := e.client.CoreV1().Namespaces().Delete(
 		spec.Metadata.Namespace,
 		&metav1.DeleteOptions{},
 	)
+	if apierrors.IsNotFound(err) {
+		e.logger().Info("namespace already deleted", "namespace", spec.Metadata.Namespace)
+		return nil
+	}
+	if err != nil {
+		e.logger().Error("cannot delete namespace",
+			"namespace", spec.Metadata.Namespace, "error", err)
+		return err
+	}
+	e.logger().Info("deleted namespace", "namespace", spec.Metadata.Namespace)
+	return nil
+}
+
+// helper function returns nil if the error indicates the
+// object is not found, for example because it is already
+// deleted.
+func ignoreNotFound(err error) error {
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// helper function schedules the deletion of the pipeline
+// namespace after the build deadline, which stops runaway
+// pipelines even if the runner hangs.
+func (e *kubeEngine) startDeadline(namespace string) {
+	timer := time.AfterFunc(e.deadline, func() {
+		e.deadlines.Delete(namespace)
+		e.logger().Warn("build deadline exceeded, deleting namespace",
+			"namespace", namespace)
+		err := e.client.CoreV1().Namespaces().Delete(namespace, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			e.logger().Error("cannot delete namespace",
+				"namespace", namespace, "error", err)
+		}
+	})
+	// if setup is retried the original deadline remains
+	// in effect.
+	if _, loaded := e.deadlines.LoadOrStore(namespace, timer); loaded {
+		timer.Stop()
+	}
+}
+
+// helper function stops the build deadline timer of the
+// pipeline namespace, if any.
+func (e *kubeEngine) stopDeadline(namespace string) {
+	if timer, ok := e.deadlines.Load(namespace); ok {
+		timer.(*time.Timer).Stop()
+		e.deadlines.Delete(namespace)
+	}
+}
+
+// helper function returns the engine logger, defaulting
+// to a logger that discards all output.
+func (e *kubeEngine) logger() Logger {
+	if e.log == nil {
+		return nopLogger{}
+	}
+	return e.log
+}
+
+// helper function deletes the detached service pods and
+// waits for the pods to terminate, which gives services the
+// opportunity to flush data and run their pre-stop hooks
+// within their termination grace period.
+func (e *kubeEngine) stopServices(ctx context.Context, spec *engine.Spec) {
+	pods := e.client.CoreV1().Pods(spec.Metadata.Namespace)
+
+	var names []string
+	for _, step := range spec.Steps {
+		if !step.Detach {
+			continue
+		}
+		err := pods.Delete(step.Metadata.UID, &metav1.DeleteOptions{})
+		if err == nil {
+			names = append(names, step.Metadata.UID)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, terminationTimeout)
+	defer cancel()
+	for _, name := range names {
+		for {
+			_, err := pods.Get(name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(e.interval):
+			}
+		}
+	}
 }
@@ -0,0 +1,1307 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestWait_Unschedulable(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodPending,
+		Conditions: []v1.PodCondition{
+			{
+				Type:               v1.PodScheduled,
+				Status:             v1.ConditionFalse,
+				Reason:             v1.PodReasonUnschedulable,
+				Message:            "0/5 nodes are available: 5 Insufficient memory.",
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+		},
+	}
+
+	e := &kubeEngine{
+		client: fake.NewSimpleClientset(pod),
+		grace:  time.Minute,
+	}
+	_, err := e.Wait(context.Background(), spec, step)
+	if err == nil {
+		t.Errorf("Expect unschedulable error")
+		return
+	}
+	if !strings.Contains(err.Error(), "0/5 nodes are available") {
+		t.Errorf("Expect scheduler message in error, got %q", err)
+	}
+}
+
+func TestWait_UnschedulableGrace(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodPending,
+		Conditions: []v1.PodCondition{
+			{
+				Type:               v1.PodScheduled,
+				Status:             v1.ConditionFalse,
+				Reason:             v1.PodReasonUnschedulable,
+				LastTransitionTime: metav1.Now(),
+			},
+		},
+	}
+
+	e := &kubeEngine{
+		client:   fake.NewSimpleClientset(pod),
+		grace:    time.Hour,
+		interval: time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := e.Wait(ctx, spec, step)
+	if !errors.Is(err, engine.ErrTimeout) {
+		t.Errorf("Want error %v within grace period, got %v", engine.ErrTimeout, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Want error %v within grace period, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWait_StepTimeout(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodRunning,
+	}
+
+	e := &kubeEngine{
+		client:   fake.NewSimpleClientset(pod),
+		interval: time.Millisecond,
+		timeout:  10 * time.Millisecond,
+	}
+	_, err := e.Wait(context.Background(), spec, step)
+	if !errors.Is(err, engine.ErrTimeout) {
+		t.Errorf("Want error %v, got %v", engine.ErrTimeout, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Want error %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWait_PullTimeout(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodPending,
+		Conditions: []v1.PodCondition{
+			{
+				Type:               v1.PodScheduled,
+				Status:             v1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+		},
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				Image: "alpine:3.6",
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"},
+				},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(pod)
+	e := &kubeEngine{
+		client: client,
+		pull:   time.Minute,
+	}
+	_, err := e.Wait(context.Background(), spec, step)
+	if err == nil {
+		t.Errorf("Expect pull timeout error")
+		return
+	}
+	if !strings.Contains(err.Error(), "timeout pulling image alpine:3.6") {
+		t.Errorf("Expect pull timeout error, got %q", err)
+	}
+	if !errors.Is(err, engine.ErrImagePull) {
+		t.Errorf("Want error %v, got %v", engine.ErrImagePull, err)
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect pod deleted after pull timeout")
+	}
+}
+
+func TestWait_PullTimeoutPending(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodPending,
+		Conditions: []v1.PodCondition{
+			{
+				Type:               v1.PodScheduled,
+				Status:             v1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+			},
+		},
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"},
+				},
+			},
+		},
+	}
+
+	e := &kubeEngine{
+		client:   fake.NewSimpleClientset(pod),
+		interval: time.Millisecond,
+		pull:     time.Hour,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := e.Wait(ctx, spec, step)
+	if !errors.Is(err, engine.ErrTimeout) {
+		t.Errorf("Want error %v within pull timeout, got %v", engine.ErrTimeout, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Want error %v within pull timeout, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWait_ImagePull(t *testing.T) {
+	for _, reason := range []string{"ErrImagePull", "ImagePullBackOff", "InvalidImageName"} {
+		spec, step := testSpec()
+		pod := testPod(spec, step)
+		pod.Status = v1.PodStatus{
+			Phase: v1.PodPending,
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Image: "alpine:3.6",
+					State: v1.ContainerState{
+						Waiting: &v1.ContainerStateWaiting{Reason: reason},
+					},
+				},
+			},
+		}
+
+		// the image pull failure is reported without
+		// configuring a pull timeout.
+		client := fake.NewSimpleClientset(pod)
+		e := &kubeEngine{client: client}
+		_, err := e.Wait(context.Background(), spec, step)
+		if !errors.Is(err, engine.ErrImagePull) {
+			t.Errorf("Want error %v for reason %s, got %v", engine.ErrImagePull, reason, err)
+		}
+		pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+		if len(pods.Items) != 0 {
+			t.Errorf("Expect pod deleted after image pull failure %s", reason)
+		}
+	}
+}
+
+func TestWait_OOMKilled(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodFailed,
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+				},
+			},
+		},
+	}
+
+	e := &kubeEngine{client: fake.NewSimpleClientset(pod)}
+	state, err := e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !errors.Is(state.Err(), engine.ErrOOMKilled) {
+		t.Errorf("Want error %v, got %v", engine.ErrOOMKilled, state.Err())
+	}
+}
+
+func TestWait(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodFailed,
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 2},
+				},
+			},
+		},
+	}
+
+	e := &kubeEngine{client: fake.NewSimpleClientset(pod)}
+	state, err := e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := state.ExitCode, 2; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if !state.Exited {
+		t.Errorf("Expect exited state")
+	}
+}
+
+func TestWait_SuccessExitCodes(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodFailed,
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 2},
+				},
+			},
+		},
+	}
+
+	e := &kubeEngine{client: fake.NewSimpleClientset(pod)}
+	WithSuccessExitCodes(2)(e)
+	state, err := e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := state.ExitCode, 2; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if !state.Succeeded {
+		t.Errorf("Expect exit code classified as successful")
+	}
+
+	// exit codes that are not configured are failures.
+	WithSuccessExitCodes(3)(e)
+	state, err = e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if state.Succeeded {
+		t.Errorf("Expect exit code classified as failed")
+	}
+}
+
+func TestWait_DeleteCompleted(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodSucceeded,
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 0},
+				},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(pod)
+	e := &kubeEngine{client: client}
+	WithDeleteCompleted(true)(e)
+	if _, err := e.Wait(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the pod is deleted when the log stream is closed.
+	rc := &collectCloser{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("")),
+		collect:    func() { e.collect(spec, step) },
+	}
+	rc.Close()
+
+	_, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Expect completed pod deleted, got %v", err)
+	}
+}
+
+func TestWait_DeleteCompletedKeepOnFailure(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodFailed,
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 1},
+				},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(pod)
+	e := &kubeEngine{client: client, keep: true}
+	WithDeleteCompleted(true)(e)
+	if _, err := e.Wait(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	e.collect(spec, step)
+
+	if _, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expect failed pod kept, got %v", err)
+	}
+}
+
+func TestWait_InitContainerFailed(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodFailed,
+		InitContainerStatuses: []v1.ContainerStatus{
+			{
+				Name: "files-" + step.Metadata.UID,
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 0},
+				},
+			},
+			{
+				Name: "mkdir-" + step.Metadata.UID,
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 1},
+				},
+			},
+		},
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"},
+				},
+			},
+		},
+	}
+
+	e := &kubeEngine{client: fake.NewSimpleClientset(pod)}
+	_, err := e.Wait(context.Background(), spec, step)
+	if err == nil {
+		t.Errorf("Expect init container failure")
+		return
+	}
+	if got, want := err.Error(), "kubernetes: init container mkdir-uid_8a7IJsL9zSJCCchd failed with exit code 1"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestWait_Conditions(t *testing.T) {
+	spec, step := testSpec()
+	scheduled := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	ready := scheduled.Add(time.Minute)
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodSucceeded,
+		Conditions: []v1.PodCondition{
+			{
+				Type:               v1.PodScheduled,
+				Status:             v1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(scheduled),
+			},
+			{
+				Type:               v1.PodReady,
+				Status:             v1.ConditionFalse,
+				Reason:             "PodCompleted",
+				LastTransitionTime: metav1.NewTime(ready),
+			},
+		},
+	}
+
+	e := &kubeEngine{client: fake.NewSimpleClientset(pod)}
+	state, err := e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := []*engine.Condition{
+		{Type: "PodScheduled", Status: "True", Time: scheduled},
+		{Type: "Ready", Status: "False", Time: ready, Reason: "PodCompleted"},
+	}
+	if diff := cmp.Diff(state.Conditions, want); diff != "" {
+		t.Errorf("Unexpected pod conditions")
+		t.Log(diff)
+	}
+}
+
+func TestStart_StrictReferences(t *testing.T) {
+	spec, step := testSpec()
+	step.Secrets = []*engine.SecretVar{
+		{Name: "password", Env: "PASSWORD"},
+	}
+
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client, strict: true}
+	err := e.Start(context.Background(), spec, step)
+	if err == nil {
+		t.Errorf("Expect missing reference error")
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect pod not created")
+	}
+}
+
+func TestStart_RestartPolicy(t *testing.T) {
+	for _, policy := range []engine.RestartPolicy{engine.RestartOnFailure, engine.RestartUnlessStopped} {
+		spec, step := testSpec()
+		step.Docker.Restart = policy
+		client := fake.NewSimpleClientset()
+		e := &kubeEngine{client: client}
+		if err := e.Start(context.Background(), spec, step); err == nil {
+			t.Errorf("Expect restart policy %s rejected", policy)
+		}
+		pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+		if len(pods.Items) != 0 {
+			t.Errorf("Expect no pod created with restart policy %s", policy)
+		}
+	}
+}
+
+func TestStart_DockerSockUntrusted(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.DockerSock = true
+
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	if err := e.Start(context.Background(), spec, step); err == nil {
+		t.Errorf("Expect error mounting docker socket in untrusted pipeline")
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect pod not created")
+	}
+}
+
+func TestStart_PodSpecPatch(t *testing.T) {
+	spec, step := testSpec()
+	spec.Docker.PodSpecPatch = `{"hostNetwork":true}`
+
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	if err := e.Start(context.Background(), spec, step); err == nil {
+		t.Errorf("Expect error patching pod spec in untrusted pipeline")
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect pod not created")
+	}
+
+	e.trusted = true
+	if err := e.Start(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	pod, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !pod.Spec.HostNetwork {
+		t.Errorf("Expect pod spec patch applied in trusted pipeline")
+	}
+}
+
+func TestStart_CreateRate(t *testing.T) {
+	spec, _ := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	WithCreateRate(20, 1)(e)
+
+	// the first create consumes the burst, and each
+	// subsequent create waits 50ms for the next token.
+	before := time.Now()
+	for i := 0; i < 3; i++ {
+		step := &engine.Step{
+			Metadata: engine.Metadata{UID: fmt.Sprintf("uid_%d", i), Name: fmt.Sprintf("step_%d", i)},
+			Docker:   &engine.DockerStep{Image: "alpine:3.8"},
+		}
+		if err := e.Start(context.Background(), spec, step); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if elapsed := time.Since(before); elapsed < 90*time.Millisecond {
+		t.Errorf("Want pod creation throttled to 20 per second, created 3 pods in %v", elapsed)
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if got, want := len(pods.Items), 3; got != want {
+		t.Errorf("Want %d pods created, got %d", want, got)
+	}
+}
+
+func TestStart_CreateRateCancel(t *testing.T) {
+	spec, step := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	WithCreateRate(0.001, 1)(e)
+	e.limiter.Allow() // consume the burst
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.Start(ctx, spec, step); err == nil {
+		t.Errorf("Expect error when the context is cancelled while throttled")
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect pod not created")
+	}
+}
+
+func TestStart_PodMutator(t *testing.T) {
+	spec, step := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	WithPodMutator(func(pod *v1.Pod) error {
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels["company.com/team"] = "platform"
+		return nil
+	})(e)
+
+	if err := e.Start(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	pod, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := pod.Labels["company.com/team"], "platform"; got != want {
+		t.Errorf("Want mutated pod label %q, got %q", want, got)
+	}
+}
+
+func TestStart_NodeEnv(t *testing.T) {
+	spec, step := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	WithNodeEnv(false)(e)
+
+	if err := e.Start(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	pod, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, env := range pod.Spec.Containers[0].Env {
+		if env.Name == "KUBERNETES_NODE" {
+			t.Errorf("Expect KUBERNETES_NODE absent when disabled")
+		}
+	}
+}
+
+func TestStart_ExternalName(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.Service = &engine.ServiceConfig{
+		ExternalName: "db.example.com",
+	}
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+
+	if err := e.Start(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	service, err := client.CoreV1().Services(spec.Metadata.Namespace).Get("greetings", metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := service.Spec.ExternalName, "db.example.com"; got != want {
+		t.Errorf("Want external name %s, got %s", want, got)
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect no pod created for external service")
+	}
+
+	state, err := e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !state.Exited || state.ExitCode != 0 {
+		t.Errorf("Expect external service step exited successfully")
+	}
+}
+
+func TestStart_PodMutatorError(t *testing.T) {
+	spec, step := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	WithPodMutator(func(pod *v1.Pod) error {
+		return errors.New("sidecar policy violation")
+	})(e)
+
+	if err := e.Start(context.Background(), spec, step); err == nil {
+		t.Errorf("Expect mutator error aborts pod creation")
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect pod not created")
+	}
+}
+
+func TestStart_Forbidden(t *testing.T) {
+	spec, step := testSpec()
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(
+			schema.GroupResource{Resource: "pods"},
+			step.Metadata.UID,
+			errors.New("exceeded quota"),
+		)
+	})
+	e := &kubeEngine{client: client}
+	err := e.Start(context.Background(), spec, step)
+	if !errors.Is(err, engine.ErrForbidden) {
+		t.Errorf("Want error %v, got %v", engine.ErrForbidden, err)
+	}
+}
+
+func TestStart_UserName(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.User = "nobody"
+
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	if err := e.Start(context.Background(), spec, step); err == nil {
+		t.Errorf("Expect error starting step with a user name")
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Expect pod not created")
+	}
+}
+
+func TestListPods(t *testing.T) {
+	spec, _ := testSpec()
+	spec.Steps = []*engine.Step{
+		{Metadata: engine.Metadata{UID: "uid_1"}},
+		{Metadata: engine.Metadata{UID: "uid_2"}},
+		{Metadata: engine.Metadata{UID: "uid_4"}},
+	}
+	labels := map[string]string{labelBuildID: spec.Metadata.UID}
+	client := fake.NewSimpleClientset(
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "uid_1", Namespace: spec.Metadata.Namespace, UID: "1", Labels: labels},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "uid_2", Namespace: spec.Metadata.Namespace, UID: "2", Labels: labels},
+			Status:     v1.PodStatus{Phase: v1.PodSucceeded},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "uid_3", Namespace: "ns_other", UID: "3", Labels: labels},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		},
+		// the job pod is reported with the step uid.
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "uid_4-x7k2p",
+				Namespace: spec.Metadata.Namespace,
+				UID:       "4",
+				Labels:    map[string]string{labelBuildID: spec.Metadata.UID, jobLabel: "uid_4"},
+			},
+			Status: v1.PodStatus{Phase: v1.PodFailed},
+		},
+		// helper pods are not step pods.
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: prePullName, Namespace: spec.Metadata.Namespace, UID: "5", Labels: labels},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		},
+		// pods of another build are excluded.
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "uid_4",
+				Namespace: spec.Metadata.Namespace,
+				UID:       "6",
+				Labels:    map[string]string{labelBuildID: "uid_other"},
+			},
+			Status: v1.PodStatus{Phase: v1.PodRunning},
+		},
+	)
+
+	pods, err := ListPods(context.Background(), &kubeEngine{client: client}, spec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := map[string]string{
+		"uid_1": "Running",
+		"uid_2": "Succeeded",
+		"uid_4": "Failed",
+	}
+	if got, want := len(pods), len(want); got != want {
+		t.Errorf("Want %d pods, got %d", want, got)
+		return
+	}
+	for _, pod := range pods {
+		if got, want := pod.Phase, want[pod.Name]; got != want {
+			t.Errorf("Want pod %s phase %s, got %s", pod.Name, want, got)
+		}
+	}
+}
+
+// this test verifies that the engine specific functions
+// accept an engine wrapped by the tracer.
+func TestListPods_Trace(t *testing.T) {
+	spec, step := testSpec()
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      step.Metadata.UID,
+			Namespace: spec.Metadata.Namespace,
+			Labels:    map[string]string{labelBuildID: spec.Metadata.UID},
+		},
+	})
+	tracer := func(ctx context.Context, op string, attrs map[string]string) (context.Context, func(error)) {
+		return ctx, func(error) {}
+	}
+	eng := engine.Trace(&kubeEngine{client: client}, tracer)
+	pods, err := ListPods(context.Background(), eng, spec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := len(pods), 1; got != want {
+		t.Errorf("Want %d pods, got %d", want, got)
+	}
+}
+
+func TestSetup_ServiceAccountPullSecret(t *testing.T) {
+	spec, step := testSpec()
+	spec.Docker.Auths = []*engine.DockerAuth{
+		{Address: "index.docker.io", Username: "octocat", Password: "correct-horse-battery-staple"},
+	}
+
+	client := fake.NewSimpleClientset(
+		&v1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: spec.Metadata.Namespace},
+			ImagePullSecrets: []v1.LocalObjectReference{
+				{Name: "mirror-credentials"},
+			},
+		},
+	)
+	e := &kubeEngine{client: client}
+	WithServiceAccountPullSecret(true)(e)
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+
+	account, err := client.CoreV1().ServiceAccounts(spec.Metadata.Namespace).Get("default", metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := []v1.LocalObjectReference{
+		{Name: "mirror-credentials"},
+		{Name: "docker-auth-config"},
+	}
+	if diff := cmp.Diff(account.ImagePullSecrets, want); diff != "" {
+		t.Errorf("Unexpected service account pull secrets")
+		t.Log(diff)
+	}
+
+	// pods inherit the pull secret from the service
+	// account, and do not reference it directly.
+	if err := e.Start(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	pod, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(pod.Spec.ImagePullSecrets) != 0 {
+		t.Errorf("Expect no pod pull secrets, got %v", pod.Spec.ImagePullSecrets)
+	}
+}
+
+func TestSetup_AlreadyExists(t *testing.T) {
+	spec, _ := testSpec()
+	spec.Secrets = []*engine.Secret{
+		{Metadata: engine.Metadata{Name: "password", UID: "f1a2b3"}, Data: "correct-horse-battery-staple"},
+	}
+	spec.Files = []*engine.File{
+		{Metadata: engine.Metadata{Name: "script", UID: "c4d5e6"}, Data: []byte("echo hello")},
+	}
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: spec.Metadata.Namespace},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "f1a2b3", Namespace: spec.Metadata.Namespace},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "c4d5e6", Namespace: spec.Metadata.Namespace},
+		},
+	)
+
+	e := &kubeEngine{client: client}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps(spec.Metadata.Namespace).Get("c4d5e6", metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := configMap.Data["c4d5e6"], "echo hello"; got != want {
+		t.Errorf("Want existing config map updated with %q, got %q", want, got)
+	}
+}
+
+func TestSetup_ResourceQuota(t *testing.T) {
+	spec, _ := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{
+		client: client,
+		quota:  &Quota{Pods: 10},
+	}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err := client.CoreV1().ResourceQuotas(spec.Metadata.Namespace).Get(quotaName, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("Expect resource quota created, got %v", err)
+	}
+
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = client.CoreV1().ResourceQuotas(spec.Metadata.Namespace).Get(quotaName, metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("Expect resource quota deleted")
+	}
+}
+
+func TestSetup_ExistingQuota(t *testing.T) {
+	spec, step := testSpec()
+	step.Resources = &engine.Resources{
+		Requests: &engine.ResourceObject{Memory: 512 * 1024 * 1024},
+	}
+	client := fake.NewSimpleClientset(&v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "team-quota",
+			Namespace: spec.Metadata.Namespace,
+		},
+		Status: v1.ResourceQuotaStatus{
+			Hard: v1.ResourceList{v1.ResourceRequestsMemory: resource.MustParse("1Gi")},
+			Used: v1.ResourceList{v1.ResourceRequestsMemory: resource.MustParse("768Mi")},
+		},
+	})
+	e := &kubeEngine{
+		client: client,
+		quota:  &Quota{Pods: 10},
+	}
+	err := e.Setup(context.Background(), spec)
+	if err == nil {
+		t.Errorf("Expect pre-flight quota check error")
+		return
+	}
+	want := "kubernetes: step greetings requires requests.memory 512Mi, which exceeds the 256Mi remaining in resource quota team-quota"
+	if got := err.Error(); got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestSetup_ExistingQuotaNotReplaced(t *testing.T) {
+	spec, step := testSpec()
+	step.Resources = &engine.Resources{
+		Requests: &engine.ResourceObject{Memory: 128 * 1024 * 1024},
+	}
+	client := fake.NewSimpleClientset(&v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "team-quota",
+			Namespace: spec.Metadata.Namespace,
+		},
+		Status: v1.ResourceQuotaStatus{
+			Hard: v1.ResourceList{v1.ResourceRequestsMemory: resource.MustParse("1Gi")},
+			Used: v1.ResourceList{v1.ResourceRequestsMemory: resource.MustParse("768Mi")},
+		},
+	})
+	e := &kubeEngine{
+		client: client,
+		quota:  &Quota{Pods: 10},
+	}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err := client.CoreV1().ResourceQuotas(spec.Metadata.Namespace).Get(quotaName, metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("Expect resource quota not created in namespace with existing quota")
+	}
+}
+
+// this test verifies that resource quotas are not accessed
+// unless the quota option is set, since the engine may not
+// be granted access to resource quotas.
+func TestSetup_NoQuota(t *testing.T) {
+	spec, _ := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "resourcequotas" {
+			t.Errorf("Expect resource quotas not accessed, got %s", action.GetVerb())
+		}
+	}
+}
+
+func TestDestroy_StopServices(t *testing.T) {
+	spec, step := testSpec()
+	service := &engine.Step{
+		Metadata: engine.Metadata{
+			UID:       "uid_2dCeS8f1Rzkd0aTn",
+			Namespace: spec.Metadata.Namespace,
+			Name:      "redis",
+		},
+		Detach: true,
+		Docker: &engine.DockerStep{Image: "redis:4"},
+	}
+	spec.Steps = append(spec.Steps, service)
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: spec.Metadata.Namespace},
+		},
+		testPod(spec, step),
+		testPod(spec, service),
+	)
+	e := &kubeEngine{client: client}
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var deletes []string
+	for _, action := range client.Actions() {
+		if action, ok := action.(ktesting.DeleteAction); ok {
+			deletes = append(deletes, action.GetResource().Resource+"/"+action.GetName())
+		}
+	}
+	want := []string{
+		"pods/" + service.Metadata.UID,
+		"namespaces/" + spec.Metadata.Namespace,
+	}
+	if diff := cmp.Diff(deletes, want); diff != "" {
+		t.Errorf("Unexpected delete order")
+		t.Log(diff)
+	}
+}
+
+func TestDestroy_AlreadyDeleted(t *testing.T) {
+	spec, step := testSpec()
+	step.Detach = true
+	logger := new(captureLogger)
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{
+		client: client,
+		log:    logger,
+		quota:  &Quota{Pods: 10},
+		policy: &NetworkPolicy{},
+	}
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	want := []string{
+		"INFO namespace already deleted [namespace ns_JVzesGoyteu5koZK]",
+	}
+	if diff := cmp.Diff(logger.lines, want); diff != "" {
+		t.Errorf("Unexpected log lines")
+		t.Log(diff)
+	}
+}
+
+func TestDestroy_KeepOnFailure(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodFailed,
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 1},
+				},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: spec.Metadata.Namespace},
+		},
+		pod,
+	)
+	e := &kubeEngine{client: client, keep: true}
+	if _, err := e.Wait(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Errorf("Expect no delete for failed pipeline, got %s %s",
+				action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestDestroy_KeepOnFailureSuccess(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodSucceeded,
+	}
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: spec.Metadata.Namespace},
+		},
+		pod,
+	)
+	e := &kubeEngine{client: client, keep: true}
+	if _, err := e.Wait(context.Background(), spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err := client.CoreV1().Namespaces().Get(spec.Metadata.Namespace, metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("Expect namespace deleted for successful pipeline")
+	}
+}
+
+func TestSetup_NamespaceTTL(t *testing.T) {
+	spec, _ := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client, ttl: time.Hour}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	ns, err := client.CoreV1().Namespaces().Get(spec.Metadata.Namespace, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expires, err := time.Parse(time.RFC3339, ns.Annotations[annotationExpires])
+	if err != nil {
+		t.Errorf("Expect namespace expiry annotation, got %v", err)
+		return
+	}
+	if d := time.Until(expires); d <= 0 || d > time.Hour {
+		t.Errorf("Want namespace expiry within one hour, got %v", expires)
+	}
+}
+
+func TestSetup_NamespaceFinalizers(t *testing.T) {
+	spec, _ := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client, finalizers: []string{"example.com/cost"}}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	ns, err := client.CoreV1().Namespaces().Get(spec.Metadata.Namespace, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(ns.Finalizers, []string{"example.com/cost"}); diff != "" {
+		t.Errorf("Unexpected namespace finalizers")
+		t.Log(diff)
+	}
+
+	// destroy initiates the namespace deletion, which is
+	// completed by the controller removing the finalizer.
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	var deleted bool
+	for _, action := range client.Actions() {
+		if action.Matches("delete", "namespaces") {
+			deleted = true
+		}
+	}
+	if !deleted {
+		t.Errorf("Expect namespace deletion initiated")
+	}
+}
+
+func TestSetup_BuildDeadline(t *testing.T) {
+	spec, _ := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client, ttl: time.Hour, deadline: 50 * time.Millisecond}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	ns, err := client.CoreV1().Namespaces().Get(spec.Metadata.Namespace, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expires, err := time.Parse(time.RFC3339, ns.Annotations[annotationExpires])
+	if err != nil {
+		t.Errorf("Expect namespace expiry annotation, got %v", err)
+		return
+	}
+	if d := time.Until(expires); d > time.Second {
+		t.Errorf("Want namespace expiry at the build deadline, got %v", expires)
+	}
+
+	// the namespace is deleted once the deadline passes.
+	for i := 0; i < 100; i++ {
+		_, err = client.CoreV1().Namespaces().Get(spec.Metadata.Namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expect namespace deleted past the build deadline")
+}
+
+func TestDestroy_StopsBuildDeadline(t *testing.T) {
+	spec, _ := testSpec()
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client, deadline: time.Hour}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := e.deadlines.Load(spec.Metadata.Namespace); !ok {
+		t.Errorf("Expect build deadline timer tracked")
+	}
+	e.Destroy(context.Background(), spec)
+	if _, ok := e.deadlines.Load(spec.Metadata.Namespace); ok {
+		t.Errorf("Expect build deadline timer stopped")
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ns_expired",
+				Annotations: map[string]string{
+					annotationExpires: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+				},
+			},
+		},
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ns_live",
+				Annotations: map[string]string{
+					annotationExpires: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+				},
+			},
+		},
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-system"},
+		},
+	)
+
+	reaped, err := ReapExpired(context.Background(), &kubeEngine{client: client})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(reaped, []string{"ns_expired"}); diff != "" {
+		t.Errorf("Unexpected reaped namespaces")
+		t.Log(diff)
+	}
+	list, _ := client.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if got, want := len(list.Items), 2; got != want {
+		t.Errorf("Want %d remaining namespaces, got %d", want, got)
+	}
+}
+
+// helper function returns a minimal spec and step for
+// testing purposes.
+func testSpec() (*engine.Spec, *engine.Step) {
+	step := &engine.Step{
+		Metadata: engine.Metadata{
+			UID:       "uid_8a7IJsL9zSJCCchd",
+			Namespace: "ns_JVzesGoyteu5koZK",
+			Name:      "greetings",
+		},
+		Docker: &engine.DockerStep{
+			Image: "alpine:3.6",
+		},
+	}
+	spec := &engine.Spec{
+		Metadata: engine.Metadata{
+			UID:       "uid_AOTCIPBf3XdTFs2j",
+			Namespace: "ns_JVzesGoyteu5koZK",
+			Name:      "test_hello_world",
+		},
+		Steps:  []*engine.Step{step},
+		Docker: &engine.DockerConfig{},
+	}
+	return spec, step
+}
+
+// helper function returns a minimal pod for the step.
+func testPod(spec *engine.Spec, step *engine.Step) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      step.Metadata.UID,
+			Namespace: spec.Metadata.Namespace,
+		},
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// logImage defines the image of the log sidecar.
+	logImage = "busybox:1"
+
+	// logVolume defines the name of the volume shared by
+	// the step container and the log sidecar.
+	logVolume = "drone-logs"
+
+	// logDone defines the name of the file that signals
+	// the log sidecar that the step container terminated.
+	logDone = ".drone-logs-done"
+)
+
+// helper function returns true if the step logs are read
+// from a file by a log sidecar.
+func hasLogSidecar(step *engine.Step) bool {
+	return step.Docker != nil && step.Docker.LogFile != ""
+}
+
+// helper function returns the name of the log sidecar.
+func logContainerName(step *engine.Step) string {
+	return "logs-" + step.Metadata.UID
+}
+
+// helper function adds a sidecar that writes the step log
+// file to stdout, from which the logs are streamed. The log
+// file directory is shared with the step container using
+// an empty directory volume. The sidecar exits once the
+// engine signals that the step container terminated.
+func setLogSidecar(pod *v1.Pod, step *engine.Step) {
+	if len(pod.Spec.Containers) == 0 {
+		return
+	}
+	file := step.Docker.LogFile
+	dir := path.Dir(file)
+	done := path.Join(dir, logDone)
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: logVolume,
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		},
+	})
+	mount := v1.VolumeMount{
+		Name:      logVolume,
+		MountPath: dir,
+	}
+	container := &pod.Spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+
+	script := fmt.Sprintf("touch %s; tail -n +1 -F %s & "+
+		"while [ ! -e %s ]; do sleep 1; done; sleep 1; kill $!",
+		file, file, done)
+	pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{
+		Name:            logContainerName(step),
+		Image:           logImage,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", script},
+		VolumeMounts:    []v1.VolumeMount{mount},
+	})
+}
+
+// helper function signals the log sidecar that the step
+// container terminated, after which the sidecar exits and
+// the pod completes.
+func (e *kubeEngine) stopLogs(pod *v1.Pod, step *engine.Step) error {
+	if e.exec == nil {
+		return fmt.Errorf("kubernetes: exec is not supported")
+	}
+	opts := &v1.PodExecOptions{
+		Container: logContainerName(step),
+		Command:   []string{"touch", path.Join(path.Dir(step.Docker.LogFile), logDone)},
+	}
+	return e.exec(pod.Namespace, pod.Name, opts, remotecommand.StreamOptions{})
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestSetLogSidecar(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.LogFile = "/var/log/build/output.log"
+	pod := toPod(spec, step)
+	setLogSidecar(pod, step)
+
+	if got, want := len(pod.Spec.Containers), 2; got != want {
+		t.Errorf("Want %d containers, got %d", want, got)
+		return
+	}
+	sidecar := pod.Spec.Containers[1]
+	if got, want := sidecar.Name, "logs-uid_8a7IJsL9zSJCCchd"; got != want {
+		t.Errorf("Want log sidecar name %s, got %s", want, got)
+	}
+	if script := sidecar.Command[2]; !strings.Contains(script, "tail -n +1 -F /var/log/build/output.log") {
+		t.Errorf("Want log sidecar to tail the log file, got %s", script)
+	}
+
+	mount := v1.VolumeMount{Name: logVolume, MountPath: "/var/log/build"}
+	if diff := cmp.Diff(sidecar.VolumeMounts, []v1.VolumeMount{mount}); diff != "" {
+		t.Errorf("Unexpected log sidecar volume mounts")
+		t.Log(diff)
+	}
+	mounts := pod.Spec.Containers[0].VolumeMounts
+	if diff := cmp.Diff(mounts[len(mounts)-1], mount); diff != "" {
+		t.Errorf("Unexpected step container log volume mount")
+		t.Log(diff)
+	}
+}
+
+func TestWait_LogSidecar(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.LogFile = "/var/log/build/output.log"
+	pod := toPod(spec, step)
+	setLogSidecar(pod, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodRunning,
+		ContainerStatuses: []v1.ContainerStatus{
+			{
+				Name: logContainerName(step),
+				State: v1.ContainerState{
+					Running: &v1.ContainerStateRunning{},
+				},
+			},
+			{
+				Name: step.Metadata.UID,
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 3},
+				},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(pod)
+	var opts *v1.PodExecOptions
+	e := &kubeEngine{
+		client:   client,
+		interval: time.Millisecond,
+		exec: func(ns, name string, o *v1.PodExecOptions, streams remotecommand.StreamOptions) error {
+			opts = o
+			// the sidecar exits, and the pod completes.
+			pod.Status.Phase = v1.PodFailed
+			_, err := client.CoreV1().Pods(ns).UpdateStatus(pod)
+			return err
+		},
+	}
+	state, err := e.Wait(context.Background(), spec, step)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if opts == nil {
+		t.Errorf("Expect log sidecar signalled")
+		return
+	}
+	if got, want := opts.Container, logContainerName(step); got != want {
+		t.Errorf("Want exec in container %s, got %s", want, got)
+	}
+	if diff := cmp.Diff(opts.Command, []string{"touch", "/var/log/build/.drone-logs-done"}); diff != "" {
+		t.Errorf("Unexpected log sidecar stop command")
+		t.Log(diff)
+	}
+	if got, want := state.ExitCode, 3; got != want {
+		t.Errorf("Want step container exit code %d, got %d", want, got)
+	}
+}
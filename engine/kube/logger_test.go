@@ -0,0 +1,73 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLogger(t *testing.T) {
+	spec, step := testSpec()
+	logger := new(captureLogger)
+	client := fake.NewSimpleClientset()
+	e := &kubeEngine{client: client, log: logger}
+
+	ctx := context.Background()
+	if err := e.Setup(ctx, spec); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := e.Start(ctx, spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// update the pod status to simulate completion.
+	pod, _ := client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+	pod.Status.Phase = v1.PodSucceeded
+	client.CoreV1().Pods(spec.Metadata.Namespace).UpdateStatus(pod)
+
+	if _, err := e.Wait(ctx, spec, step); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := e.Destroy(ctx, spec); err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []string{
+		"INFO created namespace [namespace ns_JVzesGoyteu5koZK]",
+		"INFO created pod [namespace ns_JVzesGoyteu5koZK step greetings pod uid_8a7IJsL9zSJCCchd]",
+		"INFO step completed [namespace ns_JVzesGoyteu5koZK step greetings phase Succeeded exit_code 0]",
+		"INFO deleted namespace [namespace ns_JVzesGoyteu5koZK]",
+	}
+	if diff := cmp.Diff(logger.lines, want); diff != "" {
+		t.Errorf("Unexpected log lines")
+		t.Log(diff)
+	}
+}
+
+// captureLogger is a Logger that captures log lines for
+// testing purposes.
+type captureLogger struct {
+	lines []string
+}
+
+func (l *captureLogger) Debug(msg string, keyvals ...interface{}) { l.log("DEBUG", msg, keyvals) }
+func (l *captureLogger) Info(msg string, keyvals ...interface{})  { l.log("INFO", msg, keyvals) }
+func (l *captureLogger) Warn(msg string, keyvals ...interface{})  { l.log("WARN", msg, keyvals) }
+func (l *captureLogger) Error(msg string, keyvals ...interface{}) { l.log("ERROR", msg, keyvals) }
+
+func (l *captureLogger) log(level, msg string, keyvals []interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf("%s %s %v", level, msg, keyvals))
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// logger returns the log stream of a pod container.
+type logger func(namespace, pod string, opts *v1.PodLogOptions) (io.ReadCloser, error)
+
+// helper function returns a logger that streams the logs
+// using the pod log subresource.
+func newLogger(client kubernetes.Interface) logger {
+	return func(namespace, pod string, opts *v1.PodLogOptions) (io.ReadCloser, error) {
+		return client.CoreV1().Pods(namespace).GetLogs(pod, opts).Stream()
+	}
+}
+
+// Logs returns the complete log of the step container. If
+// previous is true, the log of the previous container is
+// returned, which is useful if the container restarted.
+// Unlike Tail, the log is not followed, which can be used
+// to retrieve the logs of a completed step when streaming
+// was missed, for example after the runner is restarted.
+// The pod and container are resolved the same way as Tail,
+// and the log is truncated at the log limit of the spec.
+func Logs(ctx context.Context, eng engine.Engine, spec *engine.Spec, step *engine.Step, previous bool) (io.ReadCloser, error) {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return nil, fmt.Errorf("Not a valid Engine type")
+	}
+	if e.logs == nil {
+		return nil, fmt.Errorf("kubernetes: logs are not supported")
+	}
+
+	// the pods of a step executed as a job are named by
+	// the job controller, and the logs of the most recent
+	// pod are returned.
+	name := step.Metadata.UID
+	if isJob(step) {
+		pod, err := e.jobPod(spec, step)
+		if err != nil {
+			return nil, err
+		}
+		if pod == nil {
+			return nil, fmt.Errorf("kubernetes: job %s has not created a pod", step.Metadata.Name)
+		}
+		name = pod.Name
+	}
+
+	container := step.Metadata.UID
+	if hasLogSidecar(step) {
+		container = logContainerName(step)
+	}
+	rc, err := e.logs(spec.Metadata.Namespace, name, &v1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return engine.LimitLogs(rc, spec.LogLimitBytes), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLogs(t *testing.T) {
+	spec, step := testSpec()
+
+	var namespace, name string
+	var opts *v1.PodLogOptions
+	e := &kubeEngine{
+		logs: func(ns, pod string, o *v1.PodLogOptions) (io.ReadCloser, error) {
+			namespace, name, opts = ns, pod, o
+			return ioutil.NopCloser(strings.NewReader("hello\nworld\n")), nil
+		},
+	}
+
+	rc, err := Logs(context.Background(), e, spec, step, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer rc.Close()
+	out, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := string(out), "hello\nworld\n"; got != want {
+		t.Errorf("Want logs %q, got %q", want, got)
+	}
+	if got, want := namespace, spec.Metadata.Namespace; got != want {
+		t.Errorf("Want logs namespace %s, got %s", want, got)
+	}
+	if got, want := name, step.Metadata.UID; got != want {
+		t.Errorf("Want logs pod %s, got %s", want, got)
+	}
+	want := &v1.PodLogOptions{
+		Container: step.Metadata.UID,
+		Previous:  true,
+	}
+	if diff := cmp.Diff(opts, want); diff != "" {
+		t.Errorf("Unexpected log options")
+		t.Log(diff)
+	}
+}
+
+func TestLogs_Sidecar(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.LogFile = "/var/log/build/output.log"
+	spec.LogLimitBytes = 5
+
+	var opts *v1.PodLogOptions
+	e := &kubeEngine{
+		logs: func(ns, pod string, o *v1.PodLogOptions) (io.ReadCloser, error) {
+			opts = o
+			return ioutil.NopCloser(strings.NewReader("hello\nworld\n")), nil
+		},
+	}
+
+	rc, err := Logs(context.Background(), e, spec, step, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer rc.Close()
+	out, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := opts.Container, "logs-uid_8a7IJsL9zSJCCchd"; got != want {
+		t.Errorf("Want logs container %s, got %s", want, got)
+	}
+	if !strings.HasPrefix(string(out), "hello\n[log truncated") {
+		t.Errorf("Want logs truncated at the limit, got %q", out)
+	}
+}
+
+func TestLogs_Job(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.BackoffLimit = 2
+
+	pod := testPod(spec, step)
+	pod.Name = "uid_8a7IJsL9zSJCCchd-x7k2p"
+	pod.Labels = map[string]string{jobLabel: step.Metadata.UID}
+
+	var name string
+	e := &kubeEngine{
+		client: fake.NewSimpleClientset(pod),
+		logs: func(ns, p string, o *v1.PodLogOptions) (io.ReadCloser, error) {
+			name = p
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		},
+	}
+	rc, err := Logs(context.Background(), e, spec, step, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rc.Close()
+	if got, want := name, "uid_8a7IJsL9zSJCCchd-x7k2p"; got != want {
+		t.Errorf("Want logs of job pod %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/api/core/v1"
+)
+
+// Option configures a Kubernetes engine option.
+type Option func(*kubeEngine)
+
+// WithPollInterval sets the interval at which the engine
+// polls the pod status while waiting for a step.
+func WithPollInterval(d time.Duration) Option {
+	return func(e *kubeEngine) {
+		if d > 0 {
+			e.interval = d
+		}
+	}
+}
+
+// WithStepTimeout sets the maximum amount of time the
+// engine waits for a step to complete. A zero value
+// disables the timeout, deferring to the context deadline.
+func WithStepTimeout(d time.Duration) Option {
+	return func(e *kubeEngine) {
+		e.timeout = d
+	}
+}
+
+// WithPrePull configures the engine to pull the step images
+// sequentially on the build node before the steps are
+// scheduled, which avoids pulling many large images at once
+// on nodes with limited bandwidth. The images are pulled on
+// the engine node if configured, or any node otherwise.
+// The pre-pull is best effort, and failures are logged.
+func WithPrePull(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.prePull = enabled
+	}
+}
+
+// WithPullTimeout sets the maximum amount of time the
+// engine waits for the step image to be pulled, after which
+// the step fails. A zero value disables the timeout.
+func WithPullTimeout(d time.Duration) Option {
+	return func(e *kubeEngine) {
+		e.pull = d
+	}
+}
+
+// WithStrictReferences configures the engine to fail the
+// step if it references secrets, files or volumes that are
+// not defined, instead of silently ignoring them.
+func WithStrictReferences(strict bool) Option {
+	return func(e *kubeEngine) {
+		e.strict = strict
+	}
+}
+
+// WithNodeTempDir configures the engine to include the
+// node name in the host path used to emulate temporary
+// volumes. This option requires a node to be configured.
+func WithNodeTempDir(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.nodeDir = enabled
+	}
+}
+
+// WithExpandArgs configures the engine to substitute
+// ${DRONE_*} tokens in the step command and args with the
+// matching step environment variables.
+func WithExpandArgs(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.expand = enabled
+	}
+}
+
+// WithKeepOnFailure configures the engine to keep the
+// namespace and pods of a failed pipeline for inspection.
+// Kept namespaces must be deleted by the operator.
+func WithKeepOnFailure(keep bool) Option {
+	return func(e *kubeEngine) {
+		e.keep = keep
+	}
+}
+
+// WithNamespaceTTL configures the engine to annotate each
+// pipeline namespace with an expiry time, after which the
+// namespace is deleted by ReapExpired.
+func WithNamespaceTTL(d time.Duration) Option {
+	return func(e *kubeEngine) {
+		e.ttl = d
+	}
+}
+
+// WithNamespaceFinalizers configures the engine to add the
+// finalizers to each pipeline namespace, so that external
+// controllers, for example for cost reconciliation, can
+// complete their teardown before the namespace is removed.
+func WithNamespaceFinalizers(finalizers ...string) Option {
+	return func(e *kubeEngine) {
+		e.finalizers = finalizers
+	}
+}
+
+// WithBuildDeadline configures the engine to delete the
+// pipeline namespace once the build has run for longer than
+// d, as a backstop against runaway pipelines. The namespace
+// expiry is also shortened to the deadline, so that it is
+// deleted by ReapExpired if the runner stops.
+func WithBuildDeadline(d time.Duration) Option {
+	return func(e *kubeEngine) {
+		e.deadline = d
+	}
+}
+
+// WithShell sets the shell used to execute the step
+// commands. The default shell is /bin/sh with errexit.
+func WithShell(shell Shell) Option {
+	return func(e *kubeEngine) {
+		if shell.Path != "" {
+			e.shell = shell
+		}
+	}
+}
+
+// WithTrusted configures the engine to trust pipelines
+// with privileged access to the host, such as mounting the
+// host Docker socket.
+func WithTrusted(trusted bool) Option {
+	return func(e *kubeEngine) {
+		e.trusted = trusted
+	}
+}
+
+// WithLocalSSDPath sets the node path at which local solid
+// state storage is mounted, which is used as scratch space
+// by steps that require local storage.
+func WithLocalSSDPath(path string) Option {
+	return func(e *kubeEngine) {
+		if path != "" {
+			e.ssdPath = path
+		}
+	}
+}
+
+// WithCreateWorkingDir configures the engine to create the
+// step working directory using an init container, for
+// images that do not include the working directory.
+func WithCreateWorkingDir(create bool) Option {
+	return func(e *kubeEngine) {
+		e.mkdir = create
+	}
+}
+
+// WithLogger sets the logger used to log engine activity.
+// By default the engine does not log.
+func WithLogger(logger Logger) Option {
+	return func(e *kubeEngine) {
+		if logger != nil {
+			e.log = logger
+		}
+	}
+}
+
+// PodMutator modifies the step pod before it is created,
+// for example to add organization-specific labels or
+// sidecar containers. Returning an error aborts the pod
+// creation, and the error is returned to the caller.
+type PodMutator func(*v1.Pod) error
+
+// WithPodMutator registers a pod mutator that is invoked
+// before each step pod is created. Mutators are invoked in
+// the order they are registered, after the pod spec patch
+// is applied.
+func WithPodMutator(mutator PodMutator) Option {
+	return func(e *kubeEngine) {
+		if mutator != nil {
+			e.mutators = append(e.mutators, mutator)
+		}
+	}
+}
+
+// WithHostZoneInfo configures the engine to mount the host
+// timezone database into the pods of steps that define a
+// timezone, for images that do not include the database.
+func WithHostZoneInfo(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.zoneinfo = enabled
+	}
+}
+
+// WithNodeEnv configures the engine to expose the node
+// name to the step containers in the KUBERNETES_NODE
+// environment variable. This is enabled by default.
+func WithNodeEnv(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.noNode = !enabled
+	}
+}
+
+// WithCPUEnv configures the engine to size the Go and Java
+// runtimes of steps with a cpu limit to the limit, instead
+// of the node core count, by setting the GOMAXPROCS and
+// JAVA_TOOL_OPTIONS environment variables.
+func WithCPUEnv(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.cpuEnv = enabled
+	}
+}
+
+// WithSuccessExitCodes configures the engine to treat the
+// step exit codes as successful, in addition to zero, for
+// tools that exit with a non-zero code on warnings. The
+// exit code of the step is reported unchanged, and the
+// state is marked as succeeded. This option is specific
+// to the Kubernetes engine; the Docker engine only treats
+// zero as successful.
+func WithSuccessExitCodes(codes ...int) Option {
+	return func(e *kubeEngine) {
+		e.success = codes
+	}
+}
+
+// WithServiceAccountPullSecret configures the engine to add
+// the registry credentials to the default service account of
+// the pipeline namespace, instead of referencing them from
+// each pod, so that all pods in the namespace inherit them.
+// This modifies the default service account.
+func WithServiceAccountPullSecret(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.saPull = enabled
+	}
+}
+
+// WithDeleteCompleted configures the engine to delete the
+// pod of each step once the step completes and its logs are
+// streamed, instead of when the pipeline is destroyed, which
+// reduces the number of pods in long pipelines. Failed pods
+// are kept if the engine keeps failed pipelines.
+func WithDeleteCompleted(enabled bool) Option {
+	return func(e *kubeEngine) {
+		e.gc = enabled
+	}
+}
+
+// WithCreateRate configures the engine to limit the rate
+// at which pods are created to qps creates per second, with
+// bursts of up to burst creates, which smooths the load on
+// the api server when many steps start at once. A zero qps
+// disables the rate limit.
+func WithCreateRate(qps float64, burst int) Option {
+	return func(e *kubeEngine) {
+		if qps <= 0 {
+			e.limiter = nil
+			return
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		e.limiter = rate.NewLimiter(rate.Limit(qps), burst)
+	}
+}
+
+// WithClientRate sets the rate limit of the Kubernetes
+// client to qps requests per second, with bursts of up to
+// burst requests. The client default of 5 requests per
+// second is too low for runners that execute many steps
+// concurrently. A zero value keeps the client default.
+func WithClientRate(qps float32, burst int) Option {
+	return func(e *kubeEngine) {
+		e.qps = qps
+		e.burst = burst
+	}
+}
+
+// WithServiceConcurrency configures the engine to start
+// the service pods concurrently during setup, with at most
+// n pods starting at once, and to wait for the service pods
+// to become ready. A zero value disables this behavior.
+func WithServiceConcurrency(n int) Option {
+	return func(e *kubeEngine) {
+		e.services = n
+	}
+}
+
+// WithResourceQuota configures the engine to create a
+// resource quota in each pipeline namespace, capping the
+// total resources consumed by the pipeline.
+func WithResourceQuota(quota Quota) Option {
+	return func(e *kubeEngine) {
+		e.quota = &quota
+	}
+}
+
+// WithLimitRange configures the engine to create a limit
+// range in each pipeline namespace, defaulting and capping
+// the resources of each container.
+func WithLimitRange(limits LimitRange) Option {
+	return func(e *kubeEngine) {
+		e.limits = &limits
+	}
+}
+
+// WithNetworkPolicy configures the engine to create a
+// network policy in each pipeline namespace, isolating
+// the pipeline pods from the cluster.
+func WithNetworkPolicy(policy NetworkPolicy) Option {
+	return func(e *kubeEngine) {
+		e.policy = &policy
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestWithPollInterval(t *testing.T) {
+	e := &kubeEngine{interval: defaultPollInterval}
+	WithPollInterval(time.Millisecond)(e)
+	if got, want := e.interval, time.Millisecond; got != want {
+		t.Errorf("Want poll interval %v, got %v", want, got)
+	}
+	WithPollInterval(0)(e)
+	if got, want := e.interval, time.Millisecond; got != want {
+		t.Errorf("Want zero poll interval ignored, got %v", got)
+	}
+}
+
+func TestWithStepTimeout(t *testing.T) {
+	e := new(kubeEngine)
+	WithStepTimeout(time.Minute)(e)
+	if got, want := e.timeout, time.Minute; got != want {
+		t.Errorf("Want step timeout %v, got %v", want, got)
+	}
+}
+
+func TestWithPullTimeout(t *testing.T) {
+	e := new(kubeEngine)
+	WithPullTimeout(time.Minute)(e)
+	if got, want := e.pull, time.Minute; got != want {
+		t.Errorf("Want pull timeout %v, got %v", want, got)
+	}
+}
+
+func TestWithStrictReferences(t *testing.T) {
+	e := new(kubeEngine)
+	WithStrictReferences(true)(e)
+	if !e.strict {
+		t.Errorf("Want strict references enabled")
+	}
+}
+
+func TestWithNodeTempDir(t *testing.T) {
+	e := new(kubeEngine)
+	WithNodeTempDir(true)(e)
+	if !e.nodeDir {
+		t.Errorf("Want node temp dir enabled")
+	}
+}
+
+func TestWithHostZoneInfo(t *testing.T) {
+	e := new(kubeEngine)
+	WithHostZoneInfo(true)(e)
+	if !e.zoneinfo {
+		t.Errorf("Want host zoneinfo enabled")
+	}
+}
+
+func TestWithNodeEnv(t *testing.T) {
+	e := new(kubeEngine)
+	if e.noNode {
+		t.Errorf("Want node env enabled by default")
+	}
+	WithNodeEnv(false)(e)
+	if !e.noNode {
+		t.Errorf("Want node env disabled")
+	}
+}
+
+func TestWithCPUEnv(t *testing.T) {
+	e := new(kubeEngine)
+	WithCPUEnv(true)(e)
+	if !e.cpuEnv {
+		t.Errorf("Want cpu env enabled")
+	}
+}
+
+func TestWithBuildDeadline(t *testing.T) {
+	e := new(kubeEngine)
+	WithBuildDeadline(time.Hour)(e)
+	if got, want := e.deadline, time.Hour; got != want {
+		t.Errorf("Want build deadline %v, got %v", want, got)
+	}
+}
+
+func TestWithSuccessExitCodes(t *testing.T) {
+	e := new(kubeEngine)
+	WithSuccessExitCodes(2, 3)(e)
+	if !e.isSuccess(2) || !e.isSuccess(3) {
+		t.Errorf("Want exit codes 2 and 3 successful")
+	}
+	if e.isSuccess(1) {
+		t.Errorf("Want exit code 1 unsuccessful")
+	}
+}
+
+func TestWithServiceAccountPullSecret(t *testing.T) {
+	e := new(kubeEngine)
+	WithServiceAccountPullSecret(true)(e)
+	if !e.saPull {
+		t.Errorf("Want service account pull secret enabled")
+	}
+}
+
+func TestWithNamespaceFinalizers(t *testing.T) {
+	e := new(kubeEngine)
+	WithNamespaceFinalizers("example.com/cost")(e)
+	if len(e.finalizers) != 1 || e.finalizers[0] != "example.com/cost" {
+		t.Errorf("Want namespace finalizers [example.com/cost], got %v", e.finalizers)
+	}
+}
+
+func TestWithPrePull(t *testing.T) {
+	e := new(kubeEngine)
+	WithPrePull(true)(e)
+	if !e.prePull {
+		t.Errorf("Want images pre-pulled")
+	}
+}
+
+func TestWithDeleteCompleted(t *testing.T) {
+	e := new(kubeEngine)
+	WithDeleteCompleted(true)(e)
+	if !e.gc {
+		t.Errorf("Want completed pods deleted")
+	}
+}
+
+func TestWithCreateRate(t *testing.T) {
+	e := new(kubeEngine)
+	WithCreateRate(10, 5)(e)
+	if e.limiter == nil {
+		t.Errorf("Want create rate limiter")
+		return
+	}
+	if got, want := float64(e.limiter.Limit()), 10.0; got != want {
+		t.Errorf("Want create rate %v, got %v", want, got)
+	}
+	if got, want := e.limiter.Burst(), 5; got != want {
+		t.Errorf("Want create burst %v, got %v", want, got)
+	}
+
+	WithCreateRate(0, 0)(e)
+	if e.limiter != nil {
+		t.Errorf("Want create rate limiter disabled")
+	}
+}
+
+func TestWithClientRate(t *testing.T) {
+	e := new(kubeEngine)
+	WithClientRate(50, 100)(e)
+
+	config := &rest.Config{QPS: 5, Burst: 10}
+	e.configure(config)
+	if got, want := config.QPS, float32(50); got != want {
+		t.Errorf("Want client qps %v, got %v", want, got)
+	}
+	if got, want := config.Burst, 100; got != want {
+		t.Errorf("Want client burst %v, got %v", want, got)
+	}
+}
+
+func TestWithClientRate_Default(t *testing.T) {
+	e := new(kubeEngine)
+	config := &rest.Config{QPS: 5, Burst: 10}
+	e.configure(config)
+	if got, want := config.QPS, float32(5); got != want {
+		t.Errorf("Want default client qps %v, got %v", want, got)
+	}
+	if got, want := config.Burst, 10; got != want {
+		t.Errorf("Want default client burst %v, got %v", want, got)
+	}
+}
+
+func TestWithPodMutator(t *testing.T) {
+	e := new(kubeEngine)
+	WithPodMutator(func(*v1.Pod) error { return nil })(e)
+	WithPodMutator(nil)(e)
+	if got, want := len(e.mutators), 1; got != want {
+		t.Errorf("Want %d pod mutators, got %d", want, got)
+	}
+}
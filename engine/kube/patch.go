@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/api/core/v1"
+)
+
+// helper function applies the json merge patch (RFC 7386)
+// to the pod spec. An error is returned if the patch cannot
+// be parsed, or if the patched pod spec removes the step
+// container, or the name or image of any container.
+func applyPodSpecPatch(pod *v1.Pod, patch string) error {
+	var p interface{}
+	if err := json.Unmarshal([]byte(patch), &p); err != nil {
+		return fmt.Errorf("kubernetes: invalid pod spec patch: %s", err)
+	}
+	if _, ok := p.(map[string]interface{}); !ok {
+		return fmt.Errorf("kubernetes: invalid pod spec patch: not a json object")
+	}
+
+	raw, err := json.Marshal(pod.Spec)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	raw, err = json.Marshal(mergePatch(doc, p))
+	if err != nil {
+		return err
+	}
+	spec := v1.PodSpec{}
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return fmt.Errorf("kubernetes: invalid pod spec patch: %s", err)
+	}
+
+	var found bool
+	for _, container := range spec.Containers {
+		if container.Name == "" || container.Image == "" {
+			return fmt.Errorf("kubernetes: invalid pod spec patch: container name and image are required")
+		}
+		if len(pod.Spec.Containers) != 0 && container.Name == pod.Spec.Containers[0].Name {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("kubernetes: invalid pod spec patch: step container is required")
+	}
+	pod.Spec = spec
+	return nil
+}
+
+// helper function merges the json merge patch into the
+// json document. Null values in the patch remove the
+// matching field, and arrays are replaced.
+func mergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+			continue
+		}
+		d[k] = mergePatch(d[k], v)
+	}
+	return d
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyPodSpecPatch(t *testing.T) {
+	spec, step := testSpec()
+	pod := toPod(spec, step)
+	want := toPod(spec, step)
+	want.Spec.NodeSelector = map[string]string{"disktype": "ssd"}
+
+	err := applyPodSpecPatch(pod, `{"nodeSelector":{"disktype":"ssd"}}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(pod, want); diff != "" {
+		t.Errorf("Unexpected patched pod")
+		t.Log(diff)
+	}
+}
+
+func TestApplyPodSpecPatch_RemoveField(t *testing.T) {
+	spec, step := testSpec()
+	pod := toPod(spec, step)
+	pod.Spec.NodeSelector = map[string]string{"disktype": "ssd"}
+
+	err := applyPodSpecPatch(pod, `{"nodeSelector":null}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(pod.Spec.NodeSelector) != 0 {
+		t.Errorf("Expect node selector removed by null patch value")
+	}
+}
+
+func TestApplyPodSpecPatch_Invalid(t *testing.T) {
+	tests := []string{
+		`{"nodeSelector":`,
+		`["nodeSelector"]`,
+		`{"nodeSelector":"ssd"}`,
+		`{"containers":null}`,
+		`{"containers":[{"name":"uid_8a7IJsL9zSJCCchd"}]}`,
+		`{"containers":[{"name":"sidecar","image":"alpine:3.8"}]}`,
+	}
+	for _, patch := range tests {
+		spec, step := testSpec()
+		pod := toPod(spec, step)
+		want := toPod(spec, step)
+		if err := applyPodSpecPatch(pod, patch); err == nil {
+			t.Errorf("Expect error applying patch %s", patch)
+		}
+		if diff := cmp.Diff(pod, want); diff != "" {
+			t.Errorf("Expect pod unchanged by invalid patch %s", patch)
+			t.Log(diff)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"net"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// policyName is the name of the network policy created in
+// the pipeline namespace.
+const policyName = "drone-isolation"
+
+// defaultExceptCIDRs defines the private address ranges
+// that pipeline pods cannot reach by default.
+var defaultExceptCIDRs = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+}
+
+// NetworkPolicy defines the network isolation of pipeline
+// pods. Pods may reach each other and the cluster DNS, and
+// may reach the allowed address ranges with the exception
+// of the excluded address ranges. All other traffic,
+// including ingress from outside the pipeline, is denied.
+type NetworkPolicy struct {
+	// Allow lists the address ranges pipeline pods may
+	// reach. Defaults to all addresses.
+	Allow []string
+
+	// Except lists the address ranges pipeline pods cannot
+	// reach. Defaults to the private address ranges.
+	Except []string
+}
+
+// helper function returns the kubernetes network policy
+// for the given specification.
+func toNetworkPolicy(spec *engine.Spec, policy *NetworkPolicy) *networkingv1.NetworkPolicy {
+	allow := policy.Allow
+	if len(allow) == 0 {
+		allow = []string{"0.0.0.0/0"}
+	}
+	except := policy.Except
+	if except == nil {
+		except = defaultExceptCIDRs
+	}
+
+	// allow traffic between the pipeline pods, which is
+	// required to communicate with service containers.
+	pipeline := networkingv1.NetworkPolicyPeer{
+		PodSelector: &metav1.LabelSelector{},
+	}
+
+	// allow dns lookups in any namespace, since the cluster
+	// dns server typically resides in the kube-system
+	// namespace, on a private address.
+	udp, tcp := v1.ProtocolUDP, v1.ProtocolTCP
+	dns := intstr.FromInt(53)
+	lookup := networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{
+			{NamespaceSelector: &metav1.LabelSelector{}},
+		},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &dns},
+			{Protocol: &tcp, Port: &dns},
+		},
+	}
+
+	external := networkingv1.NetworkPolicyEgressRule{}
+	for _, cidr := range allow {
+		external.To = append(external.To, networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{
+				CIDR:   cidr,
+				Except: exceptWithin(cidr, except),
+			},
+		})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      policyName,
+			Namespace: spec.Metadata.Namespace,
+			Labels:    toLabels(spec, nil),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			// an empty selector selects all pods in the
+			// pipeline namespace.
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+				networkingv1.PolicyTypeEgress,
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{From: []networkingv1.NetworkPolicyPeer{pipeline}},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{To: []networkingv1.NetworkPolicyPeer{pipeline}},
+				lookup,
+				external,
+			},
+		},
+	}
+}
+
+// helper function returns the address ranges that are
+// contained within the cidr. Kubernetes rejects network
+// policies with exceptions outside of the address block.
+func exceptWithin(cidr string, except []string) []string {
+	_, block, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	size, _ := block.Mask.Size()
+	var within []string
+	for _, s := range except {
+		ip, network, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+		if n, _ := network.Mask.Size(); n > size && block.Contains(ip) {
+			within = append(within, s)
+		}
+	}
+	return within
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestToNetworkPolicy(t *testing.T) {
+	spec, _ := testSpec()
+	policy := toNetworkPolicy(spec, &NetworkPolicy{})
+
+	if got, want := policy.Namespace, spec.Metadata.Namespace; got != want {
+		t.Errorf("Want policy namespace %s, got %s", want, got)
+	}
+	if diff := cmp.Diff(policy.Spec.PodSelector, metav1.LabelSelector{}); diff != "" {
+		t.Errorf("Expect policy selects all pipeline pods")
+		t.Log(diff)
+	}
+
+	pipeline := networkingv1.NetworkPolicyPeer{
+		PodSelector: &metav1.LabelSelector{},
+	}
+	udp, tcp := v1.ProtocolUDP, v1.ProtocolTCP
+	dns := intstr.FromInt(53)
+	want := []networkingv1.NetworkPolicyEgressRule{
+		{
+			To: []networkingv1.NetworkPolicyPeer{pipeline},
+		},
+		{
+			To: []networkingv1.NetworkPolicyPeer{
+				{NamespaceSelector: &metav1.LabelSelector{}},
+			},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dns},
+				{Protocol: &tcp, Port: &dns},
+			},
+		},
+		{
+			To: []networkingv1.NetworkPolicyPeer{
+				{
+					IPBlock: &networkingv1.IPBlock{
+						CIDR:   "0.0.0.0/0",
+						Except: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(policy.Spec.Egress, want); diff != "" {
+		t.Errorf("Unexpected egress rules")
+		t.Log(diff)
+	}
+
+	ingress := []networkingv1.NetworkPolicyIngressRule{
+		{From: []networkingv1.NetworkPolicyPeer{pipeline}},
+	}
+	if diff := cmp.Diff(policy.Spec.Ingress, ingress); diff != "" {
+		t.Errorf("Unexpected ingress rules")
+		t.Log(diff)
+	}
+}
+
+func TestToNetworkPolicy_CIDRs(t *testing.T) {
+	spec, _ := testSpec()
+	policy := toNetworkPolicy(spec, &NetworkPolicy{
+		Allow:  []string{"10.0.0.0/8", "203.0.113.0/24"},
+		Except: []string{"10.96.0.0/12"},
+	})
+	want := []networkingv1.NetworkPolicyPeer{
+		{
+			IPBlock: &networkingv1.IPBlock{
+				CIDR:   "10.0.0.0/8",
+				Except: []string{"10.96.0.0/12"},
+			},
+		},
+		{
+			IPBlock: &networkingv1.IPBlock{
+				CIDR: "203.0.113.0/24",
+			},
+		},
+	}
+	if diff := cmp.Diff(policy.Spec.Egress[2].To, want); diff != "" {
+		t.Errorf("Unexpected egress address blocks")
+		t.Log(diff)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// prePullName defines the name of the pod that pulls
+	// the step images before the steps are scheduled.
+	prePullName = "drone-prepull"
+
+	// prePullPath defines the container path at which the
+	// static busybox binary is mounted, which is executed
+	// in place of the image entrypoint once the image is
+	// pulled.
+	prePullPath = "/drone-prepull"
+)
+
+// helper function returns the step images in step order.
+// Each image is returned once. Images that are never pulled
+// are excluded.
+func toPrePullImages(spec *engine.Spec) []string {
+	var images []string
+	seen := map[string]bool{}
+	for _, step := range spec.Steps {
+		if step.Docker == nil || step.Docker.Image == "" || isExternal(step) {
+			continue
+		}
+		if step.Docker.PullPolicy == engine.PullNever {
+			continue
+		}
+		image := engine.MirrorImage(spec, step.Docker.Image)
+		if seen[image] {
+			continue
+		}
+		seen[image] = true
+		images = append(images, image)
+	}
+	return images
+}
+
+// helper function returns the pod that pulls the step
+// images. Init containers run sequentially, so that the
+// images are pulled one at a time. Each init container
+// executes a static busybox binary, copied from the first
+// init container, since the images may not include a shell.
+func toPrePullPod(spec *engine.Spec, images []string) *v1.Pod {
+	mounts := []v1.VolumeMount{{
+		Name:      prePullName,
+		MountPath: prePullPath,
+	}}
+	busybox := path.Join(prePullPath, "busybox")
+	initContainers := []v1.Container{{
+		Name:            "prepull-busybox",
+		Image:           workingDirImage,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Command:         []string{"cp", "/bin/busybox", busybox},
+		VolumeMounts:    mounts,
+		Resources:       helperResources,
+	}}
+	for i, image := range images {
+		initContainers = append(initContainers, v1.Container{
+			Name:            fmt.Sprintf("prepull-%d", i),
+			Image:           image,
+			ImagePullPolicy: v1.PullIfNotPresent,
+			Command:         []string{busybox, "true"},
+			VolumeMounts:    mounts,
+			Resources:       helperResources,
+		})
+	}
+
+	var pullSecrets []v1.LocalObjectReference
+	if spec.Docker != nil && len(spec.Docker.Auths) > 0 {
+		pullSecrets = []v1.LocalObjectReference{{
+			Name: "docker-auth-config",
+		}}
+	}
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prePullName,
+			Namespace: spec.Metadata.Namespace,
+			Labels:    toLabels(spec, nil),
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy:    v1.RestartPolicyNever,
+			InitContainers:   initContainers,
+			ImagePullSecrets: pullSecrets,
+			Containers: []v1.Container{{
+				Name:            "prepull",
+				Image:           workingDirImage,
+				ImagePullPolicy: v1.PullIfNotPresent,
+				Command:         []string{"true"},
+				Resources:       helperResources,
+			}},
+			Volumes: []v1.Volume{{
+				Name: prePullName,
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			}},
+		},
+	}
+}
+
+// helper function pulls the step images sequentially on
+// the build node, and waits for the images to be pulled.
+// The pre-pull is best effort: if the pod cannot be created,
+// scheduled or pull an image within the pull timeout, the
+// failure is logged and the images are pulled when the
+// steps are started. An error is only returned if the
+// context is cancelled.
+func (e *kubeEngine) pullImages(ctx context.Context, spec *engine.Spec) error {
+	images := toPrePullImages(spec)
+	if len(images) == 0 {
+		return nil
+	}
+	pod := toPrePullPod(spec, images)
+	if e.saPull {
+		pod.Spec.ImagePullSecrets = nil
+	}
+	pod.Spec.NodeName = e.node
+
+	// note that setup may be retried, in which case the
+	// pre-pull pod may already exist.
+	pods := e.client.CoreV1().Pods(spec.Metadata.Namespace)
+	_, err := pods.Create(pod)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		e.logger().Warn("cannot create pre-pull pod",
+			"namespace", spec.Metadata.Namespace,
+			"error", err)
+		return nil
+	}
+	defer pods.Delete(prePullName, &metav1.DeleteOptions{})
+
+	for {
+		pod, err := pods.Get(prePullName, metav1.GetOptions{})
+		if err != nil {
+			e.logger().Warn("cannot get pre-pull pod",
+				"namespace", spec.Metadata.Namespace,
+				"error", err)
+			return nil
+		}
+		switch pod.Status.Phase {
+		case v1.PodSucceeded, v1.PodFailed:
+			e.logger().Info("pulled images",
+				"namespace", spec.Metadata.Namespace,
+				"images", len(images))
+			return nil
+		}
+		if image, ok := failedPull(pod); ok {
+			e.logger().Warn("cannot pre-pull image",
+				"namespace", spec.Metadata.Namespace,
+				"image", image)
+			return nil
+		}
+		if err := checkSchedulable(pod, e.grace); err != nil {
+			e.logger().Warn("cannot schedule pre-pull pod",
+				"namespace", spec.Metadata.Namespace,
+				"error", err)
+			return nil
+		}
+		if e.pull > 0 {
+			if err := checkPulling(pod, e.pull); err != nil {
+				e.logger().Warn("cannot pre-pull images",
+					"namespace", spec.Metadata.Namespace,
+					"error", err)
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return engine.WrapError(engine.ErrTimeout, ctx.Err())
+			}
+			return ctx.Err()
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// helper function returns the image of the first init
+// container that cannot pull its image, if any.
+func failedPull(pod *v1.Pod) (string, bool) {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+			return status.Image, true
+		}
+	}
+	return "", false
+}
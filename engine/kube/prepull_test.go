@@ -0,0 +1,148 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestToPrePullPod(t *testing.T) {
+	spec, _ := testSpec()
+	spec.Steps = []*engine.Step{
+		{Docker: &engine.DockerStep{Image: "golang:1.11"}},
+		{Docker: &engine.DockerStep{Image: "redis:4"}},
+		{Docker: &engine.DockerStep{Image: "golang:1.11"}},
+		{Docker: &engine.DockerStep{Image: "plugins/docker", PullPolicy: engine.PullNever}},
+		{Docker: &engine.DockerStep{Image: "node:10"}},
+	}
+	pod := toPrePullPod(spec, toPrePullImages(spec))
+
+	var images []string
+	for _, container := range pod.Spec.InitContainers {
+		images = append(images, container.Image)
+	}
+	want := []string{workingDirImage, "golang:1.11", "redis:4", "node:10"}
+	if diff := cmp.Diff(images, want); diff != "" {
+		t.Errorf("Expect images pulled sequentially in step order")
+		t.Log(diff)
+	}
+	if diff := cmp.Diff(pod.Spec.InitContainers[1].Command, []string{"/drone-prepull/busybox", "true"}); diff != "" {
+		t.Errorf("Unexpected pre-pull command")
+		t.Log(diff)
+	}
+	if got, want := pod.Spec.RestartPolicy, v1.RestartPolicyNever; got != want {
+		t.Errorf("Want restart policy %s, got %s", want, got)
+	}
+
+	// the containers require resources, so that the pod
+	// is admitted when the namespace has a resource quota.
+	containers := append(pod.Spec.InitContainers, pod.Spec.Containers...)
+	for _, container := range containers {
+		if len(container.Resources.Limits) == 0 || len(container.Resources.Requests) == 0 {
+			t.Errorf("Want resources for container %s", container.Name)
+		}
+	}
+}
+
+func TestSetup_PrePull(t *testing.T) {
+	spec, _ := testSpec()
+
+	// the pre-pull pod is created by a previous attempt,
+	// and has completed.
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prePullName,
+			Namespace: spec.Metadata.Namespace,
+		},
+		Status: v1.PodStatus{Phase: v1.PodSucceeded},
+	}
+	client := fake.NewSimpleClientset(pod)
+	e := &kubeEngine{client: client, prePull: true}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(prePullName, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Expect pre-pull pod deleted, got %v", err)
+	}
+}
+
+// this test verifies that the pre-pull is best effort, and
+// that setup continues if the pre-pull pod is unschedulable.
+func TestSetup_PrePullUnschedulable(t *testing.T) {
+	spec, _ := testSpec()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prePullName,
+			Namespace: spec.Metadata.Namespace,
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{{
+				Type:    v1.PodScheduled,
+				Status:  v1.ConditionFalse,
+				Reason:  v1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available: 3 Insufficient cpu.",
+			}},
+		},
+	}
+	logger := new(captureLogger)
+	client := fake.NewSimpleClientset(pod)
+	e := &kubeEngine{client: client, log: logger, prePull: true}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get(prePullName, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Expect pre-pull pod deleted, got %v", err)
+	}
+	want := "WARN cannot schedule pre-pull pod [namespace ns_JVzesGoyteu5koZK error kubernetes: pod drone-prepull is unschedulable: 0/3 nodes are available: 3 Insufficient cpu.]"
+	var found bool
+	for _, line := range logger.lines {
+		found = found || line == want
+	}
+	if !found {
+		t.Errorf("Want log line %q, got %q", want, logger.lines)
+	}
+}
+
+func TestFailedPull(t *testing.T) {
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			InitContainerStatuses: []v1.ContainerStatus{
+				{
+					Image: "golang:1.11",
+					State: v1.ContainerState{
+						Terminated: &v1.ContainerStateTerminated{},
+					},
+				},
+				{
+					Image: "redis:4",
+					State: v1.ContainerState{
+						Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"},
+					},
+				},
+			},
+		},
+	}
+	image, ok := failedPull(pod)
+	if !ok {
+		t.Errorf("Expect failed pull detected")
+	}
+	if got, want := image, "redis:4"; got != want {
+		t.Errorf("Want image %s, got %s", want, got)
+	}
+}
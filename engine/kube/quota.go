@@ -0,0 +1,178 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"fmt"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// quotaName is the name of the resource quota created
+	// in the pipeline namespace.
+	quotaName = "drone-quota"
+
+	// limitRangeName is the name of the limit range created
+	// in the pipeline namespace.
+	limitRangeName = "drone-limits"
+)
+
+// Quota defines the total compute resources available to
+// all pods in a pipeline namespace. A zero value does not
+// impose a limit.
+type Quota struct {
+	CPU    int64 // Total cpu limit, in millicores
+	Memory int64 // Total memory limit, in bytes
+	Pods   int64 // Maximum number of pods
+}
+
+// LimitRange defines the default and permitted compute
+// resources of each container in a pipeline namespace.
+// Kubernetes applies the defaults to containers that do
+// not declare their own requests or limits.
+type LimitRange struct {
+	Default        engine.ResourceObject // Default limits
+	DefaultRequest engine.ResourceObject // Default requests
+	Max            engine.ResourceObject // Maximum limits
+	Min            engine.ResourceObject // Minimum requests
+}
+
+// helper function returns true if the quota does not
+// impose any limits.
+func (q *Quota) empty() bool {
+	return q == nil || (q.CPU == 0 && q.Memory == 0 && q.Pods == 0)
+}
+
+// helper function returns the kubernetes resource quota
+// for the given specification. Note that kubernetes
+// rejects pods that do not declare cpu or memory limits
+// when the quota constrains them.
+func toResourceQuota(spec *engine.Spec, quota *Quota) *v1.ResourceQuota {
+	hard := v1.ResourceList{}
+	if quota.CPU > 0 {
+		hard[v1.ResourceLimitsCPU] = *resource.NewMilliQuantity(
+			quota.CPU, resource.DecimalSI)
+	}
+	if quota.Memory > 0 {
+		hard[v1.ResourceLimitsMemory] = *resource.NewQuantity(
+			quota.Memory, resource.BinarySI)
+	}
+	if quota.Pods > 0 {
+		hard[v1.ResourcePods] = *resource.NewQuantity(
+			quota.Pods, resource.DecimalSI)
+	}
+	return &v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      quotaName,
+			Namespace: spec.Metadata.Namespace,
+			Labels:    toLabels(spec, nil),
+		},
+		Spec: v1.ResourceQuotaSpec{
+			Hard: hard,
+		},
+	}
+}
+
+// helper function returns true if the limit range does
+// not define any defaults or constraints.
+func (l *LimitRange) empty() bool {
+	var zero engine.ResourceObject
+	return l == nil || (l.Default == zero &&
+		l.DefaultRequest == zero &&
+		l.Max == zero &&
+		l.Min == zero)
+}
+
+// helper function returns the kubernetes limit range for
+// the given specification.
+func toLimitRange(spec *engine.Spec, limits *LimitRange) *v1.LimitRange {
+	return &v1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      limitRangeName,
+			Namespace: spec.Metadata.Namespace,
+			Labels:    toLabels(spec, nil),
+		},
+		Spec: v1.LimitRangeSpec{
+			Limits: []v1.LimitRangeItem{
+				{
+					Type:           v1.LimitTypeContainer,
+					Default:        toResourceList(limits.Default),
+					DefaultRequest: toResourceList(limits.DefaultRequest),
+					Max:            toResourceList(limits.Max),
+					Min:            toResourceList(limits.Min),
+				},
+			},
+		},
+	}
+}
+
+// helper function returns the kubernetes resource list
+// for the given resource object. Zero values are omitted.
+func toResourceList(from engine.ResourceObject) v1.ResourceList {
+	if from.CPU <= 0 && from.Memory <= 0 {
+		return nil
+	}
+	list := v1.ResourceList{}
+	if from.CPU > 0 {
+		list[v1.ResourceCPU] = *resource.NewMilliQuantity(
+			from.CPU, resource.DecimalSI)
+	}
+	if from.Memory > 0 {
+		list[v1.ResourceMemory] = *resource.NewQuantity(
+			from.Memory, resource.BinarySI)
+	}
+	return list
+}
+
+// helper function verifies the resources of each step fit
+// within the remaining budget of the existing resource
+// quotas, so that a pod that would be rejected by the
+// quota fails with an informative error before the
+// pipeline starts.
+func checkQuotas(spec *engine.Spec, quotas []v1.ResourceQuota) error {
+	for _, quota := range quotas {
+		for _, step := range spec.Steps {
+			resources := toResources(spec, step)
+			requested := map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU:            toRequest(resources, v1.ResourceCPU),
+				v1.ResourceMemory:         toRequest(resources, v1.ResourceMemory),
+				v1.ResourceRequestsCPU:    toRequest(resources, v1.ResourceCPU),
+				v1.ResourceRequestsMemory: toRequest(resources, v1.ResourceMemory),
+				v1.ResourceLimitsCPU:      resources.Limits[v1.ResourceCPU],
+				v1.ResourceLimitsMemory:   resources.Limits[v1.ResourceMemory],
+			}
+			for name, hard := range quota.Status.Hard {
+				want, ok := requested[name]
+				if !ok || want.IsZero() {
+					continue
+				}
+				remaining := hard.DeepCopy()
+				if used, ok := quota.Status.Used[name]; ok {
+					remaining.Sub(used)
+				}
+				if want.Cmp(remaining) > 0 {
+					return fmt.Errorf("kubernetes: step %s requires %s %s, which exceeds the %s remaining in resource quota %s",
+						step.Metadata.Name, name, want.String(), remaining.String(), quota.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// helper function returns the container request for the
+// named resource. Kubernetes defaults the request to the
+// limit if no request is defined.
+func toRequest(resources v1.ResourceRequirements, name v1.ResourceName) resource.Quantity {
+	if q, ok := resources.Requests[name]; ok {
+		return q
+	}
+	return resources.Limits[name]
+}
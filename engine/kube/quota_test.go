@@ -0,0 +1,133 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestToResourceQuota(t *testing.T) {
+	spec, _ := testSpec()
+	quota := toResourceQuota(spec, &Quota{
+		CPU:    4000,
+		Memory: 8589934592,
+		Pods:   10,
+	})
+	if got, want := quota.Namespace, spec.Metadata.Namespace; got != want {
+		t.Errorf("Want quota namespace %s, got %s", want, got)
+	}
+	want := v1.ResourceList{
+		v1.ResourceLimitsCPU:    resource.MustParse("4"),
+		v1.ResourceLimitsMemory: resource.MustParse("8Gi"),
+		v1.ResourcePods:         resource.MustParse("10"),
+	}
+	if !equalResourceList(quota.Spec.Hard, want) {
+		t.Errorf("Want quota hard limits %v, got %v", want, quota.Spec.Hard)
+	}
+}
+
+func TestToResourceQuota_Partial(t *testing.T) {
+	spec, _ := testSpec()
+	quota := toResourceQuota(spec, &Quota{Pods: 5})
+	want := v1.ResourceList{
+		v1.ResourcePods: resource.MustParse("5"),
+	}
+	if !equalResourceList(quota.Spec.Hard, want) {
+		t.Errorf("Want quota hard limits %v, got %v", want, quota.Spec.Hard)
+	}
+}
+
+func TestToLimitRange(t *testing.T) {
+	spec, _ := testSpec()
+	limits := toLimitRange(spec, &LimitRange{
+		Default:        engine.ResourceObject{CPU: 1000, Memory: 1073741824},
+		DefaultRequest: engine.ResourceObject{CPU: 250, Memory: 268435456},
+		Max:            engine.ResourceObject{CPU: 2000},
+	})
+	if got, want := limits.Namespace, spec.Metadata.Namespace; got != want {
+		t.Errorf("Want limit range namespace %s, got %s", want, got)
+	}
+	if got, want := len(limits.Spec.Limits), 1; got != want {
+		t.Errorf("Want %d limit range items, got %d", want, got)
+		return
+	}
+	item := limits.Spec.Limits[0]
+	if got, want := item.Type, v1.LimitTypeContainer; got != want {
+		t.Errorf("Want limit type %s, got %s", want, got)
+	}
+	tests := []struct {
+		name string
+		got  v1.ResourceList
+		want v1.ResourceList
+	}{
+		{
+			name: "default",
+			got:  item.Default,
+			want: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				v1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		},
+		{
+			name: "default request",
+			got:  item.DefaultRequest,
+			want: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("250m"),
+				v1.ResourceMemory: resource.MustParse("256Mi"),
+			},
+		},
+		{
+			name: "max",
+			got:  item.Max,
+			want: v1.ResourceList{
+				v1.ResourceCPU: resource.MustParse("2"),
+			},
+		},
+	}
+	for _, test := range tests {
+		if !equalResourceList(test.got, test.want) {
+			t.Errorf("Want %s %v, got %v", test.name, test.want, test.got)
+		}
+	}
+	if item.Min != nil {
+		t.Errorf("Expect empty min omitted, got %v", item.Min)
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	spec, step := testSpec()
+	step.Resources = &engine.Resources{
+		Limits: &engine.ResourceObject{CPU: 2000},
+	}
+	quotas := []v1.ResourceQuota{
+		{
+			Status: v1.ResourceQuotaStatus{
+				Hard: v1.ResourceList{
+					v1.ResourceLimitsCPU:   resource.MustParse("4"),
+					v1.ResourceRequestsCPU: resource.MustParse("4"),
+				},
+				Used: v1.ResourceList{
+					v1.ResourceLimitsCPU:   resource.MustParse("1"),
+					v1.ResourceRequestsCPU: resource.MustParse("1"),
+				},
+			},
+		},
+	}
+	if err := checkQuotas(spec, quotas); err != nil {
+		t.Errorf("Expect step fits within quota, got %s", err)
+	}
+
+	// the request defaults to the limit, which exceeds
+	// the remaining cpu requests.
+	quotas[0].Status.Used[v1.ResourceRequestsCPU] = resource.MustParse("3")
+	if err := checkQuotas(spec, quotas); err == nil {
+		t.Errorf("Expect step exceeds remaining quota")
+	}
+}
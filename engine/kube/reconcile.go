@@ -0,0 +1,146 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone/drone-runtime/engine"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reconcile deletes all objects created for the build, which
+// are found using the build id label. This can be used by a
+// new runner to clean up the objects of a build orphaned by
+// a crashed runner. It returns the deleted objects in the
+// kind/namespace/name format, or kind/name for namespaces.
+func Reconcile(ctx context.Context, eng engine.Engine, buildID string) ([]string, error) {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return nil, fmt.Errorf("Not a valid Engine type")
+	}
+	var deleted []string
+	opts := metav1.ListOptions{LabelSelector: labelBuildID + "=" + buildID}
+	remove := func(kind, namespace, name string, err error) error {
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if namespace != "" {
+			name = namespace + "/" + name
+		}
+		deleted = append(deleted, kind+"/"+name)
+		return nil
+	}
+
+	// jobs are deleted before pods, so that the job
+	// controller does not replace the deleted pods.
+	propagation := metav1.DeletePropagationBackground
+	jobs, err := e.client.BatchV1().Jobs(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range jobs.Items {
+		err := e.client.BatchV1().Jobs(item.Namespace).Delete(item.Name,
+			&metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err := remove("job", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	core := e.client.CoreV1()
+	pods, err := core.Pods(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range pods.Items {
+		err := core.Pods(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("pod", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	services, err := core.Services(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range services.Items {
+		err := core.Services(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("service", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	secrets, err := core.Secrets(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range secrets.Items {
+		err := core.Secrets(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("secret", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	configMaps, err := core.ConfigMaps(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range configMaps.Items {
+		err := core.ConfigMaps(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("configmap", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	quotas, err := core.ResourceQuotas(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range quotas.Items {
+		err := core.ResourceQuotas(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("resourcequota", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	limits, err := core.LimitRanges(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range limits.Items {
+		err := core.LimitRanges(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("limitrange", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	policies, err := e.client.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range policies.Items {
+		err := e.client.NetworkingV1().NetworkPolicies(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("networkpolicy", item.Namespace, item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+
+	// the namespace is deleted last, which deletes any
+	// remaining objects in the namespace.
+	namespaces, err := core.Namespaces().List(opts)
+	if err != nil {
+		return deleted, err
+	}
+	for _, item := range namespaces.Items {
+		err := core.Namespaces().Delete(item.Name, &metav1.DeleteOptions{})
+		if err := remove("namespace", "", item.Name, err); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
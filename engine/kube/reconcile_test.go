@@ -0,0 +1,100 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcile(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.Ports = []*engine.Port{{Port: 6379}}
+	job := &engine.Step{
+		Metadata: engine.Metadata{
+			UID:       "uid_Vq6jBz1HscbaSB4t",
+			Namespace: spec.Metadata.Namespace,
+			Name:      "test",
+		},
+		Docker: &engine.DockerStep{
+			Image:        "golang:1.11",
+			BackoffLimit: 2,
+		},
+	}
+	spec.Steps = append(spec.Steps, job)
+	spec.Secrets = []*engine.Secret{
+		{Metadata: engine.Metadata{UID: "uid_password", Name: "password"}, Data: "correct-horse"},
+	}
+	spec.Files = []*engine.File{
+		{Metadata: engine.Metadata{UID: "uid_script", Name: "script"}, Data: []byte("echo hello")},
+	}
+
+	// the object of another build, which is not deleted.
+	other := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "uid_other",
+			Namespace: spec.Metadata.Namespace,
+			Labels:    map[string]string{labelBuildID: "uid_other"},
+		},
+	}
+	client := fake.NewSimpleClientset(other)
+	e := &kubeEngine{
+		client: client,
+		quota:  &Quota{Pods: 10},
+		policy: &NetworkPolicy{},
+	}
+
+	ctx := context.Background()
+	if err := e.Setup(ctx, spec); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, step := range spec.Steps {
+		if err := e.Start(ctx, spec, step); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	deleted, err := Reconcile(ctx, e, spec.Metadata.UID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sort.Strings(deleted)
+	want := []string{
+		"configmap/ns_JVzesGoyteu5koZK/uid_script",
+		"job/ns_JVzesGoyteu5koZK/uid_Vq6jBz1HscbaSB4t",
+		"namespace/ns_JVzesGoyteu5koZK",
+		"networkpolicy/ns_JVzesGoyteu5koZK/" + policyName,
+		"pod/ns_JVzesGoyteu5koZK/uid_8a7IJsL9zSJCCchd",
+		"resourcequota/ns_JVzesGoyteu5koZK/" + quotaName,
+		"secret/ns_JVzesGoyteu5koZK/uid_password",
+		"service/ns_JVzesGoyteu5koZK/greetings",
+	}
+	sort.Strings(want)
+	if diff := cmp.Diff(deleted, want); diff != "" {
+		t.Errorf("Unexpected deleted objects")
+		t.Log(diff)
+	}
+
+	if _, err := client.CoreV1().Secrets(spec.Metadata.Namespace).Get(other.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expect objects of other builds kept, got %v", err)
+	}
+}
+
+func TestReconcile_InvalidEngine(t *testing.T) {
+	if _, err := Reconcile(context.Background(), nil, "uid_AOTCIPBf3XdTFs2j"); err == nil {
+		t.Errorf("Expect error for invalid engine type")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// helper function starts the detached service steps
+// concurrently, and waits for the service pods to become
+// ready. The service steps are recorded as started, so
+// that they are not started again by the runtime.
+func (e *kubeEngine) startServices(ctx context.Context, spec *engine.Spec) error {
+	var services []*engine.Step
+	for _, step := range spec.Steps {
+		if isEarlyService(step) {
+			services = append(services, step)
+		}
+	}
+	return runAll(services, e.services, func(step *engine.Step) error {
+		if err := e.Start(ctx, spec, step); err != nil {
+			return err
+		}
+		e.started.Store(step.Metadata.UID, true)
+		return e.waitReady(ctx, spec, step)
+	})
+}
+
+// helper function returns true if the step is a service
+// that can be started before the pipeline executes. Services
+// that depend on other steps, or that only run when the
+// pipeline fails or never run, are started by the runtime
+// according to their dependencies and run policy.
+func isEarlyService(step *engine.Step) bool {
+	if !step.Detach || step.Docker == nil || len(step.DependsOn) != 0 {
+		return false
+	}
+	switch step.RunPolicy {
+	case engine.RunOnSuccess, engine.RunAlways:
+		return true
+	default:
+		return false
+	}
+}
+
+// helper function returns true if the step service
+// resolves to an external host, in which case no pod is
+// created for the step.
+func isExternal(step *engine.Step) bool {
+	return step.Docker != nil &&
+		step.Docker.Service != nil &&
+		step.Docker.Service.ExternalName != ""
+}
+
+// WaitReady blocks until the pod of the named step is
+// running and its containers are ready, which allows
+// dependents to wait for a service independently of the
+// step lifecycle. A zero timeout waits until the context
+// is cancelled.
+func WaitReady(ctx context.Context, eng engine.Engine, spec *engine.Spec, step string, timeout time.Duration) error {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return fmt.Errorf("Not a valid Engine type")
+	}
+	for _, s := range spec.Steps {
+		if s.Metadata.UID != step {
+			continue
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		err := e.waitReady(ctx, spec, s)
+		if err == context.DeadlineExceeded {
+			return engine.WrapError(engine.ErrTimeout, err)
+		}
+		return err
+	}
+	return fmt.Errorf("kubernetes: unknown step %s", step)
+}
+
+// helper function waits for the step pod to be running
+// and ready.
+func (e *kubeEngine) waitReady(ctx context.Context, spec *engine.Spec, step *engine.Step) error {
+	if isExternal(step) {
+		return nil
+	}
+	for {
+		pod, err := e.client.CoreV1().Pods(spec.Metadata.Namespace).Get(step.Metadata.UID, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		switch pod.Status.Phase {
+		case v1.PodRunning:
+			if isReady(pod) {
+				return nil
+			}
+		case v1.PodSucceeded, v1.PodFailed, v1.PodUnknown:
+			return fmt.Errorf("kubernetes: service %s exited", step.Metadata.Name)
+		}
+		if err := checkSchedulable(pod, e.grace); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// helper function returns true if the pod reports the
+// ready or containers ready condition. The containers are
+// ready once their readiness probes pass, which may precede
+// the pod ready condition.
+func isReady(pod *v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		switch cond.Type {
+		case v1.PodReady, v1.ContainersReady:
+			if cond.Status == v1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helper function invokes the function for each step, with
+// at most limit invocations executing concurrently, and
+// returns the aggregated errors.
+func runAll(steps []*engine.Step, limit int, fn func(*engine.Step) error) error {
+	if limit <= 0 {
+		limit = len(steps)
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+		sem  = make(chan struct{}, limit)
+	)
+	for _, step := range steps {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(step *engine.Step) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(step); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %s", step.Metadata.Name, err))
+				mu.Unlock()
+			}
+		}(step)
+	}
+	wg.Wait()
+	if len(errs) != 0 {
+		return fmt.Errorf("kubernetes: cannot start services: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
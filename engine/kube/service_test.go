@@ -0,0 +1,198 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestRunAll_Concurrent(t *testing.T) {
+	steps := testServices()
+
+	// each invocation waits for all invocations to start,
+	// which times out unless they execute concurrently.
+	var wg sync.WaitGroup
+	wg.Add(len(steps))
+	all := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(all)
+	}()
+
+	err := runAll(steps, 3, func(*engine.Step) error {
+		wg.Done()
+		select {
+		case <-all:
+			return nil
+		case <-time.After(time.Second):
+			return errors.New("timeout")
+		}
+	})
+	if err != nil {
+		t.Errorf("Expect services started concurrently, got %s", err)
+	}
+}
+
+func TestRunAll_Errors(t *testing.T) {
+	steps := testServices()
+	err := runAll(steps, 1, func(step *engine.Step) error {
+		if step.Metadata.Name == "mysql" {
+			return nil
+		}
+		return errors.New("image not found")
+	})
+	if err == nil {
+		t.Errorf("Expect aggregated error")
+		return
+	}
+	for _, want := range []string{"redis: image not found", "postgres: image not found"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Want error containing %q, got %q", want, err)
+		}
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status = v1.PodStatus{
+		Phase: v1.PodRunning,
+		Conditions: []v1.PodCondition{
+			{Type: v1.PodReady, Status: v1.ConditionFalse},
+			{Type: v1.ContainersReady, Status: v1.ConditionTrue},
+		},
+	}
+	e := &kubeEngine{client: fake.NewSimpleClientset(pod), interval: time.Hour}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- WaitReady(context.Background(), e, spec, step.Metadata.UID, time.Minute)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expect ready service returned promptly")
+	}
+}
+
+func TestWaitReady_Timeout(t *testing.T) {
+	spec, step := testSpec()
+	pod := testPod(spec, step)
+	pod.Status.Phase = v1.PodPending
+	e := &kubeEngine{client: fake.NewSimpleClientset(pod), interval: time.Millisecond}
+
+	err := WaitReady(context.Background(), e, spec, step.Metadata.UID, 10*time.Millisecond)
+	if !errors.Is(err, engine.ErrTimeout) {
+		t.Errorf("Want timeout error, got %v", err)
+	}
+}
+
+func TestWaitReady_UnknownStep(t *testing.T) {
+	spec, _ := testSpec()
+	e := &kubeEngine{client: fake.NewSimpleClientset()}
+	if err := WaitReady(context.Background(), e, spec, "uid_unknown", 0); err == nil {
+		t.Errorf("Expect unknown step error")
+	}
+}
+
+func TestSetup_Services(t *testing.T) {
+	spec, _ := testSpec()
+	spec.Steps = append(spec.Steps, testServices()...)
+
+	// the fake client reports created pods as running
+	// and ready.
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pod := action.(ktesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Status.Phase = v1.PodRunning
+		pod.Status.Conditions = []v1.PodCondition{
+			{Type: v1.PodReady, Status: v1.ConditionTrue},
+		}
+		return false, nil, nil
+	})
+
+	e := &kubeEngine{client: client, services: 2}
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	pods, _ := client.CoreV1().Pods(spec.Metadata.Namespace).List(metav1.ListOptions{})
+	if got, want := len(pods.Items), 3; got != want {
+		t.Errorf("Want %d service pods, got %d", want, got)
+	}
+
+	// the runtime starts the service steps again, which
+	// must not attempt to re-create the pods.
+	for _, step := range spec.Steps[1:] {
+		if err := e.Start(context.Background(), spec, step); err != nil {
+			t.Errorf("Expect started service skipped, got %s", err)
+		}
+	}
+
+	// the started services are forgotten once the pipeline
+	// is destroyed.
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, step := range spec.Steps[1:] {
+		if _, ok := e.started.Load(step.Metadata.UID); ok {
+			t.Errorf("Expect service %s removed from started services", step.Metadata.Name)
+		}
+	}
+}
+
+func TestIsEarlyService(t *testing.T) {
+	tests := []struct {
+		step  *engine.Step
+		early bool
+	}{
+		{step: &engine.Step{Detach: true, Docker: &engine.DockerStep{}}, early: true},
+		{step: &engine.Step{Detach: true, Docker: &engine.DockerStep{}, RunPolicy: engine.RunAlways}, early: true},
+		{step: &engine.Step{Detach: true, Docker: &engine.DockerStep{}, RunPolicy: engine.RunOnFailure}, early: false},
+		{step: &engine.Step{Detach: true, Docker: &engine.DockerStep{}, RunPolicy: engine.RunNever}, early: false},
+		{step: &engine.Step{Detach: true, Docker: &engine.DockerStep{}, DependsOn: []string{"clone"}}, early: false},
+		{step: &engine.Step{Docker: &engine.DockerStep{}}, early: false},
+	}
+	for i, test := range tests {
+		if got, want := isEarlyService(test.step), test.early; got != want {
+			t.Errorf("Want early service %v at index %d, got %v", want, i, got)
+		}
+	}
+}
+
+// helper function returns detached service steps for
+// testing purposes.
+func testServices() []*engine.Step {
+	var steps []*engine.Step
+	for _, name := range []string{"redis", "postgres", "mysql"} {
+		steps = append(steps, &engine.Step{
+			Metadata: engine.Metadata{
+				UID:       "uid_" + name,
+				Namespace: "ns_JVzesGoyteu5koZK",
+				Name:      name,
+			},
+			Detach: true,
+			Docker: &engine.DockerStep{Image: name},
+		})
+	}
+	return steps
+}
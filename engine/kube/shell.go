@@ -0,0 +1,95 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+)
+
+// defaultShell defines the shell used to execute the step
+// commands. The bourne shell is used by default since it
+// is available in minimal images, such as alpine.
+var defaultShell = Shell{
+	Path:    "/bin/sh",
+	Errexit: true,
+}
+
+// Shell defines the shell used to execute the step
+// commands as a script.
+type Shell struct {
+	Path    string // Shell path, such as /bin/sh or /bin/bash
+	Errexit bool   // Exit immediately if a command fails
+	Xtrace  bool   // Print commands before execution
+	Timing  bool   // Print timing markers around each command
+}
+
+// timingMarker defines the prefix of the timing markers
+// written to the log stream. Each marker is followed by
+// the command index, or end after the last command, and
+// the unix timestamp in seconds, for example:
+//
+//	::drone-timing:: 0 1546300800
+//	::drone-timing:: end 1546300812
+//
+// The duration of a command is the difference between
+// its marker and the next marker.
+const timingMarker = "::drone-timing::"
+
+// helper function replaces the container command and args
+// with the shell script generated from the step commands.
+// The flags are passed to the shell before the script.
+func setScript(pod *v1.Pod, step *engine.Step, shell Shell, flags []string) {
+	if len(step.Docker.Commands) == 0 {
+		return
+	}
+	command := append([]string{shell.Path}, flags...)
+	command = append(command, "-c")
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		container.Command = command
+		container.Args = []string{toScript(step.Docker.Commands, shell)}
+	}
+}
+
+// helper function returns the shell flags defined in the
+// spec. The flags default to -e, unless the engine shell
+// disables errexit.
+func toShellFlags(spec *engine.Spec, shell Shell) []string {
+	if len(spec.ShellFlags) != 0 {
+		return spec.ShellFlags
+	}
+	if shell.Errexit {
+		return []string{"-e"}
+	}
+	return nil
+}
+
+// helper function returns a shell script that executes
+// the commands in order.
+func toScript(commands []string, shell Shell) string {
+	buf := new(bytes.Buffer)
+	if shell.Errexit {
+		buf.WriteString("set -e\n")
+	}
+	if shell.Xtrace {
+		buf.WriteString("set -x\n")
+	}
+	for i, command := range commands {
+		if shell.Timing {
+			fmt.Fprintf(buf, "echo \"%s %d $(date +%%s)\"\n", timingMarker, i)
+		}
+		buf.WriteString(command)
+		buf.WriteString("\n")
+	}
+	if shell.Timing {
+		fmt.Fprintf(buf, "echo \"%s end $(date +%%s)\"\n", timingMarker)
+	}
+	return buf.String()
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSetScript(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.Commands = []string{"go build", "go test"}
+
+	tests := []struct {
+		shell   Shell
+		command []string
+		args    []string
+	}{
+		{
+			shell:   defaultShell,
+			command: []string{"/bin/sh", "-e", "-c"},
+			args:    []string{"set -e\ngo build\ngo test\n"},
+		},
+		{
+			shell:   Shell{Path: "/bin/bash", Errexit: true, Xtrace: true},
+			command: []string{"/bin/bash", "-e", "-c"},
+			args:    []string{"set -e\nset -x\ngo build\ngo test\n"},
+		},
+		{
+			shell:   Shell{Path: "/bin/sh"},
+			command: []string{"/bin/sh", "-c"},
+			args:    []string{"go build\ngo test\n"},
+		},
+	}
+	for _, test := range tests {
+		pod := toPod(spec, step)
+		setScript(pod, step, test.shell, toShellFlags(spec, test.shell))
+		container := pod.Spec.Containers[0]
+		if diff := cmp.Diff(container.Command, test.command); diff != "" {
+			t.Errorf("Unexpected command for shell %s", test.shell.Path)
+			t.Log(diff)
+		}
+		if diff := cmp.Diff(container.Args, test.args); diff != "" {
+			t.Errorf("Unexpected args for shell %s", test.shell.Path)
+			t.Log(diff)
+		}
+	}
+}
+
+func TestSetScript_ShellFlags(t *testing.T) {
+	spec, step := testSpec()
+	spec.ShellFlags = []string{"-euo", "pipefail"}
+	step.Docker.Commands = []string{"go build"}
+
+	pod := toPod(spec, step)
+	shell := Shell{Path: "/bin/bash", Errexit: true}
+	setScript(pod, step, shell, toShellFlags(spec, shell))
+	want := []string{"/bin/bash", "-euo", "pipefail", "-c"}
+	if diff := cmp.Diff(pod.Spec.Containers[0].Command, want); diff != "" {
+		t.Errorf("Unexpected command with shell flags")
+		t.Log(diff)
+	}
+}
+
+func TestSetScript_NoCommands(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.Command = []string{"/bin/plugin"}
+	pod := toPod(spec, step)
+	setScript(pod, step, defaultShell, nil)
+	if diff := cmp.Diff(pod.Spec.Containers[0].Command, []string{"/bin/plugin"}); diff != "" {
+		t.Errorf("Expect command unchanged without step commands")
+		t.Log(diff)
+	}
+}
+
+func TestToScript_Timing(t *testing.T) {
+	shell := Shell{Path: "/bin/sh", Errexit: true, Timing: true}
+	got := toScript([]string{"go build", "go test"}, shell)
+	want := "set -e\n" +
+		"echo \"::drone-timing:: 0 $(date +%s)\"\n" +
+		"go build\n" +
+		"echo \"::drone-timing:: 1 $(date +%s)\"\n" +
+		"go test\n" +
+		"echo \"::drone-timing:: end $(date +%s)\"\n"
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected timing script")
+		t.Log(diff)
+	}
+}
+
+func TestToScript_TimingOutput(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	shell := Shell{Path: "/bin/sh", Errexit: true, Timing: true}
+	script := toScript([]string{"echo hello", "echo world"}, shell)
+	out, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if got, want := len(lines), 5; got != want {
+		t.Errorf("Want %d output lines, got %d", want, got)
+		return
+	}
+	marker := regexp.MustCompile(`^::drone-timing:: (0|1|end) [0-9]+$`)
+	for _, i := range []int{0, 2, 4} {
+		if !marker.MatchString(lines[i]) {
+			t.Errorf("Want timing marker, got %q", lines[i])
+		}
+	}
+	if lines[1] != "hello" || lines[3] != "world" {
+		t.Errorf("Want command output between timing markers, got %q", lines)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ssdCleanupTimeout defines the maximum amount of time
+	// the engine waits for the local ssd scratch directories
+	// to be removed.
+	ssdCleanupTimeout = time.Minute
+
+	// ssdCleanupPath defines the container path at which
+	// the local solid state storage is mounted by the
+	// cleanup pod.
+	ssdCleanupPath = "/drone/ssd"
+)
+
+// helper function returns true if any pipeline step uses
+// local solid state storage.
+func usesLocalSSD(spec *engine.Spec) bool {
+	for _, step := range spec.Steps {
+		if step.Docker != nil && step.Docker.LocalSSD {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the nodes on which pods mounting
+// local solid state storage were scheduled, in pod order.
+func toLocalSSDNodes(pods []v1.Pod) []string {
+	var nodes []string
+	seen := map[string]bool{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || seen[pod.Spec.NodeName] {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.Name == "local-ssd" {
+				seen[pod.Spec.NodeName] = true
+				nodes = append(nodes, pod.Spec.NodeName)
+				break
+			}
+		}
+	}
+	return nodes
+}
+
+// helper function returns the pod that removes the pipeline
+// scratch directory from the local solid state storage of
+// the node.
+func toLocalSSDCleanupPod(spec *engine.Spec, name, node, hostPath string) *v1.Pod {
+	srcType := v1.HostPathDirectory
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: spec.Metadata.Namespace,
+			Labels:    toLabels(spec, nil),
+		},
+		Spec: v1.PodSpec{
+			NodeName:      node,
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers: []v1.Container{{
+				Name:            "cleanup",
+				Image:           workingDirImage,
+				ImagePullPolicy: v1.PullIfNotPresent,
+				Command:         []string{"rm", "-rf", path.Join(ssdCleanupPath, spec.Metadata.Namespace)},
+				Resources:       helperResources,
+				VolumeMounts: []v1.VolumeMount{{
+					Name:      "local-ssd",
+					MountPath: ssdCleanupPath,
+				}},
+			}},
+			Volumes: []v1.Volume{{
+				Name: "local-ssd",
+				VolumeSource: v1.VolumeSource{
+					HostPath: &v1.HostPathVolumeSource{
+						Path: hostPath,
+						Type: &srcType,
+					},
+				},
+			}},
+		},
+	}
+}
+
+// helper function removes the pipeline scratch directories
+// from the local solid state storage of the nodes on which
+// the steps were scheduled, using a pod pinned to each node,
+// and waits for the pods to complete. The cleanup is best
+// effort, and failures are logged.
+func (e *kubeEngine) cleanLocalSSD(ctx context.Context, spec *engine.Spec) {
+	hostPath := e.ssdPath
+	if hostPath == "" {
+		hostPath = defaultLocalSSDPath
+	}
+	pods := e.client.CoreV1().Pods(spec.Metadata.Namespace)
+	list, err := pods.List(metav1.ListOptions{})
+	if err != nil {
+		e.logger().Warn("cannot list pods to clean local ssd",
+			"namespace", spec.Metadata.Namespace,
+			"error", err)
+		return
+	}
+
+	var names []string
+	for i, node := range toLocalSSDNodes(list.Items) {
+		name := fmt.Sprintf("drone-ssd-cleanup-%d", i)
+		_, err := pods.Create(toLocalSSDCleanupPod(spec, name, node, hostPath))
+		if err != nil {
+			e.logger().Warn("cannot create local ssd cleanup pod",
+				"namespace", spec.Metadata.Namespace,
+				"node", node,
+				"error", err)
+			continue
+		}
+		names = append(names, name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ssdCleanupTimeout)
+	defer cancel()
+	for _, name := range names {
+		for {
+			pod, err := pods.Get(name, metav1.GetOptions{})
+			if err != nil {
+				e.logger().Warn("cannot get local ssd cleanup pod",
+					"namespace", spec.Metadata.Namespace,
+					"pod", name,
+					"error", err)
+				break
+			}
+			if pod.Status.Phase == v1.PodSucceeded {
+				break
+			}
+			if pod.Status.Phase == v1.PodFailed {
+				e.logger().Warn("cannot clean local ssd",
+					"namespace", spec.Metadata.Namespace,
+					"node", pod.Spec.NodeName)
+				break
+			}
+			select {
+			case <-ctx.Done():
+				e.logger().Warn("timeout cleaning local ssd",
+					"namespace", spec.Metadata.Namespace,
+					"node", pod.Spec.NodeName)
+				return
+			case <-time.After(e.interval):
+			}
+		}
+	}
+}
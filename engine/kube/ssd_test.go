@@ -0,0 +1,72 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestDestroy_LocalSSD(t *testing.T) {
+	spec, step := testSpec()
+	step.Docker.LocalSSD = true
+
+	pod := testPod(spec, step)
+	pod.Spec.NodeName = "node-1"
+	pod.Spec.Volumes = []v1.Volume{{Name: "local-ssd"}}
+
+	// the cleanup pod completes once created.
+	client := fake.NewSimpleClientset(pod)
+	client.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pod := action.(ktesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Status.Phase = v1.PodSucceeded
+		return false, nil, nil
+	})
+	e := &kubeEngine{client: client, ssdPath: "/mnt/disks/ssd0"}
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cleanup, err := client.CoreV1().Pods(spec.Metadata.Namespace).Get("drone-ssd-cleanup-0", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("Expect local ssd cleanup pod created, got %s", err)
+		return
+	}
+	if got, want := cleanup.Spec.NodeName, "node-1"; got != want {
+		t.Errorf("Want cleanup pod on node %s, got %s", want, got)
+	}
+	if got, want := cleanup.Spec.Volumes[0].HostPath.Path, "/mnt/disks/ssd0"; got != want {
+		t.Errorf("Want host path %s, got %s", want, got)
+	}
+	want := []string{"rm", "-rf", "/drone/ssd/ns_JVzesGoyteu5koZK"}
+	if diff := cmp.Diff(cleanup.Spec.Containers[0].Command, want); diff != "" {
+		t.Errorf("Unexpected cleanup command")
+		t.Log(diff)
+	}
+}
+
+func TestToLocalSSDNodes(t *testing.T) {
+	ssd := []v1.Volume{{Name: "local-ssd"}}
+	pods := []v1.Pod{
+		{Spec: v1.PodSpec{NodeName: "node-1", Volumes: ssd}},
+		{Spec: v1.PodSpec{NodeName: "node-2"}},
+		{Spec: v1.PodSpec{NodeName: "node-3", Volumes: ssd}},
+		{Spec: v1.PodSpec{NodeName: "node-1", Volumes: ssd}},
+		{Spec: v1.PodSpec{Volumes: ssd}},
+	}
+	want := []string{"node-1", "node-3"}
+	if diff := cmp.Diff(toLocalSSDNodes(pods), want); diff != "" {
+		t.Errorf("Unexpected local ssd nodes")
+		t.Log(diff)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/drone/drone-runtime/engine"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+)
+
+// metricsGroupVersion defines the group version of the
+// resource metrics api, which is served by metrics-server.
+const metricsGroupVersion = "metrics.k8s.io/v1beta1"
+
+// Usage describes the resource usage of a step pod.
+type Usage struct {
+	CPU    int64 // CPU usage in millicores
+	Memory int64 // Memory usage in bytes
+}
+
+// metrics returns the raw pod metrics of a pod.
+type metrics func(namespace, pod string) ([]byte, error)
+
+// helper function returns a metrics function that reads
+// the pod metrics from the resource metrics api.
+func newMetrics(client kubernetes.Interface) metrics {
+	return func(namespace, pod string) ([]byte, error) {
+		return client.Discovery().RESTClient().Get().
+			AbsPath("/apis", metricsGroupVersion, "namespaces", namespace, "pods", pod).
+			DoRaw()
+	}
+}
+
+// PodUsage returns the current resource usage of the step
+// pod, which is the sum of the usage of its containers. An
+// error is returned if the resource metrics api is not
+// available, which requires metrics-server to be installed
+// in the cluster.
+func PodUsage(ctx context.Context, eng engine.Engine, spec *engine.Spec, step string) (*Usage, error) {
+	e, ok := engine.Unwrap(eng).(*kubeEngine)
+	if !ok {
+		return nil, fmt.Errorf("Not a valid Engine type")
+	}
+	if e.metrics == nil {
+		return nil, fmt.Errorf("kubernetes: metrics are not supported")
+	}
+	list, err := e.client.Discovery().ServerResourcesForGroupVersion(metricsGroupVersion)
+	if err != nil || list == nil {
+		return nil, fmt.Errorf("kubernetes: metrics api is not available")
+	}
+	raw, err := e.metrics(spec.Metadata.Namespace, step)
+	if err != nil {
+		return nil, err
+	}
+	return toUsage(raw)
+}
+
+// helper function converts the raw pod metrics to the
+// pod resource usage.
+func toUsage(raw []byte) (*Usage, error) {
+	out := struct {
+		Containers []struct {
+			Usage map[string]string `json:"usage"`
+		} `json:"containers"`
+	}{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	usage := new(Usage)
+	for _, container := range out.Containers {
+		if v, ok := container.Usage["cpu"]; ok {
+			q, err := resource.ParseQuantity(v)
+			if err != nil {
+				return nil, err
+			}
+			usage.CPU += q.MilliValue()
+		}
+		if v, ok := container.Usage["memory"]; ok {
+			q, err := resource.ParseQuantity(v)
+			if err != nil {
+				return nil, err
+			}
+			usage.Memory += q.Value()
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPodUsage(t *testing.T) {
+	spec, step := testSpec()
+
+	client := fake.NewSimpleClientset()
+	client.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "metrics.k8s.io/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "pods", Namespaced: true, Kind: "PodMetrics"}},
+		},
+	}
+
+	var namespace, name string
+	e := &kubeEngine{
+		client: client,
+		metrics: func(ns, pod string) ([]byte, error) {
+			namespace, name = ns, pod
+			return []byte(`{
+				"metadata": {"name": "uid_8a7IJsL9zSJCCchd"},
+				"containers": [
+					{"name": "uid_8a7IJsL9zSJCCchd", "usage": {"cpu": "250m", "memory": "64Mi"}},
+					{"name": "sidecar", "usage": {"cpu": "1500000n", "memory": "1Mi"}}
+				]
+			}`), nil
+		},
+	}
+
+	usage, err := PodUsage(context.Background(), e, spec, step.Metadata.UID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := usage.CPU, int64(252); got != want {
+		t.Errorf("Want cpu usage %d millicores, got %d", want, got)
+	}
+	if got, want := usage.Memory, int64(65*1024*1024); got != want {
+		t.Errorf("Want memory usage %d bytes, got %d", want, got)
+	}
+	if got, want := namespace, spec.Metadata.Namespace; got != want {
+		t.Errorf("Want metrics namespace %s, got %s", want, got)
+	}
+	if got, want := name, step.Metadata.UID; got != want {
+		t.Errorf("Want metrics pod %s, got %s", want, got)
+	}
+}
+
+func TestPodUsage_Unavailable(t *testing.T) {
+	spec, step := testSpec()
+	e := &kubeEngine{
+		client: fake.NewSimpleClientset(),
+		metrics: func(ns, pod string) ([]byte, error) {
+			t.Errorf("Expect metrics api not queried")
+			return nil, nil
+		},
+	}
+	if _, err := PodUsage(context.Background(), e, spec, step.Metadata.UID); err == nil {
+		t.Errorf("Expect error when the metrics api is not available")
+	}
+}
@@ -6,14 +6,18 @@ package kube
 
 import (
 	"fmt"
+	"math"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drone/drone-runtime/engine"
 
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -35,6 +39,11 @@ func toEnv(spec *engine.Spec, step *engine.Step) []v1.EnvVar {
 			to = append(to, v1.EnvVar{Name: k, Value: v})
 		}
 	}
+	// the step timezone is set using the TZ variable,
+	// unless the variable is explicitly defined.
+	if _, ok := step.Envs["TZ"]; !ok && step.Timezone != "" {
+		to = append(to, v1.EnvVar{Name: "TZ", Value: step.Timezone})
+	}
 	to = append(to, v1.EnvVar{
 		Name: "KUBERNETES_NODE",
 		ValueFrom: &v1.EnvVarSource{
@@ -101,6 +110,21 @@ func toConfigVolumes(spec *engine.Spec, step *engine.Step) []v1.Volume {
 		if !ok {
 			continue
 		}
+
+		// packed files, which are compressed or split across
+		// multiple config maps, are unpacked by an init
+		// container into an empty directory, which is mounted
+		// in the step container in place of the config map.
+		if isPacked(file) {
+			to = append(to, toPackedVolume(file, mount), v1.Volume{
+				Name: file.Metadata.UID,
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			})
+			continue
+		}
+
 		mode := int32(mount.Mode)
 		volume := v1.Volume{Name: file.Metadata.UID}
 
@@ -130,9 +154,15 @@ func toConfigMounts(spec *engine.Spec, step *engine.Step) []v1.VolumeMount {
 		if !ok {
 			continue
 		}
+		// the file is mounted at its exact path using a sub
+		// path, so that the mount does not shadow the other
+		// contents of the parent directory (e.g. the home
+		// directory), nor clash with files mounted in the
+		// same directory.
 		volume := v1.VolumeMount{
 			Name:      file.Metadata.UID,
-			MountPath: path.Dir(mount.Path), // mount the config map here, using the base path
+			MountPath: mount.Path,
+			SubPath:   path.Base(mount.Path),
 		}
 		to = append(to, volume)
 	}
@@ -142,7 +172,7 @@ func toConfigMounts(spec *engine.Spec, step *engine.Step) []v1.VolumeMount {
 func toVolumes(spec *engine.Spec, step *engine.Step) []v1.Volume {
 	var to []v1.Volume
 	for _, mount := range step.Volumes {
-		vol, ok := engine.LookupVolume(spec, mount.Name)
+		vol, ok := engine.LookupStepVolume(spec, step, mount.Name)
 		if !ok {
 			continue
 		}
@@ -166,7 +196,7 @@ func toVolumes(spec *engine.Spec, step *engine.Step) []v1.Volume {
 func toHostPathVolume(spec *engine.Spec, vol *engine.Volume) (hostPathVolume v1.Volume) {
 	var path string
 	if vol.EmptyDir != nil {
-		path = filepath.Join("/tmp", "drone", spec.Metadata.Namespace, vol.Metadata.UID)
+		path = filepath.Join(tempDir(spec, ""), vol.Metadata.UID)
 	} else {
 		path = vol.HostPath.Path
 	}
@@ -179,6 +209,133 @@ func toHostPathVolume(spec *engine.Spec, vol *engine.Volume) (hostPathVolume v1.
 	return
 }
 
+// helper function returns the host directory used to
+// emulate temporary volumes for the pipeline. If a node
+// name is provided it is included in the path, so that
+// builds on different nodes do not collide when /tmp/drone
+// is a shared network filesystem.
+func tempDir(spec *engine.Spec, node string) string {
+	if node != "" {
+		return filepath.Join("/tmp", "drone", node, spec.Metadata.Namespace)
+	}
+	return filepath.Join("/tmp", "drone", spec.Metadata.Namespace)
+}
+
+// helper function rewrites the temporary host path volumes
+// of the pod to the node-specific temporary directory.
+func setNodeTempDir(pod *v1.Pod, spec *engine.Spec, node string) {
+	from := tempDir(spec, "")
+	to := tempDir(spec, node)
+	for _, vol := range pod.Spec.Volumes {
+		if vol.HostPath == nil || !strings.HasPrefix(vol.HostPath.Path, from+"/") {
+			continue
+		}
+		vol.HostPath.Path = to + strings.TrimPrefix(vol.HostPath.Path, from)
+	}
+}
+
+// workingDirImage defines the image used to create the
+// working directory.
+const workingDirImage = "busybox:1"
+
+// helperResources defines the resources of the helper
+// containers created by the engine, which are required when
+// the namespace has a resource quota. The helper containers
+// run a single short-lived command.
+var helperResources = v1.ResourceRequirements{
+	Limits: v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("64Mi"),
+	},
+	Requests: v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("64Mi"),
+	},
+}
+
+// helper function adds an init container that creates the
+// working directory before the step container starts. The
+// init container mounts the step volumes, so this creates
+// a working directory within a volume, such as the
+// workspace, which is otherwise owned by root.
+func setWorkingDir(pod *v1.Pod, step *engine.Step) {
+	if step.WorkingDir == "" || len(pod.Spec.Containers) == 0 {
+		return
+	}
+	container := pod.Spec.Containers[0]
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, v1.Container{
+		Name:            "mkdir-" + step.Metadata.UID,
+		Image:           workingDirImage,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Command:         []string{"mkdir", "-p", step.WorkingDir},
+		SecurityContext: container.SecurityContext,
+		VolumeMounts:    container.VolumeMounts,
+	})
+}
+
+const (
+	// localSSDLabel defines the node label that identifies
+	// nodes with local solid state storage.
+	localSSDLabel = "drone.io/local-ssd"
+
+	// localSSDMountPath defines the container path at
+	// which the local solid state storage is mounted.
+	localSSDMountPath = "/drone/scratch"
+)
+
+// helper function schedules the pod on a node with local
+// solid state storage, and mounts a pipeline directory on
+// the storage as scratch space.
+func setLocalSSD(pod *v1.Pod, spec *engine.Spec, hostPath string) {
+	addNodeRequirement(pod, v1.NodeSelectorRequirement{
+		Key:      localSSDLabel,
+		Operator: v1.NodeSelectorOpIn,
+		Values:   []string{"true"},
+	})
+
+	srcType := v1.HostPathDirectoryOrCreate
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: "local-ssd",
+		VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{
+				Path: filepath.Join(hostPath, spec.Metadata.Namespace),
+				Type: &srcType,
+			},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+			Name:      "local-ssd",
+			MountPath: localSSDMountPath,
+		})
+	}
+}
+
+// helper function adds the requirement to the required
+// node affinity of the pod. The requirement is added to
+// every node selector term, since terms are ORed.
+func addNodeRequirement(pod *v1.Pod, req v1.NodeSelectorRequirement) {
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &v1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &v1.NodeAffinity{}
+	}
+	affinity := pod.Spec.Affinity.NodeAffinity
+	if affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{}
+	}
+	selector := affinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(selector.NodeSelectorTerms) == 0 {
+		selector.NodeSelectorTerms = []v1.NodeSelectorTerm{{}}
+	}
+	for i := range selector.NodeSelectorTerms {
+		term := &selector.NodeSelectorTerms[i]
+		term.MatchExpressions = append(term.MatchExpressions, req)
+	}
+}
+
 // secret volumes must be created one per secret, due to the current
 // structure of secrets in spec
 func toSecretVolumes(spec *engine.Spec, vol *engine.Volume) (volumeList []v1.Volume) {
@@ -192,7 +349,8 @@ func toSecretVolumes(spec *engine.Spec, vol *engine.Volume) (volumeList []v1.Vol
 			Name: fmt.Sprintf("%s-%s", vol.Metadata.UID, item.Key),
 		}
 		secretVolume.Secret = &v1.SecretVolumeSource{
-			SecretName: sec.Metadata.UID,
+			SecretName:  sec.Metadata.UID,
+			DefaultMode: vol.Secret.DefaultMode,
 			Items: []v1.KeyToPath{
 				v1.KeyToPath{
 					Key:  sec.Metadata.UID,
@@ -206,10 +364,55 @@ func toSecretVolumes(spec *engine.Spec, vol *engine.Volume) (volumeList []v1.Vol
 	return volumeList
 }
 
+// helper function returns a secret volume for each secret
+// file of the step, which projects the secret to a file
+// named after the mount path.
+func toSecretFileVolumes(spec *engine.Spec, step *engine.Step) []v1.Volume {
+	var to []v1.Volume
+	for i, file := range step.Docker.SecretFiles {
+		sec, ok := engine.LookupSecret(spec, file.Name)
+		if !ok {
+			continue
+		}
+		to = append(to, v1.Volume{
+			Name: fmt.Sprintf("secret-file-%d", i),
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: sec.Metadata.UID,
+					Items: []v1.KeyToPath{{
+						Key:  sec.Metadata.UID,
+						Path: path.Base(file.Path),
+						Mode: file.Mode,
+					}},
+				},
+			},
+		})
+	}
+	return to
+}
+
+// helper function returns a read-only mount of each secret
+// file of the step at the exact file path.
+func toSecretFileMounts(spec *engine.Spec, step *engine.Step) []v1.VolumeMount {
+	var to []v1.VolumeMount
+	for i, file := range step.Docker.SecretFiles {
+		if _, ok := engine.LookupSecret(spec, file.Name); !ok {
+			continue
+		}
+		to = append(to, v1.VolumeMount{
+			Name:      fmt.Sprintf("secret-file-%d", i),
+			MountPath: file.Path,
+			SubPath:   path.Base(file.Path),
+			ReadOnly:  true,
+		})
+	}
+	return to
+}
+
 func toVolumeMounts(spec *engine.Spec, step *engine.Step) []v1.VolumeMount {
 	var to []v1.VolumeMount
 	for _, mount := range step.Volumes {
-		vol, ok := engine.LookupVolume(spec, mount.Name)
+		vol, ok := engine.LookupStepVolume(spec, step, mount.Name)
 		if !ok {
 			continue
 		}
@@ -233,6 +436,259 @@ func toVolumeMounts(spec *engine.Spec, step *engine.Step) []v1.VolumeMount {
 	return to
 }
 
+// helper function returns the host device volumes. The
+// devices are mounted as character device host paths,
+// which typically requires a privileged container.
+func toDeviceVolumes(spec *engine.Spec, step *engine.Step) []v1.Volume {
+	var to []v1.Volume
+	for _, device := range step.Devices {
+		vol, ok := engine.LookupStepVolume(spec, step, device.Name)
+		if !ok || vol.HostPath == nil {
+			continue
+		}
+		srcType := v1.HostPathCharDev
+		to = append(to, v1.Volume{
+			Name: toDeviceName(vol),
+			VolumeSource: v1.VolumeSource{
+				HostPath: &v1.HostPathVolumeSource{
+					Path: vol.HostPath.Path,
+					Type: &srcType,
+				},
+			},
+		})
+	}
+	return to
+}
+
+// helper function returns the host device mounts.
+func toDeviceMounts(spec *engine.Spec, step *engine.Step) []v1.VolumeMount {
+	var to []v1.VolumeMount
+	for _, device := range step.Devices {
+		vol, ok := engine.LookupStepVolume(spec, step, device.Name)
+		if !ok || vol.HostPath == nil {
+			continue
+		}
+		to = append(to, v1.VolumeMount{
+			Name:      toDeviceName(vol),
+			MountPath: device.DevicePath,
+		})
+	}
+	return to
+}
+
+// helper function returns the pod volume name of the
+// device, which is distinct from the volume name used
+// when the same host path is mounted as a volume.
+func toDeviceName(vol *engine.Volume) string {
+	return "device-" + vol.Metadata.UID
+}
+
+// helper function returns the pod /etc/hosts entries.
+func toHostAliases(step *engine.Step) []v1.HostAlias {
+	var to []v1.HostAlias
+	for _, alias := range step.HostAliases {
+		to = append(to, v1.HostAlias{
+			IP:        alias.IP,
+			Hostnames: alias.Hostnames,
+		})
+	}
+	return to
+}
+
+// dockerSock defines the path of the host Docker socket.
+const dockerSock = "/var/run/docker.sock"
+
+// helper function mounts the host Docker socket read-only
+// into the pod containers.
+func setDockerSock(pod *v1.Pod) {
+	srcType := v1.HostPathSocket
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: "docker-sock",
+		VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{
+				Path: dockerSock,
+				Type: &srcType,
+			},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+			Name:      "docker-sock",
+			MountPath: dockerSock,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// helper function returns the container security context.
+// The user is only applied if it is numeric, since the
+// kubelet cannot resolve user names in the image.
+func toSecurityContext(step *engine.Step) *v1.SecurityContext {
+	sc := &v1.SecurityContext{
+		Privileged: &step.Docker.Privileged,
+	}
+	if uid, gid, err := parseUser(step.Docker.User); err == nil {
+		sc.RunAsUser = uid
+		sc.RunAsGroup = gid
+	}
+	return sc
+}
+
+// helper function parses the user in uid[:gid] format, for
+// example 1000:1000. An error is returned if the user or
+// group is not numeric. An empty user returns nil values.
+func parseUser(user string) (uid, gid *int64, err error) {
+	if user == "" {
+		return nil, nil, nil
+	}
+	parts := strings.SplitN(user, ":", 2)
+	u, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || u < 0 {
+		return nil, nil, fmt.Errorf("kubernetes: user %s must be a numeric uid", user)
+	}
+	uid = &u
+	if len(parts) == 2 {
+		g, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || g < 0 {
+			return nil, nil, fmt.Errorf("kubernetes: group %s must be a numeric gid", parts[1])
+		}
+		gid = &g
+	}
+	return uid, gid, nil
+}
+
+// zoneInfo defines the path of the host timezone database.
+const zoneInfo = "/usr/share/zoneinfo"
+
+// helper function mounts the host timezone database
+// read-only into the pod containers, which is required
+// to set the timezone of images that do not include the
+// timezone database, such as alpine.
+func setZoneInfo(pod *v1.Pod) {
+	srcType := v1.HostPathDirectory
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: "zoneinfo",
+		VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{
+				Path: zoneInfo,
+				Type: &srcType,
+			},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+			Name:      "zoneinfo",
+			MountPath: zoneInfo,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// helper function removes the named environment variable
+// from the pod containers.
+func removeEnv(pod *v1.Pod, name string) {
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		var env []v1.EnvVar
+		for _, v := range container.Env {
+			if v.Name != name {
+				env = append(env, v)
+			}
+		}
+		container.Env = env
+	}
+}
+
+// helper function sets the GOMAXPROCS and JAVA_TOOL_OPTIONS
+// environment variables of the step container to the cpu
+// limit, rounded up to the nearest whole core, since the Go
+// and Java runtimes size their thread pools using the node
+// core count. Variables defined by the step are not changed.
+func setCPUEnv(pod *v1.Pod, step *engine.Step) {
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name != step.Metadata.UID {
+			continue
+		}
+		limit, ok := container.Resources.Limits[v1.ResourceCPU]
+		if !ok || limit.IsZero() {
+			return
+		}
+		procs := strconv.FormatInt((limit.MilliValue()+999)/1000, 10)
+		if _, ok := step.Envs["GOMAXPROCS"]; !ok {
+			container.Env = append(container.Env, v1.EnvVar{
+				Name:  "GOMAXPROCS",
+				Value: procs,
+			})
+		}
+		if _, ok := step.Envs["JAVA_TOOL_OPTIONS"]; !ok {
+			container.Env = append(container.Env, v1.EnvVar{
+				Name:  "JAVA_TOOL_OPTIONS",
+				Value: "-XX:ActiveProcessorCount=" + procs,
+			})
+		}
+	}
+}
+
+// appArmorPrefix defines the annotation key prefix used to
+// configure the AppArmor profile of a pod container. The
+// container name is appended to the prefix.
+const appArmorPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+// helper function annotates the pod containers, including
+// init containers, with the AppArmor profile. The profile
+// is one of runtime/default, unconfined or localhost/<name>.
+func setAppArmorProfile(pod *v1.Pod, profile string) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		pod.Annotations[appArmorPrefix+container.Name] = profile
+	}
+	for _, container := range pod.Spec.Containers {
+		pod.Annotations[appArmorPrefix+container.Name] = profile
+	}
+}
+
+// helper function returns the container command or args.
+// An empty command returns nil so that the image entrypoint
+// (or image command, for args) is executed, consistent with
+// the Docker runtime driver.
+func toCommand(from []string) []string {
+	if len(from) == 0 {
+		return nil
+	}
+	return from
+}
+
+// helper function returns the pod annotations. If the step
+// exposes a metrics endpoint, the annotations required for
+// Prometheus to scrape the pod are included.
+func toAnnotations(step *engine.Step) map[string]string {
+	annotations := map[string]string{}
+	if metrics := step.Docker.Metrics; metrics != nil && metrics.Port != 0 {
+		path := metrics.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		annotations["prometheus.io/scrape"] = "true"
+		annotations["prometheus.io/port"] = strconv.Itoa(metrics.Port)
+		annotations["prometheus.io/path"] = path
+	}
+	// long running steps are not evicted by the cluster
+	// autoscaler, or karpenter, when scaling down nodes.
+	if step.Docker.LongRunning {
+		annotations["cluster-autoscaler.kubernetes.io/safe-to-evict"] = "false"
+		annotations["karpenter.sh/do-not-disrupt"] = "true"
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
 func toPorts(step *engine.Step) []v1.ContainerPort {
 	if len(step.Docker.Ports) == 0 {
 		return nil
@@ -241,59 +697,314 @@ func toPorts(step *engine.Step) []v1.ContainerPort {
 	for _, port := range step.Docker.Ports {
 		ports = append(ports, v1.ContainerPort{
 			ContainerPort: int32(port.Port),
+			HostPort:      int32(port.Host),
+			Protocol:      toProtocol(port.Protocol),
 		})
 	}
 	return ports
 }
 
-// helper function returns a kubernetes namespace
-// for the given specification.
-func toNamespace(spec *engine.Spec) *v1.Namespace {
+// helper function returns the pod security context, or
+// nil if the step does not define supplemental groups.
+func toPodSecurityContext(step *engine.Step) *v1.PodSecurityContext {
+	if len(step.Docker.SupplementalGroups) == 0 {
+		return nil
+	}
+	return &v1.PodSecurityContext{
+		SupplementalGroups: step.Docker.SupplementalGroups,
+	}
+}
+
+// helper function returns the container lifecycle. If
+// the step defines a stop signal, a pre-stop hook sends the
+// signal to the container processes, since kubernetes always
+// stops containers with SIGTERM. The signal is sent to every
+// process in the container, and not only the first process,
+// since the step process is a child of the shell when the
+// step executes commands.
+func toLifecycle(step *engine.Step) *v1.Lifecycle {
+	if step.Docker.StopSignal == "" {
+		return nil
+	}
+	signal := strings.TrimPrefix(strings.ToUpper(step.Docker.StopSignal), "SIG")
+	return &v1.Lifecycle{
+		PreStop: &v1.Handler{
+			Exec: &v1.ExecAction{
+				Command: []string{"/bin/sh", "-c", "kill -" + signal + " -1; kill -" + signal + " 1"},
+			},
+		},
+	}
+}
+
+// helper function returns the readiness probe for the
+// container healthcheck, or nil if the step does not define
+// a healthcheck. The healthcheck test uses the docker format,
+// where the command is prefixed with CMD, or CMD-SHELL if the
+// command is executed by the shell.
+func toProbe(from *engine.Healthcheck) *v1.Probe {
+	if from == nil || len(from.Test) == 0 {
+		return nil
+	}
+	var command []string
+	switch from.Test[0] {
+	case "NONE":
+		return nil
+	case "CMD":
+		command = from.Test[1:]
+	case "CMD-SHELL":
+		command = []string{"/bin/sh", "-c", strings.Join(from.Test[1:], " ")}
+	default:
+		command = from.Test
+	}
+	return &v1.Probe{
+		Handler: v1.Handler{
+			Exec: &v1.ExecAction{Command: command},
+		},
+		InitialDelaySeconds: toSeconds(from.StartPeriod),
+		PeriodSeconds:       toSeconds(from.Interval),
+		TimeoutSeconds:      toSeconds(from.Timeout),
+		FailureThreshold:    int32(from.Retries),
+	}
+}
+
+// helper function converts the duration to seconds, which
+// are used by the kubernetes probe and timeout fields. The
+// duration is rounded up, so that a duration shorter than a
+// second is not truncated to zero, which applies the
+// kubernetes default.
+func toSeconds(d time.Duration) int32 {
+	if d <= 0 {
+		return 0
+	}
+	return int32(math.Ceil(d.Seconds()))
+}
+
+// helper function returns the pod termination grace
+// period, in seconds, or nil to use the default period. The
+// timeout is rounded up to the second, since a zero grace
+// period kills the pod immediately.
+func toGracePeriod(step *engine.Step) *int64 {
+	if step.Docker.StopTimeout <= 0 {
+		return nil
+	}
+	seconds := int64((step.Docker.StopTimeout + time.Second - 1) / time.Second)
+	return &seconds
+}
+
+// helper function returns the kubernetes port protocol,
+// defaulting to tcp.
+func toProtocol(from string) v1.Protocol {
+	switch strings.ToLower(from) {
+	case "udp":
+		return v1.ProtocolUDP
+	case "sctp":
+		return v1.ProtocolSCTP
+	default:
+		return v1.ProtocolTCP
+	}
+}
+
+// helper function returns true if the step binds a port
+// on the host, which restricts scheduling to nodes where
+// the port is free.
+func hasHostPort(step *engine.Step) bool {
+	for _, port := range step.Docker.Ports {
+		if port.Host != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns a copy of the labels with the
+// build id label added.
+func toLabels(spec *engine.Spec, labels map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[labelBuildID] = spec.Metadata.UID
+	return out
+}
+
+// helper function returns the pipeline namespace. The
+// finalizers block removal of the namespace, once deleted,
+// until an external controller has completed its teardown.
+func toNamespace(spec *engine.Spec, finalizers []string) *v1.Namespace {
 	return &v1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   spec.Metadata.Namespace,
-			Labels: spec.Metadata.Labels,
+			Name:       spec.Metadata.Namespace,
+			Labels:     toLabels(spec, spec.Metadata.Labels),
+			Finalizers: finalizers,
 		},
 	}
 }
 
-func toResources(step *engine.Step) v1.ResourceRequirements {
+func toResources(spec *engine.Spec, step *engine.Step) v1.ResourceRequirements {
 	var resources v1.ResourceRequirements
+	var limits, requests engine.ResourceObject
 	if step.Resources != nil && step.Resources.Limits != nil {
+		limits = *step.Resources.Limits
+	}
+	if step.Resources != nil && step.Resources.Requests != nil {
+		requests = *step.Resources.Requests
+	}
+	if spec.Resources != nil && spec.Resources.DefaultRequests != nil {
+		requests = withDefaultRequests(requests, limits, *spec.Resources.DefaultRequests)
+	}
+	if spec.Resources != nil && spec.Resources.MaxLimits != nil {
+		max := *spec.Resources.MaxLimits
+		limits = withMaxLimits(limits, max)
+		requests = withMaxLimits(requests, max)
+	}
+	if step.Resources != nil {
+		switch step.Resources.QoSClass {
+		case engine.QoSGuaranteed:
+			// kubernetes assigns the guaranteed class when
+			// the requests are equal to the limits.
+			requests = limits
+		case engine.QoSBestEffort:
+			// kubernetes assigns the best effort class when
+			// neither requests nor limits are defined.
+			return withExtendedResources(step, resources)
+		}
+	}
+	if limits.Memory > int64(0) || limits.CPU > int64(0) {
 		resources.Limits = v1.ResourceList{}
-		if step.Resources.Limits.Memory > int64(0) {
+		if limits.Memory > int64(0) {
 			resources.Limits[v1.ResourceMemory] = *resource.NewQuantity(
-				step.Resources.Limits.Memory, resource.BinarySI)
+				limits.Memory, resource.BinarySI)
 		}
-		if step.Resources.Limits.CPU > int64(0) {
+		if limits.CPU > int64(0) {
 			resources.Limits[v1.ResourceCPU] = *resource.NewMilliQuantity(
-				step.Resources.Limits.CPU, resource.DecimalSI)
+				limits.CPU, resource.DecimalSI)
 		}
 	}
-	if step.Resources != nil && step.Resources.Requests != nil {
+	if requests.Memory > int64(0) || requests.CPU > int64(0) {
 		resources.Requests = v1.ResourceList{}
-		if step.Resources.Requests.Memory > int64(0) {
+		if requests.Memory > int64(0) {
 			resources.Requests[v1.ResourceMemory] = *resource.NewQuantity(
-				step.Resources.Requests.Memory, resource.BinarySI)
+				requests.Memory, resource.BinarySI)
 		}
-		if step.Resources.Requests.CPU > int64(0) {
+		if requests.CPU > int64(0) {
 			resources.Requests[v1.ResourceCPU] = *resource.NewMilliQuantity(
-				step.Resources.Requests.CPU, resource.DecimalSI)
+				requests.CPU, resource.DecimalSI)
 		}
 	}
+	return withExtendedResources(step, resources)
+}
+
+// helper function adds the step extended resources to the
+// limits, and mirrors them to the requests, since kubernetes
+// requires the requests and limits of extended resources to
+// be equal.
+func withExtendedResources(step *engine.Step, resources v1.ResourceRequirements) v1.ResourceRequirements {
+	if step.Resources == nil || len(step.Resources.Extended) == 0 {
+		return resources
+	}
+	if resources.Limits == nil {
+		resources.Limits = v1.ResourceList{}
+	}
+	if resources.Requests == nil {
+		resources.Requests = v1.ResourceList{}
+	}
+	for name, value := range step.Resources.Extended {
+		quantity := *resource.NewQuantity(value, resource.DecimalSI)
+		resources.Limits[v1.ResourceName(name)] = quantity
+		resources.Requests[v1.ResourceName(name)] = quantity
+	}
 	return resources
 }
 
+// helper function clamps the resources to the maximum
+// values.
+func withMaxLimits(from, max engine.ResourceObject) engine.ResourceObject {
+	if max.CPU > 0 && from.CPU > max.CPU {
+		from.CPU = max.CPU
+	}
+	if max.Memory > 0 && from.Memory > max.Memory {
+		from.Memory = max.Memory
+	}
+	return from
+}
+
+// helper function returns a description of each step
+// resource that exceeds the maximum values, and is clamped
+// by toResources, so that the clamp can be logged.
+func toClamped(spec *engine.Spec, step *engine.Step) []string {
+	if spec.Resources == nil || spec.Resources.MaxLimits == nil || step.Resources == nil {
+		return nil
+	}
+	max := *spec.Resources.MaxLimits
+	var clamped []string
+	for _, r := range []struct {
+		kind string
+		from *engine.ResourceObject
+	}{
+		{"limit", step.Resources.Limits},
+		{"request", step.Resources.Requests},
+	} {
+		if r.from == nil {
+			continue
+		}
+		if max.CPU > 0 && r.from.CPU > max.CPU {
+			clamped = append(clamped, fmt.Sprintf("cpu %s %dm clamped to maximum %dm", r.kind, r.from.CPU, max.CPU))
+		}
+		if max.Memory > 0 && r.from.Memory > max.Memory {
+			clamped = append(clamped, fmt.Sprintf("memory %s %d clamped to maximum %d", r.kind, r.from.Memory, max.Memory))
+		}
+	}
+	return clamped
+}
+
+// helper function returns an error if the step requests
+// the guaranteed quality of service class without defining
+// cpu and memory limits.
+func checkGuaranteed(step *engine.Step) error {
+	if step.Resources == nil || step.Resources.QoSClass != engine.QoSGuaranteed {
+		return nil
+	}
+	limits := step.Resources.Limits
+	if limits == nil || limits.CPU <= 0 || limits.Memory <= 0 {
+		return fmt.Errorf("kubernetes: step %s: guaranteed qos class requires cpu and memory limits", step.Metadata.Name)
+	}
+	return nil
+}
+
+// helper function applies the default requests to any
+// resource not explicitly requested by the step. A default
+// request never exceeds the step limit, which would result
+// in an invalid pod.
+func withDefaultRequests(requests, limits, defaults engine.ResourceObject) engine.ResourceObject {
+	if requests.CPU == 0 {
+		requests.CPU = defaults.CPU
+		if limits.CPU > 0 && requests.CPU > limits.CPU {
+			requests.CPU = limits.CPU
+		}
+	}
+	if requests.Memory == 0 {
+		requests.Memory = defaults.Memory
+		if limits.Memory > 0 && requests.Memory > limits.Memory {
+			requests.Memory = limits.Memory
+		}
+	}
+	return requests
+}
+
 // helper function returns a kubernetes pod for the
 // given step and specification.
 func toPod(spec *engine.Spec, step *engine.Step) *v1.Pod {
 	var volumes []v1.Volume
 	volumes = append(volumes, toVolumes(spec, step)...)
 	volumes = append(volumes, toConfigVolumes(spec, step)...)
+	volumes = append(volumes, toDeviceVolumes(spec, step)...)
+	volumes = append(volumes, toSecretFileVolumes(spec, step)...)
 
 	var mounts []v1.VolumeMount
 	mounts = append(mounts, toVolumeMounts(spec, step)...)
 	mounts = append(mounts, toConfigMounts(spec, step)...)
+	mounts = append(mounts, toDeviceMounts(spec, step)...)
+	mounts = append(mounts, toSecretFileMounts(spec, step)...)
 
 	var pullSecrets []v1.LocalObjectReference
 	if len(spec.Docker.Auths) > 0 {
@@ -310,58 +1021,116 @@ func toPod(spec *engine.Spec, step *engine.Step) *v1.Pod {
 		}
 	}
 
-	return &v1.Pod{
+	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      step.Metadata.UID,
-			Namespace: step.Metadata.Namespace,
-			Labels:    step.Metadata.Labels,
+			Name:        step.Metadata.UID,
+			Namespace:   step.Metadata.Namespace,
+			Labels:      toLabels(spec, step.Metadata.Labels),
+			Annotations: toAnnotations(step),
 		},
 		Spec: v1.PodSpec{
 			AutomountServiceAccountToken: &automountServiceAccountToken,
 			RestartPolicy:                v1.RestartPolicyNever,
 			Containers: []v1.Container{{
 				Name:            step.Metadata.UID,
-				Image:           step.Docker.Image,
+				Image:           engine.MirrorImage(spec, step.Docker.Image),
 				ImagePullPolicy: toPullPolicy(step.Docker.PullPolicy),
-				Command:         step.Docker.Command,
-				Args:            step.Docker.Args,
+				Command:         toCommand(step.Docker.Command),
+				Args:            toCommand(step.Docker.Args),
 				WorkingDir:      step.WorkingDir,
-				SecurityContext: &v1.SecurityContext{
-					Privileged: &step.Docker.Privileged,
-				},
-				Env:          toEnv(spec, step),
-				VolumeMounts: mounts,
-				Ports:        toPorts(step),
-				Resources:    toResources(step),
+				SecurityContext: toSecurityContext(step),
+				Env:             toEnv(spec, step),
+				VolumeMounts:    mounts,
+				Ports:           toPorts(step),
+				Resources:       toResources(spec, step),
+				Lifecycle:       toLifecycle(step),
+				ReadinessProbe:  toProbe(step.Docker.Healthcheck),
+				TTY:             step.Docker.TTY,
+				Stdin:           step.Docker.Stdin || step.Docker.TTY,
+				StdinOnce:       step.Docker.Stdin || step.Docker.TTY,
 			}},
-			ImagePullSecrets: pullSecrets,
-			Volumes:          volumes,
+			InitContainers:                toFileInitContainers(spec, step),
+			ImagePullSecrets:              pullSecrets,
+			HostAliases:                   toHostAliases(step),
+			Volumes:                       volumes,
+			TerminationGracePeriodSeconds: toGracePeriod(step),
+			SecurityContext:               toPodSecurityContext(step),
 		},
 	}
+	if profile := step.Docker.AppArmorProfile; profile != "" {
+		setAppArmorProfile(pod, profile)
+	}
+	return pod
+}
+
+// expandPattern matches ${DRONE_*} tokens. Other forms of
+// variable substitution are not supported, since args may
+// legitimately contain shell variables.
+var expandPattern = regexp.MustCompile(`\$\{(DRONE_[A-Za-z0-9_]+)\}`)
+
+// helper function substitutes ${DRONE_*} tokens in the
+// container command and args with the matching step
+// environment variables, which are populated with the
+// pipeline metadata. Unknown tokens are left unchanged.
+func expandCommand(pod *v1.Pod, step *engine.Step) {
+	expand := func(s string) string {
+		return expandPattern.ReplaceAllStringFunc(s, func(token string) string {
+			name := expandPattern.FindStringSubmatch(token)[1]
+			if value, ok := step.Envs[name]; ok {
+				return value
+			}
+			return token
+		})
+	}
+	// the container command and args share the underlying
+	// arrays with the step, and are therefore copied.
+	expandAll := func(from []string) []string {
+		var to []string
+		for _, s := range from {
+			to = append(to, expand(s))
+		}
+		return to
+	}
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		container.Command = expandAll(container.Command)
+		container.Args = expandAll(container.Args)
+	}
 }
 
 // helper function returns a kubernetes service for the
 // given step and specification.
 func toService(spec *engine.Spec, step *engine.Step) *v1.Service {
+	if isExternal(step) {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      toDNS(step.Metadata.Name),
+				Namespace: step.Metadata.Namespace,
+				Labels:    toLabels(spec, step.Metadata.Labels),
+			},
+			Spec: v1.ServiceSpec{
+				Type:         v1.ServiceTypeExternalName,
+				ExternalName: step.Docker.Service.ExternalName,
+			},
+		}
+	}
+	// the service targets the container port. The host
+	// port is only exposed on the node, and is not reachable
+	// through the service.
 	var ports []v1.ServicePort
 	for _, p := range step.Docker.Ports {
-		source := p.Port
-		target := p.Host
-		if target == 0 {
-			target = source
-		}
 		ports = append(ports, v1.ServicePort{
-			Name: strconv.Itoa(source),
-			Port: int32(source),
-			TargetPort: intstr.IntOrString{
-				IntVal: int32(target),
-			},
+			Name:       strconv.Itoa(p.Port),
+			Port:       int32(p.Port),
+			TargetPort: intstr.FromInt(p.Port),
+			Protocol:   toProtocol(p.Protocol),
 		})
 	}
-	return &v1.Service{
+	service := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      toDNS(step.Metadata.Name),
 			Namespace: step.Metadata.Namespace,
+			Labels:    toLabels(spec, step.Metadata.Labels),
 		},
 		Spec: v1.ServiceSpec{
 			Type: v1.ServiceTypeClusterIP,
@@ -371,6 +1140,215 @@ func toService(spec *engine.Spec, step *engine.Step) *v1.Service {
 			Ports: ports,
 		},
 	}
+	if config := step.Docker.Service; config != nil && config.ClientIPAffinity {
+		service.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+		if config.AffinityTimeout > 0 {
+			timeout := int32(config.AffinityTimeout)
+			service.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{
+				ClientIP: &v1.ClientIPConfig{
+					TimeoutSeconds: &timeout,
+				},
+			}
+		}
+	}
+	return service
+}
+
+// helper function returns an error naming all secrets,
+// files and volumes referenced by the step that cannot be
+// found in the specification. The pod conversion functions
+// silently skip missing references, which is used to
+// implement the lenient (default) behavior.
+func checkReferences(spec *engine.Spec, step *engine.Step) error {
+	var missing []string
+	for _, secret := range step.Secrets {
+		if _, ok := engine.LookupSecret(spec, secret.Name); !ok {
+			missing = append(missing, "secret "+secret.Name)
+		}
+	}
+	for _, mount := range step.Files {
+		if _, ok := engine.LookupFile(spec, mount.Name); !ok {
+			missing = append(missing, "file "+mount.Name)
+		}
+	}
+	for _, file := range step.Docker.SecretFiles {
+		if _, ok := engine.LookupSecret(spec, file.Name); !ok {
+			missing = append(missing, "secret "+file.Name)
+		}
+	}
+	for _, mount := range step.Volumes {
+		vol, ok := engine.LookupVolume(spec, mount.Name)
+		if !ok {
+			missing = append(missing, "volume "+mount.Name)
+			continue
+		}
+		if vol.Secret == nil {
+			continue
+		}
+		for _, item := range vol.Secret.Items {
+			name := fmt.Sprintf("%s-%s-%s", vol.Metadata.Name, vol.Secret.Name, item.Key)
+			if _, ok := engine.LookupSecret(spec, name); !ok {
+				missing = append(missing, "secret "+name)
+			}
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("kubernetes: step %s has missing references: %s",
+			step.Metadata.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// helper function returns the engine state for the
+// given pod.
+func toState(pod *v1.Pod) *engine.State {
+	state := &engine.State{
+		IP:   pod.Status.PodIP,
+		Node: pod.Spec.NodeName,
+	}
+	switch pod.Status.Phase {
+	case v1.PodSucceeded, v1.PodFailed, v1.PodUnknown:
+		state.Exited = true
+	}
+	for _, condition := range pod.Status.Conditions {
+		state.Conditions = append(state.Conditions, &engine.Condition{
+			Type:    string(condition.Type),
+			Status:  string(condition.Status),
+			Time:    condition.LastTransitionTime.Time,
+			Reason:  condition.Reason,
+			Message: condition.Message,
+		})
+	}
+	status, ok := toStepStatus(pod)
+	if !ok {
+		return state
+	}
+	if terminated := status.State.Terminated; terminated != nil {
+		state.ExitCode = int(terminated.ExitCode)
+		state.OOMKilled = terminated.Reason == "OOMKilled"
+	}
+	return state
+}
+
+// helper function returns the status of the step container,
+// which is the first container of the pod. Sidecar container
+// statuses are ignored.
+func toStepStatus(pod *v1.Pod) (v1.ContainerStatus, bool) {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return v1.ContainerStatus{}, false
+	}
+	if len(pod.Spec.Containers) != 0 {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == pod.Spec.Containers[0].Name {
+				return status, true
+			}
+		}
+	}
+	return pod.Status.ContainerStatuses[0], true
+}
+
+// helper function returns an error if the pod has been
+// reported as unschedulable for longer than the grace
+// period. The error includes the scheduler message.
+func checkSchedulable(pod *v1.Pod, grace time.Duration) error {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type != v1.PodScheduled ||
+			cond.Status != v1.ConditionFalse ||
+			cond.Reason != v1.PodReasonUnschedulable {
+			continue
+		}
+		if time.Since(cond.LastTransitionTime.Time) < grace {
+			return nil
+		}
+		return fmt.Errorf("kubernetes: pod %s is unschedulable: %s", pod.Name, cond.Message)
+	}
+	return nil
+}
+
+// helper function returns an error if the pod containers,
+// including init containers, are still being created, which
+// includes pulling the image, longer than the timeout after
+// the pod was scheduled.
+func checkPulling(pod *v1.Pod, timeout time.Duration) error {
+	if pod.Status.Phase != v1.PodPending {
+		return nil
+	}
+	var scheduled time.Time
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodScheduled && cond.Status == v1.ConditionTrue {
+			scheduled = cond.LastTransitionTime.Time
+		}
+	}
+	if scheduled.IsZero() || time.Since(scheduled) < timeout {
+		return nil
+	}
+	var statuses []v1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ContainerCreating", "ErrImagePull", "ImagePullBackOff":
+			return engine.WrapError(engine.ErrImagePull,
+				fmt.Errorf("kubernetes: timeout pulling image %s", status.Image))
+		}
+	}
+	return nil
+}
+
+// helper function returns an error if a pod container,
+// including init containers, cannot pull its image. The
+// kubelet retries the pull indefinitely, so the failure is
+// reported as soon as it is observed.
+func checkImagePull(pod *v1.Pod) error {
+	if pod.Status.Phase != v1.PodPending {
+		return nil
+	}
+	var statuses []v1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+			return engine.WrapError(engine.ErrImagePull,
+				fmt.Errorf("kubernetes: cannot pull image %s: %s", status.Image, status.State.Waiting.Reason))
+		}
+	}
+	return nil
+}
+
+// helper function returns an error naming the first init
+// container that failed, if any. The step container does
+// not start when an init container fails, and therefore
+// reports no exit code of its own.
+func checkInitContainers(pod *v1.Pod) error {
+	for _, status := range pod.Status.InitContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated == nil || terminated.ExitCode == 0 {
+			continue
+		}
+		if terminated.Message != "" {
+			return fmt.Errorf("kubernetes: init container %s failed with exit code %d: %s",
+				status.Name, terminated.ExitCode, terminated.Message)
+		}
+		return fmt.Errorf("kubernetes: init container %s failed with exit code %d",
+			status.Name, terminated.ExitCode)
+	}
+	return nil
+}
+
+// helper function annotates the api error with the engine
+// error kind, if the kind is known.
+func toError(err error) error {
+	if apierrors.IsForbidden(err) {
+		return engine.WrapError(engine.ErrForbidden, err)
+	}
+	return err
 }
 
 func toDNS(i string) string {
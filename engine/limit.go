@@ -0,0 +1,67 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"io"
+)
+
+// logTruncated defines the marker written to the log once
+// the log limit is exceeded.
+const logTruncated = "\n[log truncated: exceeded the limit of %d bytes]\n"
+
+// LimitLogs returns a ReadCloser that reads the log up to
+// the limit in bytes. If the log exceeds the limit, a log
+// truncated marker is returned and the log is no longer
+// read, which protects the runner from runaway steps. The
+// log is not limited if the limit is zero.
+func LimitLogs(rc io.ReadCloser, limit int64) io.ReadCloser {
+	if limit <= 0 {
+		return rc
+	}
+	return &limitReader{rc: rc, limit: limit, remaining: limit}
+}
+
+type limitReader struct {
+	rc        io.ReadCloser
+	limit     int64
+	remaining int64
+	marker    []byte
+	done      bool
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if r.done {
+		if len(r.marker) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(p, r.marker)
+		r.marker = r.marker[n:]
+		return n, nil
+	}
+	if r.remaining <= 0 {
+		// the limit is reached, in which case a single byte
+		// is read to determine if the log exceeds the limit.
+		var b [1]byte
+		n, err := io.ReadFull(r.rc, b[:])
+		r.done = true
+		if n == 0 {
+			return 0, err
+		}
+		r.marker = []byte(fmt.Sprintf(logTruncated, r.limit))
+		return r.Read(p)
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.rc.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func (r *limitReader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLimitLogs(t *testing.T) {
+	rc := LimitLogs(ioutil.NopCloser(strings.NewReader("hello world\n")), 5)
+	out, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := "hello\n[log truncated: exceeded the limit of 5 bytes]\n"
+	if got := string(out); got != want {
+		t.Errorf("Want truncated log %q, got %q", want, got)
+	}
+}
+
+func TestLimitLogs_WithinLimit(t *testing.T) {
+	for _, limit := range []int64{0, 12, 100} {
+		rc := LimitLogs(ioutil.NopCloser(strings.NewReader("hello world\n")), limit)
+		out, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got, want := string(out), "hello world\n"; got != want {
+			t.Errorf("Want log %q with limit %d, got %q", want, limit, got)
+		}
+	}
+}
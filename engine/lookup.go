@@ -23,6 +23,30 @@ func LookupVolume(spec *Spec, name string) (*Volume, bool) {
 	return nil, false
 }
 
+// LookupStepVolume is a helper function that will lookup
+// the named volume, if the volume is shared with the step.
+func LookupStepVolume(spec *Spec, step *Step, name string) (*Volume, bool) {
+	vol, ok := LookupVolume(spec, name)
+	if !ok || !IsSharedWith(vol, step) {
+		return nil, false
+	}
+	return vol, true
+}
+
+// IsSharedWith returns true if the volume is shared with
+// the step.
+func IsSharedWith(vol *Volume, step *Step) bool {
+	if len(vol.SharedWith) == 0 {
+		return true
+	}
+	for _, uid := range vol.SharedWith {
+		if uid == step.Metadata.UID {
+			return true
+		}
+	}
+	return false
+}
+
 // LookupSecret is a helper function that will lookup the
 // named secret.
 func LookupSecret(spec *Spec, name string) (*Secret, bool) {
@@ -113,6 +113,30 @@ func TestLookupVolume_NotDocker(t *testing.T) {
 	}
 }
 
+func TestLookupStepVolume(t *testing.T) {
+	volume := &Volume{
+		Metadata:   Metadata{Name: "foo"},
+		SharedWith: []string{"uid_build"},
+	}
+	spec := &Spec{
+		Docker: &DockerConfig{
+			Volumes: []*Volume{volume},
+		},
+	}
+	build := &Step{Metadata: Metadata{UID: "uid_build"}}
+	if got, ok := LookupStepVolume(spec, build, "foo"); !ok || got != volume {
+		t.Errorf("Expect volume shared with step")
+	}
+	test := &Step{Metadata: Metadata{UID: "uid_test"}}
+	if _, ok := LookupStepVolume(spec, test, "foo"); ok {
+		t.Errorf("Expect volume not shared with step")
+	}
+	volume.SharedWith = nil
+	if _, ok := LookupStepVolume(spec, test, "foo"); !ok {
+		t.Errorf("Expect volume shared with all steps")
+	}
+}
+
 //
 // Auth Lookup Tests
 //
@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "strings"
+
+// MirrorImage is a helper function that rewrites the image
+// name to use the first matching registry mirror. Images
+// are matched against the mirror prefix using the fully
+// qualified image name, so that docker hub short names,
+// such as alpine, match the docker.io/library prefix. The
+// image is returned unchanged if no mirror matches.
+func MirrorImage(spec *Spec, image string) string {
+	if spec.Docker == nil || len(spec.Docker.Mirrors) == 0 {
+		return image
+	}
+	qualified := qualifyImage(image)
+	for _, mirror := range spec.Docker.Mirrors {
+		prefix := strings.TrimSuffix(mirror.Prefix, "/")
+		if prefix == "" || !strings.HasPrefix(qualified, prefix+"/") {
+			continue
+		}
+		return strings.TrimSuffix(mirror.Mirror, "/") +
+			strings.TrimPrefix(qualified, prefix)
+	}
+	return image
+}
+
+// helper function returns the fully qualified image name,
+// including the registry domain. Docker hub images without
+// a domain are qualified with docker.io, and official
+// images with the library namespace.
+func qualifyImage(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return image
+	}
+	if len(parts) == 1 {
+		return "docker.io/library/" + image
+	}
+	return "docker.io/" + image
+}
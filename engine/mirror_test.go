@@ -0,0 +1,45 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestMirrorImage(t *testing.T) {
+	spec := &Spec{
+		Docker: &DockerConfig{
+			Mirrors: []*Mirror{
+				{Prefix: "docker.io", Mirror: "mirror.internal"},
+				{Prefix: "gcr.io/google-containers", Mirror: "mirror.internal:5000/gcr"},
+			},
+		},
+	}
+	tests := []struct {
+		image string
+		want  string
+	}{
+		// docker hub short names
+		{"alpine", "mirror.internal/library/alpine"},
+		{"alpine:3.6", "mirror.internal/library/alpine:3.6"},
+		{"octocat/hello-world", "mirror.internal/octocat/hello-world"},
+		// explicit registries
+		{"docker.io/library/golang:1.11", "mirror.internal/library/golang:1.11"},
+		{"gcr.io/google-containers/pause:3.1", "mirror.internal:5000/gcr/pause:3.1"},
+		// no match
+		{"gcr.io/other/pause:3.1", "gcr.io/other/pause:3.1"},
+		{"quay.io/coreos/etcd", "quay.io/coreos/etcd"},
+		{"localhost/alpine", "localhost/alpine"},
+	}
+	for _, test := range tests {
+		if got := MirrorImage(spec, test.image); got != test.want {
+			t.Errorf("Want image %s rewritten to %s, got %s", test.image, test.want, got)
+		}
+	}
+}
+
+func TestMirrorImage_NoMirrors(t *testing.T) {
+	if got, want := MirrorImage(&Spec{}, "alpine"), "alpine"; got != want {
+		t.Errorf("Want image %s unchanged, got %s", want, got)
+	}
+}
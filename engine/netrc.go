@@ -0,0 +1,43 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"path"
+)
+
+// Netrc defines the git credentials written to the netrc
+// file, which is used by git and curl to authenticate.
+type Netrc struct {
+	Machine  string
+	Login    string
+	Password string
+}
+
+// MountNetrc is a helper function that adds the netrc
+// credentials to the specification as a secret file, and
+// mounts the file in every pipeline step at .netrc in the
+// home directory, readable only by the owner. The home
+// directory defaults to /root.
+func MountNetrc(spec *Spec, uid, home string, netrc *Netrc) {
+	if home == "" {
+		home = "/root"
+	}
+	file := &File{
+		Metadata: Metadata{UID: uid, Name: "netrc"},
+		Data: []byte(fmt.Sprintf("machine %s login %s password %s\n",
+			netrc.Machine, netrc.Login, netrc.Password)),
+		Secret: true,
+	}
+	spec.Files = append(spec.Files, file)
+	for _, step := range spec.Steps {
+		step.Files = append(step.Files, &FileMount{
+			Name: file.Metadata.Name,
+			Path: path.Join(home, ".netrc"),
+			Mode: 0600,
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMountNetrc(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{Metadata: Metadata{Name: "clone"}},
+		},
+	}
+	MountNetrc(spec, "uid_netrc", "", &Netrc{
+		Machine:  "github.com",
+		Login:    "octocat",
+		Password: "correct-horse-battery-staple",
+	})
+
+	file, ok := LookupFile(spec, "netrc")
+	if !ok {
+		t.Errorf("Expect netrc file")
+		return
+	}
+	if !file.Secret {
+		t.Errorf("Expect netrc file stored as a secret")
+	}
+	if got, want := string(file.Data), "machine github.com login octocat password correct-horse-battery-staple\n"; got != want {
+		t.Errorf("Want netrc %q, got %q", want, got)
+	}
+
+	want := []*FileMount{{Name: "netrc", Path: "/root/.netrc", Mode: 0600}}
+	if diff := cmp.Diff(spec.Steps[0].Files, want); diff != "" {
+		t.Errorf("Unexpected netrc mount")
+		t.Log(diff)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PlatformResolver resolves the platforms supported by an
+// image, which is used to verify the step images can run on
+// the target platform before the steps are scheduled.
+type PlatformResolver interface {
+	// Platforms returns the platforms supported by the
+	// image. The credentials are nil if no credentials are
+	// configured for the registry.
+	Platforms(ctx context.Context, image string, auth *DockerAuth) ([]Platform, error)
+}
+
+// CheckPlatforms returns an error if the image of any step
+// does not support the target platform of the pipeline,
+// which otherwise fails with an exec format error once the
+// step is scheduled. The platform defaults to linux/amd64.
+// Each image is resolved once.
+func CheckPlatforms(ctx context.Context, spec *Spec, resolver PlatformResolver) error {
+	os, arch := spec.Platform.OS, spec.Platform.Arch
+	if os == "" {
+		os = "linux"
+	}
+	if arch == "" {
+		arch = "amd64"
+	}
+	checked := map[string]bool{}
+	for _, step := range spec.Steps {
+		if step.Docker == nil || step.Docker.Image == "" {
+			continue
+		}
+		image := MirrorImage(spec, step.Docker.Image)
+		if checked[image] {
+			continue
+		}
+		checked[image] = true
+
+		domain, _, _ := splitImage(strings.SplitN(image, "@", 2)[0])
+		auth, _ := LookupAuth(spec, domain)
+		platforms, err := resolver.Platforms(ctx, image, auth)
+		if err != nil {
+			return fmt.Errorf("engine: cannot resolve platforms of image %s: %s", image, err)
+		}
+		var supported []string
+		for _, platform := range platforms {
+			if platform.OS == os && platform.Arch == arch {
+				supported = nil
+				break
+			}
+			supported = append(supported, platform.OS+"/"+platform.Arch)
+		}
+		if len(supported) != 0 {
+			return fmt.Errorf("engine: image %s does not support platform %s/%s, supported platforms: %s",
+				step.Docker.Image, os, arch, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
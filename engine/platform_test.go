@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckPlatforms(t *testing.T) {
+	spec := &Spec{
+		Platform: Platform{OS: "linux", Arch: "arm64"},
+		Steps: []*Step{
+			{Docker: &DockerStep{Image: "golang:1.11"}},
+			{Docker: &DockerStep{Image: "golang:1.11"}},
+		},
+	}
+	resolver := &mockPlatformResolver{
+		platforms: []Platform{
+			{OS: "linux", Arch: "amd64"},
+			{OS: "linux", Arch: "arm64"},
+		},
+	}
+	if err := CheckPlatforms(context.Background(), spec, resolver); err != nil {
+		t.Error(err)
+	}
+	if got, want := resolver.calls, 1; got != want {
+		t.Errorf("Want image resolved %d times, got %d", want, got)
+	}
+}
+
+func TestCheckPlatforms_Unsupported(t *testing.T) {
+	spec := &Spec{
+		Platform: Platform{OS: "linux", Arch: "arm64"},
+		Steps: []*Step{
+			{Docker: &DockerStep{Image: "golang:1.11"}},
+		},
+	}
+	resolver := &mockPlatformResolver{
+		platforms: []Platform{
+			{OS: "linux", Arch: "amd64"},
+			{OS: "windows", Arch: "amd64"},
+		},
+	}
+	err := CheckPlatforms(context.Background(), spec, resolver)
+	if err == nil {
+		t.Errorf("Expect error when image does not support the platform")
+		return
+	}
+	want := "engine: image golang:1.11 does not support platform linux/arm64, supported platforms: linux/amd64, windows/amd64"
+	if got := err.Error(); got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestCheckPlatforms_DefaultPlatform(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{Docker: &DockerStep{Image: "golang:1.11"}},
+		},
+	}
+	resolver := &mockPlatformResolver{
+		platforms: []Platform{{OS: "linux", Arch: "arm64"}},
+	}
+	if err := CheckPlatforms(context.Background(), spec, resolver); err == nil {
+		t.Errorf("Expect platform defaults to linux/amd64")
+	}
+}
+
+type mockPlatformResolver struct {
+	platforms []Platform
+	calls     int
+}
+
+func (r *mockPlatformResolver) Platforms(context.Context, string, *DockerAuth) ([]Platform, error) {
+	r.calls++
+	return r.platforms, nil
+}
@@ -0,0 +1,302 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// registryHosts maps registry domains to the registry api
+// host, where the two differ.
+var registryHosts = map[string]string{
+	"docker.io": "registry-1.docker.io",
+}
+
+// manifestTypes defines the accepted manifest media types.
+// Manifest lists are accepted so that the digest of a
+// multi-arch image references all platforms.
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// NewRegistryResolver returns a DigestResolver that
+// resolves the image digest using the registry api.
+func NewRegistryResolver(client *http.Client) DigestResolver {
+	return newRegistry(client)
+}
+
+// NewRegistryInspector returns an ImageInspector that
+// fetches the image configuration using the registry api.
+func NewRegistryInspector(client *http.Client) ImageInspector {
+	return newRegistry(client)
+}
+
+// NewPlatformResolver returns a PlatformResolver that
+// resolves the image platforms using the registry api.
+func NewPlatformResolver(client *http.Client) PlatformResolver {
+	return newRegistry(client)
+}
+
+func newRegistry(client *http.Client) *registryResolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &registryResolver{client: client, scheme: "https"}
+}
+
+type registryResolver struct {
+	client *http.Client
+	scheme string
+}
+
+func (r *registryResolver) Resolve(ctx context.Context, image string, auth *DockerAuth) (string, error) {
+	domain, path, tag := splitImage(image)
+	res, err := r.do(ctx, "HEAD", r.url(domain, path, "manifests", tag), manifestTypes, auth)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry did not return a digest")
+	}
+	return digest, nil
+}
+
+func (r *registryResolver) Inspect(ctx context.Context, image string, auth *DockerAuth) (*ImageConfig, error) {
+	var digest string
+	if i := strings.Index(image, "@"); i != -1 {
+		image, digest = image[:i], image[i+1:]
+	}
+	domain, path, ref := splitImage(image)
+	if digest != "" {
+		ref = digest
+	}
+	manifest := struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}{}
+	if err := r.get(ctx, r.url(domain, path, "manifests", ref), manifestTypes, auth, &manifest); err != nil {
+		return nil, err
+	}
+
+	// the manifest is a manifest list, in which case we
+	// fetch the linux/amd64 manifest, or the first manifest
+	// if the image is not published for linux/amd64.
+	if len(manifest.Manifests) != 0 {
+		digest := manifest.Manifests[0].Digest
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
+				digest = m.Digest
+				break
+			}
+		}
+		manifest.Manifests = nil
+		if err := r.get(ctx, r.url(domain, path, "manifests", digest), manifestTypes, auth, &manifest); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("registry did not return an image configuration")
+	}
+
+	blob := struct {
+		Config ImageConfig `json:"config"`
+	}{}
+	if err := r.get(ctx, r.url(domain, path, "blobs", manifest.Config.Digest), nil, auth, &blob); err != nil {
+		return nil, err
+	}
+	return &blob.Config, nil
+}
+
+func (r *registryResolver) Platforms(ctx context.Context, image string, auth *DockerAuth) ([]Platform, error) {
+	var digest string
+	if i := strings.Index(image, "@"); i != -1 {
+		image, digest = image[:i], image[i+1:]
+	}
+	domain, path, ref := splitImage(image)
+	if digest != "" {
+		ref = digest
+	}
+	manifest := struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Manifests []struct {
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}{}
+	if err := r.get(ctx, r.url(domain, path, "manifests", ref), manifestTypes, auth, &manifest); err != nil {
+		return nil, err
+	}
+
+	// the manifest is a manifest list, in which case the
+	// platforms are listed in the manifest.
+	if len(manifest.Manifests) != 0 {
+		var platforms []Platform
+		for _, m := range manifest.Manifests {
+			platforms = append(platforms, Platform{
+				OS:      m.Platform.OS,
+				Arch:    m.Platform.Architecture,
+				Variant: m.Platform.Variant,
+			})
+		}
+		return platforms, nil
+	}
+
+	// the manifest is a single platform manifest, in which
+	// case the platform is defined in the image configuration.
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("registry did not return an image configuration")
+	}
+	blob := struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	}{}
+	if err := r.get(ctx, r.url(domain, path, "blobs", manifest.Config.Digest), nil, auth, &blob); err != nil {
+		return nil, err
+	}
+	return []Platform{{OS: blob.OS, Arch: blob.Architecture, Variant: blob.Variant}}, nil
+}
+
+// helper function returns the registry api url of the
+// named repository resource.
+func (r *registryResolver) url(domain, path, kind, ref string) string {
+	host, ok := registryHosts[domain]
+	if !ok {
+		host = domain
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", r.scheme, host, path, kind, ref)
+}
+
+// helper function requests the resource and decodes the
+// json response body into v.
+func (r *registryResolver) get(ctx context.Context, uri string, accept []string, auth *DockerAuth, v interface{}) error {
+	res, err := r.do(ctx, "GET", uri, accept, auth)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// helper function sends the request to the registry. If the
+// registry requests token authentication, a token is requested
+// and the request is retried.
+func (r *registryResolver) do(ctx context.Context, method, uri string, accept []string, auth *DockerAuth) (*http.Response, error) {
+	res, err := r.send(ctx, method, uri, accept, auth, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		res.Body.Close()
+		token, err := r.token(ctx, res.Header.Get("Www-Authenticate"), auth)
+		if err != nil {
+			return nil, err
+		}
+		res, err = r.send(ctx, method, uri, accept, nil, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("registry returned status %d", res.StatusCode)
+	}
+	return res, nil
+}
+
+func (r *registryResolver) send(ctx context.Context, method, uri string, accept []string, auth *DockerAuth, token string) (*http.Response, error) {
+	req, err := http.NewRequest(method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if len(accept) != 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	return r.client.Do(req)
+}
+
+// helper function requests a bearer token from the
+// authorization server defined in the challenge header.
+func (r *registryResolver) token(ctx context.Context, challenge string, auth *DockerAuth) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("registry returned status 401")
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(parts) == 2 {
+			params[parts[0]] = strings.Trim(parts[1], `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("registry returned an invalid token realm")
+	}
+	query := realm.Query()
+	if v := params["service"]; v != "" {
+		query.Set("service", v)
+	}
+	if v := params["scope"]; v != "" {
+		query.Set("scope", v)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token server returned status %d", res.StatusCode)
+	}
+	out := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Token == "" {
+		return out.AccessToken, nil
+	}
+	return out.Token, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegistryResolver(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != "repository:octocat/hello-world:pull" {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{"token":"f1a2b3"}`))
+		case r.Header.Get("Authorization") != "Bearer f1a2b3":
+			w.Header().Set("Www-Authenticate",
+				`Bearer realm="`+server.URL+`/token",service="registry",scope="repository:octocat/hello-world:pull"`)
+			w.WriteHeader(401)
+		case r.URL.Path == "/v2/octocat/hello-world/manifests/1.0":
+			w.Header().Set("Docker-Content-Digest", "sha256:c1a7e2")
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	resolver := &registryResolver{client: server.Client(), scheme: "http"}
+	digest, err := resolver.Resolve(context.Background(), host+"/octocat/hello-world:1.0", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := digest, "sha256:c1a7e2"; got != want {
+		t.Errorf("Want digest %s, got %s", want, got)
+	}
+}
+
+func TestRegistryInspector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/octocat/hello-world/manifests/1.0":
+			w.Write([]byte(`{"manifests":[
+				{"digest":"sha256:a1","platform":{"os":"linux","architecture":"arm64"}},
+				{"digest":"sha256:b2","platform":{"os":"linux","architecture":"amd64"}}]}`))
+		case "/v2/octocat/hello-world/manifests/sha256:b2":
+			w.Write([]byte(`{"config":{"digest":"sha256:c3"}}`))
+		case "/v2/octocat/hello-world/blobs/sha256:c3":
+			w.Write([]byte(`{"config":{"Entrypoint":["/bin/hello"],"Cmd":["--world"]}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	inspector := &registryResolver{client: server.Client(), scheme: "http"}
+	config, err := inspector.Inspect(context.Background(), host+"/octocat/hello-world:1.0", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := &ImageConfig{
+		Entrypoint: []string{"/bin/hello"},
+		Cmd:        []string{"--world"},
+	}
+	if diff := cmp.Diff(config, want); diff != "" {
+		t.Errorf("Unexpected image config")
+		t.Log(diff)
+	}
+}
+
+func TestRegistryInspector_Digest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/octocat/hello-world/manifests/sha256:b2":
+			w.Write([]byte(`{"config":{"digest":"sha256:c3"}}`))
+		case "/v2/octocat/hello-world/blobs/sha256:c3":
+			w.Write([]byte(`{"config":{"Entrypoint":["/bin/hello"]}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	inspector := &registryResolver{client: server.Client(), scheme: "http"}
+	config, err := inspector.Inspect(context.Background(), host+"/octocat/hello-world@sha256:b2", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(config.Entrypoint, []string{"/bin/hello"}); diff != "" {
+		t.Errorf("Unexpected image entrypoint")
+		t.Log(diff)
+	}
+}
+
+func TestRegistryPlatforms(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/octocat/hello-world/manifests/1.0":
+			w.Write([]byte(`{"manifests":[
+				{"digest":"sha256:a1","platform":{"os":"linux","architecture":"arm","variant":"v7"}},
+				{"digest":"sha256:b2","platform":{"os":"linux","architecture":"amd64"}}]}`))
+		case "/v2/octocat/hello-world/manifests/2.0":
+			w.Write([]byte(`{"config":{"digest":"sha256:c3"}}`))
+		case "/v2/octocat/hello-world/blobs/sha256:c3":
+			w.Write([]byte(`{"os":"linux","architecture":"arm64"}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	resolver := &registryResolver{client: server.Client(), scheme: "http"}
+	platforms, err := resolver.Platforms(context.Background(), host+"/octocat/hello-world:1.0", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := []Platform{
+		{OS: "linux", Arch: "arm", Variant: "v7"},
+		{OS: "linux", Arch: "amd64"},
+	}
+	if diff := cmp.Diff(platforms, want); diff != "" {
+		t.Errorf("Unexpected manifest list platforms")
+		t.Log(diff)
+	}
+
+	platforms, err = resolver.Platforms(context.Background(), host+"/octocat/hello-world:2.0", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want = []Platform{{OS: "linux", Arch: "arm64"}}
+	if diff := cmp.Diff(platforms, want); diff != "" {
+		t.Errorf("Unexpected image configuration platform")
+		t.Log(diff)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// SecretResolver resolves secrets at runtime, for example
+// from an external secret store such as Vault.
+type SecretResolver interface {
+	// Resolve returns the named secret. A nil secret
+	// is returned if the secret does not exist. The
+	// returned secret must include a unique identifier
+	// in its metadata.
+	Resolve(ctx context.Context, spec *Spec, name string) (*Secret, error)
+}
+
+// ResolveSecrets resolves all secrets referenced by the
+// pipeline steps that are not defined inline in the spec,
+// and appends them to the spec. Inline secrets always take
+// precedence, and are never passed to the resolver.
+func ResolveSecrets(ctx context.Context, spec *Spec, resolver SecretResolver) error {
+	for _, name := range secretRefs(spec) {
+		if _, ok := LookupSecret(spec, name); ok {
+			continue
+		}
+		secret, err := resolver.Resolve(ctx, spec, name)
+		if err != nil {
+			return fmt.Errorf("engine: cannot resolve secret %s: %s", name, err)
+		}
+		if secret == nil {
+			continue
+		}
+		secret.Metadata.Name = name
+		spec.Secrets = append(spec.Secrets, secret)
+	}
+	return nil
+}
+
+// helper function returns the names of all secrets
+// referenced by the pipeline steps, including secrets
+// referenced by secret volumes.
+func secretRefs(spec *Spec) []string {
+	var names []string
+	for _, step := range spec.Steps {
+		for _, secret := range step.Secrets {
+			names = append(names, secret.Name)
+		}
+		if step.Docker != nil {
+			for _, file := range step.Docker.SecretFiles {
+				names = append(names, file.Name)
+			}
+		}
+		for _, mount := range step.Volumes {
+			vol, ok := LookupVolume(spec, mount.Name)
+			if !ok || vol.Secret == nil {
+				continue
+			}
+			for _, item := range vol.Secret.Items {
+				names = append(names, fmt.Sprintf("%s-%s-%s", vol.Metadata.Name, vol.Secret.Name, item.Key))
+			}
+		}
+	}
+	return names
+}
@@ -4,6 +4,8 @@
 
 package engine
 
+import "time"
+
 type (
 	// Metadata provides execution metadata.
 	Metadata struct {
@@ -23,6 +25,24 @@ type (
 		Steps    []*Step   `json:"steps,omitempty"`
 		Files    []*File   `json:"files,omitempty"`
 
+		// Resources defines the resource policy applied to
+		// all pipeline steps.
+		Resources *ResourcePolicy `json:"resources,omitempty"`
+
+		// Workspace defines the volume shared by all
+		// pipeline steps as the workspace.
+		Workspace *Workspace `json:"workspace,omitempty"`
+
+		// ShellFlags defines the flags passed to the shell
+		// that executes the step commands, for example
+		// -euo pipefail. The flags default to -e.
+		ShellFlags []string `json:"shell_flags,omitempty"`
+
+		// LogLimitBytes defines the maximum size of the log
+		// of each step. The log is truncated once the limit
+		// is exceeded. The log is not limited if zero.
+		LogLimitBytes int64 `json:"log_limit_bytes,omitempty"`
+
 		// Docker-specific settings. These settings are
 		// only used by the Docker and Kubernetes runtime
 		// drivers.
@@ -45,12 +65,14 @@ type (
 		Devices      []*VolumeDevice   `json:"devices,omitempty"`
 		Envs         map[string]string `json:"environment,omitempty"`
 		Files        []*FileMount      `json:"files,omitempty"`
+		HostAliases  []*HostAlias      `json:"host_aliases,omitempty"`
 		IgnoreErr    bool              `json:"ignore_err,omitempty"`
 		IgnoreStdout bool              `json:"ignore_stderr,omitempty"`
 		IgnoreStderr bool              `json:"ignore_stdout,omitempty"`
 		Resources    *Resources        `json:"resources,omitempty"`
 		RunPolicy    RunPolicy         `json:"run_policy,omitempty"`
 		Secrets      []*SecretVar      `json:"secrets,omitempty"`
+		Timezone     string            `json:"timezone,omitempty"`
 		Volumes      []*VolumeMount    `json:"volumes,omitempty"`
 		WorkingDir   string            `json:"working_dir,omitempty"`
 
@@ -60,6 +82,17 @@ type (
 		Docker *DockerStep `json:"docker,omitempty"`
 	}
 
+	// Condition describes a condition of the step
+	// container, such as whether it is scheduled or ready,
+	// and the time of the last transition.
+	Condition struct {
+		Type    string    // Condition type
+		Status  string    // Condition status (True, False, Unknown)
+		Time    time.Time // Last transition time
+		Reason  string    // Machine-readable reason
+		Message string    // Human-readable message
+	}
+
 	// DockerAuth defines dockerhub authentication credentials.
 	DockerAuth struct {
 		Address  string `json:"address,omitempty"`
@@ -69,31 +102,109 @@ type (
 
 	// DockerConfig configures a Docker-based pipeline.
 	DockerConfig struct {
-		Auths   []*DockerAuth `json:"auths,omitempty"`
-		Volumes []*Volume     `json:"volumes,omitempty"`
+		Auths     []*DockerAuth `json:"auths,omitempty"`
+		LogConfig *LogConfig    `json:"log_config,omitempty"`
+		Mirrors   []*Mirror     `json:"mirrors,omitempty"`
+		Volumes   []*Volume     `json:"volumes,omitempty"`
+
+		// PodSpecPatch is a json merge patch applied to the
+		// pod spec of each step, which provides access to pod
+		// fields that are not otherwise exposed. The patch is
+		// only applied to trusted pipelines. Kubernetes only.
+		PodSpecPatch string `json:"pod_spec_patch,omitempty"`
 	}
 
-	// DockerStep configures a docker step.
+	// DockerStep configures a docker step. Fields documented
+	// as Kubernetes only are ignored by the Docker runtime
+	// driver.
 	DockerStep struct {
-		Args       []string   `json:"args,omitempty"`
-		Command    []string   `json:"command,omitempty"`
-		DNS        []string   `json:"dns,omitempty"`
-		DNSSearch  []string   `json:"dns_search,omitempty"`
-		ExtraHosts []string   `json:"extra_hosts,omitempty"`
-		Image      string     `json:"image,omitempty"`
-		Networks   []string   `json:"networks,omitempty"`
-		Ports      []*Port    `json:"ports,omitempty"`
-		Privileged bool       `json:"privileged,omitempty"`
-		PullPolicy PullPolicy `json:"pull_policy,omitempty"`
-		User       string     `json:"user"`
+		// AppArmorProfile confines the step containers to the
+		// named profile, either runtime/default, unconfined or
+		// localhost/<profile>. Kubernetes only.
+		AppArmorProfile string   `json:"apparmor_profile,omitempty"`
+		Args            []string `json:"args,omitempty"`
+
+		// BackoffLimit executes the step as a job, which
+		// retries the failed step up to the limit. Kubernetes
+		// only.
+		BackoffLimit int      `json:"backoff_limit,omitempty"`
+		Command      []string `json:"command,omitempty"`
+
+		// Commands are executed as a shell script in place of
+		// the command and args. Kubernetes only.
+		Commands  []string `json:"commands,omitempty"`
+		DNS       []string `json:"dns,omitempty"`
+		DNSSearch []string `json:"dns_search,omitempty"`
+
+		// DockerSock mounts the host Docker socket read-only,
+		// which requires a trusted pipeline. Kubernetes only.
+		DockerSock  bool         `json:"docker_sock,omitempty"`
+		ExtraHosts  []string     `json:"extra_hosts,omitempty"`
+		Healthcheck *Healthcheck `json:"healthcheck,omitempty"`
+		Image       string       `json:"image,omitempty"`
+
+		// LocalSSD schedules the step on a node with local
+		// solid state storage, which is mounted as scratch
+		// space. Kubernetes only.
+		LocalSSD bool `json:"local_ssd,omitempty"`
+
+		// LogFile streams the step logs from the file using a
+		// sidecar, instead of the container output. Kubernetes
+		// only.
+		LogFile string `json:"log_file,omitempty"`
+
+		// LongRunning prevents node autoscalers from evicting
+		// the step pod. Kubernetes only.
+		LongRunning bool          `json:"long_running,omitempty"`
+		Metrics     *Metrics      `json:"metrics,omitempty"`
+		Networks    []string      `json:"networks,omitempty"`
+		Platform    string        `json:"platform,omitempty"`
+		Ports       []*Port       `json:"ports,omitempty"`
+		Privileged  bool          `json:"privileged,omitempty"`
+		PullPolicy  PullPolicy    `json:"pull_policy,omitempty"`
+		Restart     RestartPolicy `json:"restart_policy,omitempty"`
+
+		// SecretFiles mounts secrets as files at the exact
+		// paths. Kubernetes only.
+		SecretFiles []*SecretFile  `json:"secret_files,omitempty"`
+		Service     *ServiceConfig `json:"service,omitempty"`
+		Stdin       bool           `json:"stdin,omitempty"`
+
+		// StopSignal and StopTimeout configure how the step
+		// container is stopped when the pipeline is destroyed.
+		StopSignal  string        `json:"stop_signal,omitempty"`
+		StopTimeout time.Duration `json:"stop_timeout,omitempty"`
+
+		// SupplementalGroups adds the group ids to the step
+		// processes. Kubernetes only.
+		SupplementalGroups []int64 `json:"supplemental_groups,omitempty"`
+
+		// TTY allocates a terminal for the step, which
+		// implies Stdin on both runtime drivers.
+		TTY     bool      `json:"tty,omitempty"`
+		Ulimits []*Ulimit `json:"ulimits,omitempty"`
+		User    string    `json:"user"`
 	}
 
 	// File defines a file that should be uploaded or
 	// mounted somewhere in the step container or virtual
 	// machine prior to command execution.
+	//
+	// Compress stores the file gzip-compressed, which is
+	// used by the Kubernetes runtime to fit large files
+	// within the config map size limit. The file is
+	// decompressed before the step starts.
+	//
+	// Secret stores the file in a secret instead of a
+	// config map, which is used by the Kubernetes runtime
+	// for sensitive files. The secret is only mounted by
+	// an init container, which copies the file to the
+	// ephemeral storage of the pod.
 	File struct {
 		Metadata Metadata `json:"metadata,omitempty"`
 		Data     []byte   `json:"data,omitempty"`
+		Compress bool     `json:"compress,omitempty"`
+		Secret   bool     `json:"secret,omitempty"`
 	}
 
 	// FileMount defines how a file resource should be
@@ -111,6 +222,49 @@ type (
 		Image string `json:"image,omitempty"`
 	}
 
+	// HostAlias defines an /etc/hosts entry that resolves
+	// the hostnames to the ip address.
+	HostAlias struct {
+		IP        string   `json:"ip,omitempty"`
+		Hostnames []string `json:"hostnames,omitempty"`
+	}
+
+	// LogConfig configures the container logging driver.
+	// Note that log streaming requires a driver that
+	// supports reading logs, such as json-file or local.
+	LogConfig struct {
+		Type    string            `json:"type,omitempty"`
+		Options map[string]string `json:"options,omitempty"`
+	}
+
+	// Healthcheck defines a container healthcheck. The
+	// engine waits for the container to report healthy
+	// before the step is considered started. The Kubernetes
+	// runtime driver uses the healthcheck as the readiness
+	// probe, with durations rounded up to the second.
+	Healthcheck struct {
+		Test        []string      `json:"test,omitempty"`
+		Interval    time.Duration `json:"interval,omitempty"`
+		Timeout     time.Duration `json:"timeout,omitempty"`
+		StartPeriod time.Duration `json:"start_period,omitempty"`
+		Retries     int           `json:"retries,omitempty"`
+	}
+
+	// Metrics defines the metrics endpoint of a service
+	// container, which is scraped by Prometheus.
+	Metrics struct {
+		Port int    `json:"port,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
+	// Mirror defines a registry mirror. Images matching
+	// the prefix, for example docker.io, are pulled from
+	// the mirror, for example mirror.internal.
+	Mirror struct {
+		Prefix string `json:"prefix,omitempty"`
+		Mirror string `json:"mirror,omitempty"`
+	}
+
 	// Platform defines the target platform.
 	Platform struct {
 		OS      string `json:"os,omitempty"`
@@ -141,6 +295,32 @@ type (
 		// Requests describes the minimum amount of
 		// compute resources required.
 		Requests *ResourceObject `json:"requests,omitempty"`
+
+		// QoSClass describes the desired quality of
+		// service class. Guaranteed sets the requests
+		// equal to the limits, and best effort omits both.
+		QoSClass QoSClass `json:"qos_class,omitempty"`
+
+		// Extended describes the quantity of extended
+		// resources required, keyed by the domain-prefixed
+		// resource name, such as example.com/fpga. Extended
+		// resources cannot be overcommitted, and are only
+		// supported by the Kubernetes runtime driver.
+		Extended map[string]int64 `json:"extended,omitempty"`
+	}
+
+	// ResourcePolicy describes the compute resource
+	// policy applied to all pipeline steps.
+	ResourcePolicy struct {
+		// DefaultRequests describes the minimum amount of
+		// compute resources required by steps that do not
+		// define their own requests.
+		DefaultRequests *ResourceObject `json:"default_requests,omitempty"`
+
+		// MaxLimits describes the maximum amount of
+		// compute resources a step may request or be
+		// limited to. Larger values are clamped.
+		MaxLimits *ResourceObject `json:"max_limits,omitempty"`
 	}
 
 	// ResourceObject describes compute resource
@@ -163,26 +343,72 @@ type (
 		Env  string `json:"env,omitempty"`
 	}
 
+	// SecretFile represents a file sourced from a secret,
+	// which is mounted read-only at the path with the file
+	// mode. The mode defaults to 0644.
+	SecretFile struct {
+		Name string `json:"name,omitempty"`
+		Path string `json:"path,omitempty"`
+		Mode *int32 `json:"mode,omitempty"`
+	}
+
+	// ServiceConfig configures the service created for a
+	// step that exposes ports. It is only supported by the
+	// Kubernetes runtime driver.
+	//
+	// ClientIPAffinity routes the requests of a client to
+	// the same backend, for up to AffinityTimeout seconds.
+	// The Kubernetes default timeout is used if zero.
+	//
+	// ExternalName resolves the step service to an external
+	// host, for dependencies that run outside the cluster.
+	// No pod is created for the step.
+	ServiceConfig struct {
+		ClientIPAffinity bool   `json:"client_ip_affinity,omitempty"`
+		AffinityTimeout  int    `json:"affinity_timeout,omitempty"`
+		ExternalName     string `json:"external_name,omitempty"`
+	}
+
 	// State represents the container state.
 	State struct {
-		ExitCode  int  // Container exit code
-		Exited    bool // Container exited
-		OOMKilled bool // Container is oom killed
+		ExitCode   int          // Container exit code
+		Exited     bool         // Container exited
+		OOMKilled  bool         // Container is oom killed
+		Succeeded  bool         // Non-zero exit code is configured as successful
+		Conditions []*Condition // Pod conditions, if supported
+		IP         string       // Pod ip address, if supported
+		Node       string       // Pod node name, if supported
+	}
+
+	// Ulimit defines a process resource limit, such as
+	// the maximum number of open files (nofile).
+	Ulimit struct {
+		Name string `json:"name,omitempty"`
+		Soft int64  `json:"soft,omitempty"`
+		Hard int64  `json:"hard,omitempty"`
 	}
 
 	// Volume that can be mounted by containers.
+	//
+	// SharedWith restricts the volume to the steps with
+	// the listed uids, which prevents other steps from
+	// mounting the volume. The volume is shared with all
+	// steps if the list is empty.
 	Volume struct {
-		Metadata Metadata        `json:"metadata,omitempty"`
-		EmptyDir *VolumeEmptyDir `json:"temp,omitempty"`
-		HostPath *VolumeHostPath `json:"host,omitempty"`
-		Secret   *VolumeSecret   `json:"secret,omitempty"`
+		Metadata   Metadata        `json:"metadata,omitempty"`
+		EmptyDir   *VolumeEmptyDir `json:"temp,omitempty"`
+		HostPath   *VolumeHostPath `json:"host,omitempty"`
+		Secret     *VolumeSecret   `json:"secret,omitempty"`
+		SharedWith []string        `json:"shared_with,omitempty"`
 	}
 
 	// VolumeDevice describes a mapping of a raw block
-	// device within a container.
+	// device within a container. The cgroup permissions
+	// default to rwm if empty.
 	VolumeDevice struct {
-		Name       string `json:"name,omitempty"`
-		DevicePath string `json:"path,omitempty"`
+		Name              string `json:"name,omitempty"`
+		DevicePath        string `json:"path,omitempty"`
+		CgroupPermissions string `json:"cgroup_permissions,omitempty"`
 	}
 
 	// VolumeMount describes a mounting of a Volume
@@ -192,6 +418,14 @@ type (
 		Path string `json:"path,omitempty"`
 	}
 
+	// Workspace defines the pipeline workspace volume and
+	// the path at which it is mounted in every step. The
+	// path defaults to /drone/src.
+	Workspace struct {
+		Volume string `json:"volume,omitempty"`
+		Path   string `json:"path,omitempty"`
+	}
+
 	// VolumeEmptyDir mounts a temporary directory from the
 	// host node's filesystem into the container. This can
 	// be used as a shared scratch space.
@@ -211,6 +445,10 @@ type (
 	VolumeSecret struct {
 		Name  string       `json:"name,omitempty"`
 		Items []*KeyToPath `json:"items,omitempty"`
+
+		// DefaultMode sets the file mode of items that do
+		// not define a mode. Private keys should use 0400.
+		DefaultMode *int32 `json:"default_mode,omitempty"`
 	}
 
 	// KeyToPath represents a key-path pair to be used in VolumeSecret,
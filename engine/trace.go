@@ -0,0 +1,99 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+)
+
+// Tracer is invoked when an engine operation starts, with
+// the operation name and attributes identifying the
+// pipeline and step. It returns the context used for the
+// operation, and a function that is invoked with the
+// operation error when the operation ends. A Tracer can be
+// used to create tracing spans, for example using
+// OpenTelemetry.
+type Tracer func(ctx context.Context, operation string, attrs map[string]string) (context.Context, func(error))
+
+// Trace returns an Engine that invokes the tracer for each
+// operation of the wrapped engine.
+func Trace(engine Engine, tracer Tracer) Engine {
+	return &traceEngine{engine: engine, tracer: tracer}
+}
+
+type traceEngine struct {
+	engine Engine
+	tracer Tracer
+}
+
+// Unwrap returns the wrapped engine.
+func (e *traceEngine) Unwrap() Engine {
+	return e.engine
+}
+
+// Unwrap returns the underlying engine of an engine that
+// wraps another engine, such as the engine returned by
+// Trace, so that driver-specific functions can be used with
+// the wrapped engine. The engine is returned unchanged if it
+// does not wrap another engine.
+func Unwrap(engine Engine) Engine {
+	for {
+		wrapper, ok := engine.(interface{ Unwrap() Engine })
+		if !ok {
+			return engine
+		}
+		engine = wrapper.Unwrap()
+	}
+}
+
+func (e *traceEngine) Setup(ctx context.Context, spec *Spec) (err error) {
+	ctx, end := e.tracer(ctx, "Setup", traceAttrs(spec, nil))
+	defer func() { end(err) }()
+	return e.engine.Setup(ctx, spec)
+}
+
+func (e *traceEngine) Create(ctx context.Context, spec *Spec, step *Step) (err error) {
+	ctx, end := e.tracer(ctx, "Create", traceAttrs(spec, step))
+	defer func() { end(err) }()
+	return e.engine.Create(ctx, spec, step)
+}
+
+func (e *traceEngine) Start(ctx context.Context, spec *Spec, step *Step) (err error) {
+	ctx, end := e.tracer(ctx, "Start", traceAttrs(spec, step))
+	defer func() { end(err) }()
+	return e.engine.Start(ctx, spec, step)
+}
+
+func (e *traceEngine) Wait(ctx context.Context, spec *Spec, step *Step) (state *State, err error) {
+	ctx, end := e.tracer(ctx, "Wait", traceAttrs(spec, step))
+	defer func() { end(err) }()
+	return e.engine.Wait(ctx, spec, step)
+}
+
+func (e *traceEngine) Tail(ctx context.Context, spec *Spec, step *Step) (rc io.ReadCloser, err error) {
+	ctx, end := e.tracer(ctx, "Tail", traceAttrs(spec, step))
+	defer func() { end(err) }()
+	return e.engine.Tail(ctx, spec, step)
+}
+
+func (e *traceEngine) Destroy(ctx context.Context, spec *Spec) (err error) {
+	ctx, end := e.tracer(ctx, "Destroy", traceAttrs(spec, nil))
+	defer func() { end(err) }()
+	return e.engine.Destroy(ctx, spec)
+}
+
+// helper function returns the trace attributes for the
+// pipeline and step.
+func traceAttrs(spec *Spec, step *Step) map[string]string {
+	attrs := map[string]string{
+		"pipeline.uid": spec.Metadata.UID,
+	}
+	if step != nil {
+		attrs["step.uid"] = step.Metadata.UID
+		attrs["step.name"] = step.Metadata.Name
+	}
+	return attrs
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTrace(t *testing.T) {
+	var spans []string
+	tracer := func(ctx context.Context, op string, attrs map[string]string) (context.Context, func(error)) {
+		spans = append(spans, "start "+op+" "+attrs["step.uid"])
+		return ctx, func(err error) {
+			if err != nil {
+				spans = append(spans, "end "+op+" "+err.Error())
+			} else {
+				spans = append(spans, "end "+op)
+			}
+		}
+	}
+
+	spec := &Spec{Metadata: Metadata{UID: "uid_pipeline"}}
+	step := &Step{Metadata: Metadata{UID: "uid_step", Name: "build"}}
+	engine := Trace(&traceStub{}, tracer)
+
+	ctx := context.Background()
+	engine.Setup(ctx, spec)
+	engine.Create(ctx, spec, step)
+	engine.Start(ctx, spec, step)
+	engine.Tail(ctx, spec, step)
+	engine.Wait(ctx, spec, step)
+	engine.Destroy(ctx, spec)
+
+	want := []string{
+		"start Setup ",
+		"end Setup",
+		"start Create uid_step",
+		"end Create",
+		"start Start uid_step",
+		"end Start",
+		"start Tail uid_step",
+		"end Tail",
+		"start Wait uid_step",
+		"end Wait oops",
+		"start Destroy ",
+		"end Destroy",
+	}
+	if diff := cmp.Diff(spans, want); diff != "" {
+		t.Errorf("Unexpected spans")
+		t.Log(diff)
+	}
+}
+
+// traceStub is an Engine that succeeds every operation,
+// except Wait, for testing purposes.
+type traceStub struct{}
+
+func (*traceStub) Setup(context.Context, *Spec) error         { return nil }
+func (*traceStub) Create(context.Context, *Spec, *Step) error { return nil }
+func (*traceStub) Start(context.Context, *Spec, *Step) error  { return nil }
+func (*traceStub) Destroy(context.Context, *Spec) error       { return nil }
+
+func (*traceStub) Wait(context.Context, *Spec, *Step) (*State, error) {
+	return nil, errors.New("oops")
+}
+
+func (*traceStub) Tail(context.Context, *Spec, *Step) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+func TestUnwrap(t *testing.T) {
+	stub := &traceStub{}
+	noop := func(ctx context.Context, op string, attrs map[string]string) (context.Context, func(error)) {
+		return ctx, func(error) {}
+	}
+	if got := Unwrap(Trace(Trace(stub, noop), noop)); got != stub {
+		t.Errorf("Want the traced engine unwrapped, got %T", got)
+	}
+	if got := Unwrap(stub); got != stub {
+		t.Errorf("Want an unwrapped engine returned unchanged, got %T", got)
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dnsLabel matches a valid RFC 1123 dns label, which is
+// required for kubernetes object names.
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// uidPattern matches a valid object identifier, which is
+// used to name the containers, volumes and kubernetes
+// objects created by the runtime drivers.
+var uidPattern = regexp.MustCompile(`^[a-zA-Z0-9][-_.a-zA-Z0-9]*$`)
+
+// shellFlag matches one or more single letter shell flags,
+// where the o flag must be last, since it is followed by
+// the option name.
+var shellFlag = regexp.MustCompile(`^[-+][aCefnuvx]*o?$`)
+
+// shellOptions defines the shell option names accepted by
+// the o flag.
+var shellOptions = map[string]bool{
+	"allexport": true,
+	"errexit":   true,
+	"noclobber": true,
+	"noglob":    true,
+	"nounset":   true,
+	"pipefail":  true,
+	"verbose":   true,
+	"xtrace":    true,
+}
+
+// ValidationError reports one or more inconsistencies
+// found in the pipeline specification.
+type ValidationError struct {
+	Errors []string
+}
+
+// Error returns the error message in string format.
+func (e *ValidationError) Error() string {
+	return "engine: invalid specification: " + strings.Join(e.Errors, "; ")
+}
+
+// Validate checks the pipeline specification for internal
+// consistency. It verifies that all volume, secret and file
+// references resolve, that mount paths are absolute, and
+// that object identifiers are unique and contain only
+// characters that are safe in object names. All errors are
+// aggregated and returned as a ValidationError.
+func Validate(spec *Spec) error {
+	v := &validator{spec: spec, uids: map[string]bool{}}
+
+	for _, secret := range spec.Secrets {
+		v.checkUID("secret", secret.Metadata)
+	}
+	for _, file := range spec.Files {
+		v.checkUID("file", file.Metadata)
+	}
+	if spec.Docker != nil {
+		for _, vol := range spec.Docker.Volumes {
+			v.checkUID("volume", vol.Metadata)
+			for _, uid := range vol.SharedWith {
+				if !hasStep(spec, uid) {
+					v.errorf("volume %s: unknown step %s", vol.Metadata.Name, uid)
+				}
+			}
+		}
+		if spec.Docker.PodSpecPatch != "" {
+			v.checkPatch(spec.Docker.PodSpecPatch)
+		}
+	}
+
+	if len(spec.ShellFlags) != 0 {
+		v.checkShellFlags(spec.ShellFlags)
+	}
+
+	if spec.Workspace != nil {
+		if _, ok := LookupVolume(spec, spec.Workspace.Volume); !ok {
+			v.errorf("unknown workspace volume %s", spec.Workspace.Volume)
+		}
+		if p := spec.Workspace.Path; p != "" && spec.Platform.OS != "windows" && !path.IsAbs(p) {
+			v.errorf("workspace path %q is not absolute", p)
+		}
+	}
+
+	for _, step := range spec.Steps {
+		name := step.Metadata.Name
+		v.checkUID("step", step.Metadata)
+
+		for _, mount := range step.Volumes {
+			if vol, ok := LookupVolume(spec, mount.Name); !ok {
+				v.errorf("step %s: unknown volume %s", name, mount.Name)
+			} else if !IsSharedWith(vol, step) {
+				v.errorf("step %s: volume %s is not shared with the step", name, mount.Name)
+			}
+			v.checkPath(name, mount.Path)
+		}
+		for _, device := range step.Devices {
+			if vol, ok := LookupVolume(spec, device.Name); !ok {
+				v.errorf("step %s: unknown device %s", name, device.Name)
+			} else if !IsSharedWith(vol, step) {
+				v.errorf("step %s: device %s is not shared with the step", name, device.Name)
+			}
+			v.checkPath(name, device.DevicePath)
+		}
+		for _, secret := range step.Secrets {
+			if _, ok := LookupSecret(spec, secret.Name); !ok {
+				v.errorf("step %s: unknown secret %s", name, secret.Name)
+			}
+		}
+		for _, mount := range step.Files {
+			if _, ok := LookupFile(spec, mount.Name); !ok {
+				v.errorf("step %s: unknown file %s", name, mount.Name)
+			}
+			v.checkPath(name, mount.Path)
+		}
+		if step.WorkingDir != "" {
+			v.checkPath(name, step.WorkingDir)
+		}
+		if step.Resources != nil {
+			v.checkQoS(name, step.Resources)
+			v.checkExtended(name, step.Resources.Extended)
+		}
+		if step.Docker != nil && step.Docker.AppArmorProfile != "" {
+			v.checkAppArmor(name, step.Docker.AppArmorProfile)
+		}
+		if step.Docker != nil {
+			for _, port := range step.Docker.Ports {
+				v.checkPort(name, port)
+			}
+			if step.Docker.LogFile != "" {
+				v.checkPath(name, step.Docker.LogFile)
+			}
+			for _, gid := range step.Docker.SupplementalGroups {
+				if gid < 0 {
+					v.errorf("step %s: invalid supplemental group %d", name, gid)
+				}
+			}
+			for _, file := range step.Docker.SecretFiles {
+				if _, ok := LookupSecret(spec, file.Name); !ok {
+					v.errorf("step %s: unknown secret %s", name, file.Name)
+				}
+				v.checkPath(name, file.Path)
+				if file.Mode != nil && (*file.Mode < 0 || *file.Mode > 0777) {
+					v.errorf("step %s: invalid file mode %#o", name, *file.Mode)
+				}
+			}
+		}
+		if step.Docker != nil && step.Docker.Service != nil {
+			v.checkService(name, step.Docker.Service)
+		}
+		v.checkEnv(step)
+	}
+
+	if len(v.errors) != 0 {
+		return &ValidationError{Errors: v.errors}
+	}
+	return nil
+}
+
+// maxEnvSize defines the maximum total size of the step
+// environment, in bytes. Larger environments exceed the
+// argument size limits of the kernel, and are rejected by
+// the container runtime.
+const maxEnvSize = 128 * 1024
+
+// validator accumulates validation errors.
+type validator struct {
+	spec   *Spec
+	uids   map[string]bool
+	errors []string
+}
+
+func (v *validator) errorf(format string, args ...interface{}) {
+	v.errors = append(v.errors, fmt.Sprintf(format, args...))
+}
+
+// helper function verifies the object identifier is
+// present, unique, and safe to use in object names.
+func (v *validator) checkUID(kind string, meta Metadata) {
+	switch {
+	case meta.UID == "":
+		v.errorf("%s %s: missing uid", kind, meta.Name)
+	case len(meta.UID) > 63 || !uidPattern.MatchString(meta.UID):
+		v.errorf("%s %s: uid %s contains invalid characters", kind, meta.Name, meta.UID)
+	case v.uids[meta.UID]:
+		v.errorf("%s %s: duplicate uid %s", kind, meta.Name, meta.UID)
+	}
+	v.uids[meta.UID] = true
+}
+
+// helper function verifies the path is absolute. Paths
+// are not verified for windows pipelines.
+func (v *validator) checkPath(step, p string) {
+	if v.spec.Platform.OS == "windows" {
+		return
+	}
+	if !path.IsAbs(p) {
+		v.errorf("step %s: path %q is not absolute", step, p)
+	}
+}
+
+// helper function verifies the quality of service class
+// can be satisfied by the resource requirements.
+func (v *validator) checkQoS(step string, resources *Resources) {
+	var limits, requests ResourceObject
+	if resources.Limits != nil {
+		limits = *resources.Limits
+	}
+	if resources.Requests != nil {
+		requests = *resources.Requests
+	}
+	switch resources.QoSClass {
+	case QoSGuaranteed:
+		if limits.CPU <= 0 || limits.Memory <= 0 {
+			v.errorf("step %s: guaranteed qos class requires cpu and memory limits", step)
+		}
+	case QoSBurstable:
+		if limits == (ResourceObject{}) && requests == (ResourceObject{}) {
+			v.errorf("step %s: burstable qos class requires resource requests or limits", step)
+		}
+	case QoSBestEffort:
+		if limits != (ResourceObject{}) || requests != (ResourceObject{}) {
+			v.errorf("step %s: best-effort qos class does not permit resource requests or limits", step)
+		}
+	}
+}
+
+// helper function verifies the pod spec patch is a json
+// object. The patched pod spec is verified by the runtime
+// driver when the patch is applied.
+func (v *validator) checkPatch(patch string) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(patch), &obj); err != nil {
+		v.errorf("invalid pod spec patch: %s", err)
+	}
+}
+
+// helper function verifies the extended resources are
+// domain-prefixed and request a positive quantity.
+func (v *validator) checkExtended(name string, extended map[string]int64) {
+	var names []string
+	for resource := range extended {
+		names = append(names, resource)
+	}
+	sort.Strings(names)
+	for _, resource := range names {
+		parts := strings.SplitN(resource, "/", 2)
+		switch {
+		case len(parts) != 2 || parts[1] == "" || !isDNSName(parts[0]):
+			v.errorf("step %s: extended resource %s must be prefixed with a domain", name, resource)
+		case strings.HasSuffix(parts[0], "kubernetes.io"):
+			v.errorf("step %s: extended resource %s uses a reserved domain", name, resource)
+		case extended[resource] <= 0:
+			v.errorf("step %s: extended resource %s requires a positive quantity", name, resource)
+		}
+	}
+}
+
+// helper function verifies the apparmor profile is the
+// runtime default, unconfined, or a named localhost profile.
+func (v *validator) checkAppArmor(name, profile string) {
+	switch {
+	case profile == "runtime/default", profile == "unconfined":
+	case strings.HasPrefix(profile, "localhost/") && len(profile) > len("localhost/"):
+	default:
+		v.errorf("step %s: invalid apparmor profile %s", name, profile)
+	}
+}
+
+// helper function verifies the container and host ports
+// are within the valid port range. The host port is
+// optional.
+func (v *validator) checkPort(name string, port *Port) {
+	if port.Port < 1 || port.Port > 65535 {
+		v.errorf("step %s: port %d is out of range", name, port.Port)
+	}
+	if port.Host < 0 || port.Host > 65535 {
+		v.errorf("step %s: host port %d is out of range", name, port.Host)
+	}
+}
+
+// maxAffinityTimeout defines the maximum session affinity
+// timeout, in seconds, accepted by Kubernetes.
+const maxAffinityTimeout = 86400
+
+// helper function verifies the step service configuration.
+func (v *validator) checkService(name string, service *ServiceConfig) {
+	if service.AffinityTimeout < 0 || service.AffinityTimeout > maxAffinityTimeout {
+		v.errorf("step %s: session affinity timeout must be between 0 and %d seconds", name, maxAffinityTimeout)
+	}
+	if service.ExternalName != "" && !isDNSName(service.ExternalName) {
+		v.errorf("step %s: external name %s is not a valid dns name", name, service.ExternalName)
+	}
+}
+
+// helper function returns true if the name is a valid
+// RFC 1123 dns subdomain, such as db.example.com.
+func isDNSName(name string) bool {
+	if len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > 63 || !dnsLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function verifies the shell flags are recognized.
+// The o flag is followed by the option name, for example
+// -euo pipefail.
+func (v *validator) checkShellFlags(flags []string) {
+	for i := 0; i < len(flags); i++ {
+		flag := flags[i]
+		if len(flag) < 2 || !shellFlag.MatchString(flag) {
+			v.errorf("unrecognized shell flag %q", flag)
+			continue
+		}
+		if !strings.HasSuffix(flag, "o") {
+			continue
+		}
+		if i+1 == len(flags) {
+			v.errorf("shell flag %q requires an option name", flag)
+			continue
+		}
+		i++
+		if !shellOptions[flags[i]] {
+			v.errorf("unrecognized shell option %q", flags[i])
+		}
+	}
+}
+
+// helper function returns true if the spec defines a step
+// with the uid.
+func hasStep(spec *Spec, uid string) bool {
+	for _, step := range spec.Steps {
+		if step.Metadata.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function verifies the total size of the step
+// environment variables, including secrets, does not
+// exceed the limit. The error names the largest variables.
+func (v *validator) checkEnv(step *Step) {
+	type env struct {
+		name string
+		size int
+	}
+	var envs []env
+	var total int
+	for name, value := range step.Envs {
+		envs = append(envs, env{name, len(name) + len(value)})
+	}
+	for _, secret := range step.Secrets {
+		if sec, ok := LookupSecret(v.spec, secret.Name); ok {
+			envs = append(envs, env{secret.Env, len(secret.Env) + len(sec.Data)})
+		}
+	}
+	for _, e := range envs {
+		total += e.size
+	}
+	if total <= maxEnvSize {
+		return
+	}
+	sort.Slice(envs, func(i, j int) bool {
+		if envs[i].size == envs[j].size {
+			return envs[i].name < envs[j].name
+		}
+		return envs[i].size > envs[j].size
+	})
+	if len(envs) > 3 {
+		envs = envs[:3]
+	}
+	var largest []string
+	for _, e := range envs {
+		largest = append(largest, fmt.Sprintf("%s (%d bytes)", e.name, e.size))
+	}
+	v.errorf("step %s: environment size of %d bytes exceeds the %d byte limit, largest variables are %s; consider mounting large values as files",
+		step.Metadata.Name, total, maxEnvSize, strings.Join(largest, ", "))
+}
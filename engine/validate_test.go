@@ -0,0 +1,320 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Validate(testValidSpec()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidate_UnknownVolume(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Volumes[0].Name = "cache"
+	testValidateError(t, spec, "unknown volume cache")
+}
+
+func TestValidate_VolumeNotShared(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps = append(spec.Steps, &Step{
+		Metadata: Metadata{UID: "a5kzey3w7ip29i8gkbt6ksreb5z2ybkp", Name: "test"},
+	})
+	spec.Docker.Volumes[0].SharedWith = []string{"a5kzey3w7ip29i8gkbt6ksreb5z2ybkp"}
+	testValidateError(t, spec, "step build: volume workspace is not shared with the step")
+}
+
+func TestValidate_DeviceNotShared(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps = append(spec.Steps, &Step{
+		Metadata: Metadata{UID: "a5kzey3w7ip29i8gkbt6ksreb5z2ybkp", Name: "test"},
+	})
+	spec.Steps[0].Volumes = nil
+	spec.Steps[0].Devices = []*VolumeDevice{
+		{Name: "workspace", DevicePath: "/dev/kvm"},
+	}
+	spec.Docker.Volumes[0].SharedWith = []string{"a5kzey3w7ip29i8gkbt6ksreb5z2ybkp"}
+	testValidateError(t, spec, "step build: device workspace is not shared with the step")
+}
+
+func TestValidate_VolumeSharedWithUnknownStep(t *testing.T) {
+	spec := testValidSpec()
+	spec.Docker.Volumes[0].SharedWith = []string{"ksreb5z2ybkpa5kzey3w7ip29i8gkbt6", "unknown"}
+	testValidateError(t, spec, "volume workspace: unknown step unknown")
+}
+
+func TestValidate_ShellFlags(t *testing.T) {
+	for _, flags := range [][]string{
+		{"-e"},
+		{"-euo", "pipefail"},
+		{"-e", "-o", "pipefail", "+x"},
+	} {
+		spec := testValidSpec()
+		spec.ShellFlags = flags
+		if err := Validate(spec); err != nil {
+			t.Errorf("Want shell flags %v valid, got %s", flags, err)
+		}
+	}
+}
+
+func TestValidate_ShellFlagsInvalid(t *testing.T) {
+	spec := testValidSpec()
+	spec.ShellFlags = []string{"--login"}
+	testValidateError(t, spec, `unrecognized shell flag "--login"`)
+
+	spec.ShellFlags = []string{"-eo", "failfast"}
+	testValidateError(t, spec, `unrecognized shell option "failfast"`)
+
+	spec.ShellFlags = []string{"-euo"}
+	testValidateError(t, spec, `shell flag "-euo" requires an option name`)
+}
+
+func TestValidate_SecretFiles(t *testing.T) {
+	mode := int32(0400)
+	spec := testValidSpec()
+	spec.Steps[0].Docker = &DockerStep{
+		SecretFiles: []*SecretFile{
+			{Name: "password", Path: "/etc/ssl/private/tls.key", Mode: &mode},
+		},
+	}
+	if err := Validate(spec); err != nil {
+		t.Error(err)
+	}
+
+	spec.Steps[0].Docker.SecretFiles[0].Name = "token"
+	testValidateError(t, spec, "step build: unknown secret token")
+
+	spec.Steps[0].Docker.SecretFiles[0].Name = "password"
+	spec.Steps[0].Docker.SecretFiles[0].Path = "tls.key"
+	testValidateError(t, spec, `path "tls.key" is not absolute`)
+
+	mode = 01777
+	spec.Steps[0].Docker.SecretFiles[0].Path = "/etc/ssl/private/tls.key"
+	testValidateError(t, spec, "step build: invalid file mode 01777")
+}
+
+func TestValidate_UnknownSecret(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Secrets[0].Name = "token"
+	testValidateError(t, spec, "unknown secret token")
+}
+
+func TestValidate_UnknownFile(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Files[0].Name = "netrc"
+	testValidateError(t, spec, "unknown file netrc")
+}
+
+func TestValidate_RelativePath(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Volumes[0].Path = "drone/src"
+	testValidateError(t, spec, `path "drone/src" is not absolute`)
+}
+
+func TestValidate_DuplicateUID(t *testing.T) {
+	spec := testValidSpec()
+	spec.Files[0].Metadata.UID = spec.Steps[0].Metadata.UID
+	testValidateError(t, spec, "duplicate uid")
+}
+
+func TestValidate_InvalidUID(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Metadata.UID = "uid/invalid"
+	testValidateError(t, spec, "contains invalid characters")
+}
+
+func TestValidate_UIDUnderscore(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Metadata.UID = "uid_8a7IJsL9zSJCCchd"
+	if err := Validate(spec); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidate_MissingUID(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Metadata.UID = ""
+	testValidateError(t, spec, "missing uid")
+}
+
+func TestValidate_QoSClass(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Resources = &Resources{
+		QoSClass: QoSGuaranteed,
+		Limits:   &ResourceObject{CPU: 1000},
+	}
+	testValidateError(t, spec, "guaranteed qos class requires cpu and memory limits")
+
+	spec.Steps[0].Resources.QoSClass = QoSBestEffort
+	testValidateError(t, spec, "best-effort qos class does not permit resource requests or limits")
+}
+
+func TestValidate_PodSpecPatch(t *testing.T) {
+	spec := testValidSpec()
+	spec.Docker.PodSpecPatch = `{"nodeSelector":{"disktype":"ssd"}}`
+	if err := Validate(spec); err != nil {
+		t.Error(err)
+	}
+	spec.Docker.PodSpecPatch = `["nodeSelector"]`
+	testValidateError(t, spec, "invalid pod spec patch")
+}
+
+func TestValidate_AppArmorProfile(t *testing.T) {
+	for _, profile := range []string{"runtime/default", "unconfined", "localhost/drone"} {
+		spec := testValidSpec()
+		spec.Steps[0].Docker = &DockerStep{AppArmorProfile: profile}
+		if err := Validate(spec); err != nil {
+			t.Errorf("Want profile %s valid, got %s", profile, err)
+		}
+	}
+	spec := testValidSpec()
+	spec.Steps[0].Docker = &DockerStep{AppArmorProfile: "localhost/"}
+	testValidateError(t, spec, "step build: invalid apparmor profile localhost/")
+}
+
+func TestValidate_AffinityTimeout(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Docker = &DockerStep{
+		Service: &ServiceConfig{ClientIPAffinity: true, AffinityTimeout: 90000},
+	}
+	testValidateError(t, spec, "step build: session affinity timeout must be between 0 and 86400 seconds")
+}
+
+func TestValidate_ExternalName(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Docker = &DockerStep{
+		Service: &ServiceConfig{ExternalName: "db.example.com"},
+	}
+	if err := Validate(spec); err != nil {
+		t.Error(err)
+	}
+	spec.Steps[0].Docker.Service.ExternalName = "db_example.com"
+	testValidateError(t, spec, "step build: external name db_example.com is not a valid dns name")
+}
+
+func TestValidate_Ports(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Docker = &DockerStep{
+		Ports: []*Port{{Port: 8080, Host: 80}},
+	}
+	if err := Validate(spec); err != nil {
+		t.Error(err)
+	}
+	spec.Steps[0].Docker.Ports[0].Host = 70000
+	testValidateError(t, spec, "step build: host port 70000 is out of range")
+}
+
+func TestValidate_SupplementalGroups(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Docker = &DockerStep{
+		SupplementalGroups: []int64{1000, -1},
+	}
+	testValidateError(t, spec, "step build: invalid supplemental group -1")
+}
+
+func TestValidate_ExtendedResources(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Resources = &Resources{
+		Extended: map[string]int64{"example.com/fpga": 1},
+	}
+	if err := Validate(spec); err != nil {
+		t.Error(err)
+	}
+	spec.Steps[0].Resources.Extended = map[string]int64{"fpga": 1}
+	testValidateError(t, spec, "step build: extended resource fpga must be prefixed with a domain")
+
+	spec.Steps[0].Resources.Extended = map[string]int64{"example.com/fpga": 0}
+	testValidateError(t, spec, "step build: extended resource example.com/fpga requires a positive quantity")
+}
+
+func TestValidate_Workspace(t *testing.T) {
+	spec := testValidSpec()
+	spec.Workspace = &Workspace{Volume: "workspace", Path: "/drone/src"}
+	if err := Validate(spec); err != nil {
+		t.Error(err)
+	}
+	spec.Workspace = &Workspace{Volume: "cache"}
+	testValidateError(t, spec, "unknown workspace volume cache")
+
+	spec.Workspace = &Workspace{Volume: "workspace", Path: "drone/src"}
+	testValidateError(t, spec, `workspace path "drone/src" is not absolute`)
+}
+
+func TestValidate_EnvSize(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Envs = map[string]string{
+		"SMALL":       "1",
+		"CERTIFICATE": strings.Repeat("x", 100*1024),
+		"SETTINGS":    strings.Repeat("x", 50*1024),
+	}
+	testValidateError(t, spec, "step build: environment size of 153633 bytes exceeds the 131072 byte limit, "+
+		"largest variables are CERTIFICATE (102411 bytes), SETTINGS (51208 bytes), PASSWORD (8 bytes); "+
+		"consider mounting large values as files")
+}
+
+func TestValidate_Aggregate(t *testing.T) {
+	spec := testValidSpec()
+	spec.Steps[0].Secrets[0].Name = "token"
+	spec.Steps[0].Files[0].Name = "netrc"
+	err := Validate(spec)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Errorf("Expect ValidationError, got %v", err)
+		return
+	}
+	if got, want := len(verr.Errors), 2; got != want {
+		t.Errorf("Want %d aggregated errors, got %d", want, got)
+	}
+}
+
+func testValidateError(t *testing.T, spec *Spec, message string) {
+	err := Validate(spec)
+	if err == nil {
+		t.Errorf("Expect validation error")
+		return
+	}
+	if !strings.Contains(err.Error(), message) {
+		t.Errorf("Want error containing %q, got %q", message, err)
+	}
+}
+
+func testValidSpec() *Spec {
+	return &Spec{
+		Metadata: Metadata{UID: "lfjucpr8oj42d4raoo88mor0ueq7aipk"},
+		Secrets: []*Secret{
+			{Metadata: Metadata{UID: "7ip29i8gkbt6", Name: "password"}},
+		},
+		Files: []*File{
+			{Metadata: Metadata{UID: "ueq7aipk4raoo", Name: "script"}},
+		},
+		Docker: &DockerConfig{
+			Volumes: []*Volume{
+				{
+					Metadata: Metadata{UID: "8oj42d4raoo8", Name: "workspace"},
+					EmptyDir: &VolumeEmptyDir{},
+				},
+			},
+		},
+		Steps: []*Step{
+			{
+				Metadata: Metadata{UID: "ksreb5z2ybkpa5kzey3w7ip29i8gkbt6", Name: "build"},
+				Volumes: []*VolumeMount{
+					{Name: "workspace", Path: "/drone/src"},
+				},
+				Secrets: []*SecretVar{
+					{Name: "password", Env: "PASSWORD"},
+				},
+				Files: []*FileMount{
+					{Name: "script", Path: "/usr/drone/bin/init"},
+				},
+				WorkingDir: "/drone/src",
+			},
+		},
+	}
+}
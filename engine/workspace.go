@@ -0,0 +1,46 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+// DefaultWorkspacePath defines the default path at which
+// the workspace volume is mounted.
+const DefaultWorkspacePath = "/drone/src"
+
+// ApplyWorkspace mounts the workspace volume in every
+// pipeline step at the workspace path, unless the step
+// already mounts the volume at a path of its own, and sets
+// the workspace path as the working directory of steps that
+// do not define one. The specification is unchanged if no
+// workspace is defined.
+func ApplyWorkspace(spec *Spec) {
+	if spec.Workspace == nil || spec.Workspace.Volume == "" {
+		return
+	}
+	path := spec.Workspace.Path
+	if path == "" {
+		path = DefaultWorkspacePath
+	}
+	for _, step := range spec.Steps {
+		mounted := false
+		for _, mount := range step.Volumes {
+			if mount.Name != spec.Workspace.Volume {
+				continue
+			}
+			if mount.Path == "" {
+				mount.Path = path
+			}
+			mounted = true
+		}
+		if !mounted {
+			step.Volumes = append(step.Volumes, &VolumeMount{
+				Name: spec.Workspace.Volume,
+				Path: path,
+			})
+		}
+		if step.WorkingDir == "" {
+			step.WorkingDir = path
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyWorkspace(t *testing.T) {
+	spec := &Spec{
+		Workspace: &Workspace{Volume: "workspace", Path: "/go/src/github.com/octocat/hello-world"},
+		Steps: []*Step{
+			{Metadata: Metadata{Name: "build"}},
+			{
+				Metadata: Metadata{Name: "test"},
+				Volumes:  []*VolumeMount{{Name: "workspace"}},
+			},
+		},
+	}
+	ApplyWorkspace(spec)
+
+	for _, step := range spec.Steps {
+		want := []*VolumeMount{{Name: "workspace", Path: "/go/src/github.com/octocat/hello-world"}}
+		if diff := cmp.Diff(step.Volumes, want); diff != "" {
+			t.Errorf("Unexpected workspace mount for step %s", step.Metadata.Name)
+			t.Log(diff)
+		}
+		if got, want := step.WorkingDir, "/go/src/github.com/octocat/hello-world"; got != want {
+			t.Errorf("Want step %s working dir %s, got %s", step.Metadata.Name, want, got)
+		}
+	}
+}
+
+func TestApplyWorkspace_DefaultPath(t *testing.T) {
+	spec := &Spec{
+		Workspace: &Workspace{Volume: "workspace"},
+		Steps: []*Step{
+			{
+				Metadata:   Metadata{Name: "build"},
+				WorkingDir: "/tmp",
+				Volumes:    []*VolumeMount{{Name: "workspace", Path: "/src"}},
+			},
+			{Metadata: Metadata{Name: "test"}},
+		},
+	}
+	ApplyWorkspace(spec)
+
+	// paths defined by the step are not changed.
+	if got, want := spec.Steps[0].Volumes[0].Path, "/src"; got != want {
+		t.Errorf("Want workspace mount path %s, got %s", want, got)
+	}
+	if got, want := spec.Steps[0].WorkingDir, "/tmp"; got != want {
+		t.Errorf("Want working dir %s, got %s", want, got)
+	}
+	if got, want := spec.Steps[1].Volumes[0].Path, DefaultWorkspacePath; got != want {
+		t.Errorf("Want workspace mount path %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// bufferWriter batches the log output and writes complete
+// lines to the underlying writer once the buffer is full or
+// the flush interval elapses. Only complete lines are written,
+// unless a single line exceeds the buffer size, so that the
+// secret masking is applied to whole lines.
+type bufferWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	buf  []byte
+	size int
+	done chan struct{}
+}
+
+func newBufferWriter(w io.Writer, size int, interval time.Duration) *bufferWriter {
+	b := &bufferWriter{
+		w:    w,
+		buf:  make([]byte, 0, size),
+		size: size,
+		done: make(chan struct{}),
+	}
+	if interval > 0 {
+		go b.tick(interval)
+	}
+	return b
+}
+
+func (b *bufferWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) >= b.size {
+		b.flush(false)
+	}
+	// a single line exceeding the buffer size is written
+	// without waiting for the end of the line.
+	if len(b.buf) >= b.size {
+		b.flush(true)
+	}
+	return len(p), nil
+}
+
+// Close stops the flush interval and writes the remaining
+// buffered output to the underlying writer.
+func (b *bufferWriter) Close() error {
+	close(b.done)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flush(true)
+	return nil
+}
+
+func (b *bufferWriter) tick(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			b.flush(false)
+			b.mu.Unlock()
+		}
+	}
+}
+
+// helper function writes the complete lines in the buffer
+// to the underlying writer. If all is true, the incomplete
+// line is also written.
+func (b *bufferWriter) flush(all bool) {
+	n := len(b.buf)
+	if !all {
+		n = bytes.LastIndexByte(b.buf, '\n') + 1
+	}
+	if n == 0 {
+		return
+	}
+	b.w.Write(b.buf[:n])
+	b.buf = append(b.buf[:0], b.buf[n:]...)
+}
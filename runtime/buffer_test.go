@@ -0,0 +1,154 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Drone Non-Commercial License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBufferWriter(t *testing.T) {
+	w := new(mockWriter)
+	b := newBufferWriter(w, 10, 0)
+	b.Write([]byte("foo\n"))
+	b.Write([]byte("bar\n"))
+	if got := w.get(); len(got) != 0 {
+		t.Errorf("Expect output buffered, got %q", got)
+	}
+
+	// the buffer is full, and the complete lines are
+	// written in a single batch.
+	b.Write([]byte("baz\nqu"))
+	if diff := cmp.Diff(w.get(), []string{"foo\nbar\nbaz\n"}); diff != "" {
+		t.Errorf("Expect complete lines written in a single batch")
+		t.Log(diff)
+	}
+
+	// the remaining output is flushed on close.
+	b.Write([]byte("x"))
+	b.Close()
+	if diff := cmp.Diff(w.get(), []string{"foo\nbar\nbaz\n", "qux"}); diff != "" {
+		t.Errorf("Expect remaining output flushed on close")
+		t.Log(diff)
+	}
+}
+
+func TestBufferWriter_LongLine(t *testing.T) {
+	w := new(mockWriter)
+	b := newBufferWriter(w, 4, 0)
+	b.Write([]byte("foobar"))
+	if diff := cmp.Diff(w.get(), []string{"foobar"}); diff != "" {
+		t.Errorf("Expect line exceeding the buffer size written")
+		t.Log(diff)
+	}
+}
+
+func TestBufferWriter_Interval(t *testing.T) {
+	w := new(mockWriter)
+	b := newBufferWriter(w, 1024, 10*time.Millisecond)
+	defer b.Close()
+	b.Write([]byte("foo\nba"))
+	for i := 0; i < 100; i++ {
+		if len(w.get()) != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if diff := cmp.Diff(w.get(), []string{"foo\n"}); diff != "" {
+		t.Errorf("Expect complete lines flushed after the interval")
+		t.Log(diff)
+	}
+}
+
+// this test verifies a secret split across two writes is
+// masked, since only complete lines are written.
+func TestBufferWriter_Secrets(t *testing.T) {
+	var lines []*Line
+	state := &State{
+		hook: &Hook{
+			GotLine: func(_ *State, l *Line) error {
+				lines = append(lines, l)
+				return nil
+			},
+		},
+		Step: &engine.Step{},
+		config: &engine.Spec{
+			Secrets: []*engine.Secret{
+				{Metadata: engine.Metadata{Name: "password"}, Data: "correct-horse"},
+			},
+		},
+	}
+	b := newBufferWriter(newWriter(state), 1024, 0)
+	b.Write([]byte("password correct-"))
+	b.Write([]byte("horse\n"))
+	b.Close()
+	if len(lines) != 1 {
+		t.Errorf("Want 1 line, got %d", len(lines))
+		return
+	}
+	if got, want := lines[0].Message, "password ********\n"; got != want {
+		t.Errorf("Want line %q, got %q", want, got)
+	}
+}
+
+// this test verifies a batch of complete lines written
+// by the buffer is split into lines, without an empty
+// trailing line.
+func TestBufferWriter_Lines(t *testing.T) {
+	var lines []*Line
+	state := &State{
+		hook: &Hook{
+			GotLine: func(_ *State, l *Line) error {
+				lines = append(lines, l)
+				return nil
+			},
+		},
+		Step:   &engine.Step{},
+		config: &engine.Spec{},
+	}
+	w := newWriter(state)
+	b := newBufferWriter(w, 1024, 0)
+	b.Write([]byte("foo\n"))
+	b.Write([]byte("bar\n"))
+	b.Write([]byte("baz\n"))
+	b.Close()
+	if len(lines) != 3 {
+		t.Errorf("Want 3 lines, got %d", len(lines))
+		return
+	}
+	for i, want := range []string{"foo\n", "bar\n", "baz\n"} {
+		if got := lines[i].Message; got != want {
+			t.Errorf("Want line %q, got %q", want, got)
+		}
+		if got := lines[i].Number; got != i {
+			t.Errorf("Want line number %d, got %d", i, got)
+		}
+	}
+	if got, want := w.num, 3; got != want {
+		t.Errorf("Want line count %d, got %d", want, got)
+	}
+}
+
+type mockWriter struct {
+	sync.Mutex
+	writes []string
+}
+
+func (w *mockWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	w.writes = append(w.writes, string(p))
+	w.Unlock()
+	return len(p), nil
+}
+
+func (w *mockWriter) get() []string {
+	w.Lock()
+	defer w.Unlock()
+	return w.writes
+}
@@ -47,13 +47,20 @@ func (e *ExitError) Error() string {
 }
 
 // An OomError reports the process received an OOMKill from
-// the kernel.
+// the kernel. The underlying engine error is of the kind
+// engine.ErrOOMKilled.
 type OomError struct {
 	Name string
 	Code int
+	Err  error
 }
 
 // Error reteurns the error message in string format.
 func (e *OomError) Error() string {
 	return fmt.Sprintf("%s : received oom kill", e.Name)
 }
+
+// Unwrap returns the underlying engine error.
+func (e *OomError) Unwrap() error {
+	return e.Err
+}
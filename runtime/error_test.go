@@ -4,7 +4,12 @@
 
 package runtime
 
-import "testing"
+import (
+	"errors"
+	"testing"
+
+	"github.com/drone/drone-runtime/engine"
+)
 
 func TestExitError(t *testing.T) {
 	err := ExitError{
@@ -26,3 +31,15 @@ func TestOomError(t *testing.T) {
 		t.Errorf("Want error message %q, got %q", want, got)
 	}
 }
+
+func TestOomError_Unwrap(t *testing.T) {
+	state := &engine.State{ExitCode: 137, Exited: true, OOMKilled: true}
+	err := &OomError{
+		Name: "build",
+		Code: state.ExitCode,
+		Err:  state.Err(),
+	}
+	if !errors.Is(err, engine.ErrOOMKilled) {
+		t.Errorf("Want error %v, got %v", engine.ErrOOMKilled, err)
+	}
+}
@@ -69,8 +69,15 @@ func (w *lineWriter) Write(p []byte) (n int, err error) {
 	// note that docker output always inclines a line
 	// feed marker. This needs to be accounted for when
 	// splitting the output into multiple lines.
+	//
+	// note that splitting output with a trailing line
+	// feed yields an empty trailing part, which is not
+	// a line and is therefore dropped.
 	if strings.Contains(strings.TrimSuffix(out, "\n"), "\n") {
 		parts = strings.SplitAfter(out, "\n")
+		if parts[len(parts)-1] == "" {
+			parts = parts[:len(parts)-1]
+		}
 	}
 
 	for _, part := range parts {
@@ -14,7 +14,11 @@
 
 package runtime
 
-import "github.com/drone/drone-runtime/engine"
+import (
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+)
 
 // Option configures a Runtime option.
 type Option func(*Runtime)
@@ -33,6 +37,42 @@ func WithConfig(c *engine.Spec) Option {
 	}
 }
 
+// WithSecretResolver sets the Runtime secret resolver,
+// used to resolve secrets not defined in the configuration.
+func WithSecretResolver(s engine.SecretResolver) Option {
+	return func(r *Runtime) {
+		r.secrets = s
+	}
+}
+
+// WithDigestResolver sets the Runtime digest resolver,
+// used to pin the step images to their manifest digest.
+func WithDigestResolver(d engine.DigestResolver) Option {
+	return func(r *Runtime) {
+		r.digests = d
+	}
+}
+
+// WithPlatformResolver sets the Runtime platform resolver,
+// used to verify the step images support the target platform
+// before the steps are scheduled.
+func WithPlatformResolver(p engine.PlatformResolver) Option {
+	return func(r *Runtime) {
+		r.platforms = p
+	}
+}
+
+// WithLogBuffer sets the Runtime log buffer size in bytes
+// and flush interval, used to batch the step log output.
+// The buffered logs are flushed once the buffer is full or
+// the interval elapses, and when the step completes.
+func WithLogBuffer(size int, interval time.Duration) Option {
+	return func(r *Runtime) {
+		r.buffer = size
+		r.interval = interval
+	}
+}
+
 // WithHooks sets the Runtime tracer.
 func WithHooks(h *Hook) Option {
 	return func(r *Runtime) {
@@ -5,7 +5,9 @@
 package runtime
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/drone/drone-runtime/engine"
 )
@@ -25,3 +27,43 @@ func TestWithConfig(t *testing.T) {
 		t.Errorf("Option does not set runtime configuration")
 	}
 }
+
+func TestWithSecretResolver(t *testing.T) {
+	s := new(mockResolver)
+	r := New(WithSecretResolver(s))
+	if r.secrets != s {
+		t.Errorf("Option does not set runtime secret resolver")
+	}
+}
+
+func TestWithDigestResolver(t *testing.T) {
+	d := engine.NewRegistryResolver(nil)
+	r := New(WithDigestResolver(d))
+	if r.digests != d {
+		t.Errorf("Option does not set runtime digest resolver")
+	}
+}
+
+func TestWithPlatformResolver(t *testing.T) {
+	p := engine.NewPlatformResolver(nil)
+	r := New(WithPlatformResolver(p))
+	if r.platforms != p {
+		t.Errorf("Option does not set runtime platform resolver")
+	}
+}
+
+func TestWithLogBuffer(t *testing.T) {
+	r := New(WithLogBuffer(4096, time.Second))
+	if r.buffer != 4096 {
+		t.Errorf("Want log buffer size 4096, got %d", r.buffer)
+	}
+	if r.interval != time.Second {
+		t.Errorf("Want log flush interval %s, got %s", time.Second, r.interval)
+	}
+}
+
+type mockResolver struct{}
+
+func (*mockResolver) Resolve(context.Context, *engine.Spec, string) (*engine.Secret, error) {
+	return nil, nil
+}
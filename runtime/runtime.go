@@ -29,11 +29,16 @@ import (
 type Runtime struct {
 	mu sync.Mutex
 
-	engine engine.Engine
-	config *engine.Spec
-	hook   *Hook
-	start  int64
-	error  error
+	engine    engine.Engine
+	config    *engine.Spec
+	secrets   engine.SecretResolver
+	digests   engine.DigestResolver
+	platforms engine.PlatformResolver
+	hook      *Hook
+	buffer    int
+	interval  time.Duration
+	start     int64
+	error     error
 }
 
 // New returns a new runtime using the specified runtime
@@ -72,6 +77,45 @@ func (r *Runtime) Resume(ctx context.Context, start int) error {
 		}
 	}
 
+	// secrets that are not defined inline are resolved
+	// before the environment is created, so that the
+	// engines can reference them like inline secrets.
+	if r.secrets != nil {
+		if err := engine.ResolveSecrets(ctx, r.config, r.secrets); err != nil {
+			return err
+		}
+	}
+
+	// images are pinned to the manifest digest before the
+	// environment is created, so that all steps using the
+	// same image tag execute the same image.
+	if r.digests != nil {
+		if err := engine.PinImages(ctx, r.config, r.digests); err != nil {
+			return err
+		}
+	}
+
+	// images are verified to support the target platform
+	// before the environment is created, so that the build
+	// fails fast instead of failing once the step starts.
+	if r.platforms != nil {
+		if err := engine.CheckPlatforms(ctx, r.config, r.platforms); err != nil {
+			return err
+		}
+	}
+
+	// the workspace volume is mounted in every step before
+	// the environment is created.
+	engine.ApplyWorkspace(r.config)
+
+	// the specification is validated once the secrets are
+	// resolved and the workspace is mounted, so that an
+	// inconsistent specification fails before any resources
+	// are created.
+	if err := engine.Validate(r.config); err != nil {
+		return err
+	}
+
 	if err := r.engine.Setup(ctx, r.config); err != nil {
 		return err
 	}
@@ -227,7 +271,7 @@ func (r *Runtime) exec(step *engine.Step) error {
 	var g errgroup.Group
 	state := snapshot(r, step, nil)
 	g.Go(func() error {
-		return stream(state, rc)
+		return stream(state, rc, r.buffer, r.interval)
 	})
 
 	if step.Detach {
@@ -246,14 +290,15 @@ func (r *Runtime) exec(step *engine.Step) error {
 
 	err = g.Wait() // wait for background tasks to complete.
 
-	if wait.OOMKilled {
+	if oom := wait.Err(); oom != nil {
 		err = &OomError{
 			Name: step.Metadata.Name,
 			Code: wait.ExitCode,
+			Err:  oom,
 		}
 	} else if wait.ExitCode == 78 {
 		err = ErrInterrupt
-	} else if wait.ExitCode != 0 {
+	} else if wait.ExitCode != 0 && !wait.Succeeded {
 		err = &ExitError{
 			Name: step.Metadata.Name,
 			Code: wait.ExitCode,
@@ -273,12 +318,19 @@ func (r *Runtime) exec(step *engine.Step) error {
 	return err
 }
 
-// helper function exports a single file or folder.
-func stream(state *State, rc io.ReadCloser) error {
+// helper function streams the step logs. If the buffer size
+// is greater than zero, the logs are written in batches.
+func stream(state *State, rc io.ReadCloser, size int, interval time.Duration) error {
 	defer rc.Close()
 
 	w := newWriter(state)
-	io.Copy(w, rc)
+	if size > 0 {
+		b := newBufferWriter(w, size, interval)
+		io.Copy(b, rc)
+		b.Close()
+	} else {
+		io.Copy(w, rc)
+	}
 
 	if state.hook.GotLogs != nil {
 		return state.hook.GotLogs(state, w.lines)
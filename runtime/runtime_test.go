@@ -4,6 +4,320 @@
 
 package runtime
 
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-runtime/engine"
+	"github.com/drone/drone-runtime/engine/mocks"
+
+	"github.com/golang/mock/gomock"
+)
+
+// TestRunDetached verifies the runtime starts a detached
+// step in the background and does not wait for it to exit.
+func TestRunDetached(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	service := &engine.Step{
+		Metadata: engine.Metadata{UID: "uid_redis", Name: "redis"},
+		Detach:   true,
+	}
+	build := &engine.Step{
+		Metadata: engine.Metadata{UID: "uid_build", Name: "build"},
+	}
+	conf := &engine.Spec{
+		Steps: []*engine.Step{service, build},
+	}
+
+	mock := mock_engine.NewMockEngine(c)
+	mock.EXPECT().Setup(gomock.Any(), conf)
+	mock.EXPECT().Destroy(gomock.Any(), conf)
+	for _, step := range conf.Steps {
+		mock.EXPECT().Create(gomock.Any(), conf, step)
+		mock.EXPECT().Start(gomock.Any(), conf, step)
+		mock.EXPECT().Tail(gomock.Any(), conf, step).Return(
+			ioutil.NopCloser(bytes.NewBufferString("")), nil)
+	}
+	// wait is only expected for the build step. the mock
+	// fails the test if wait is invoked for the service.
+	mock.EXPECT().Wait(gomock.Any(), conf, build).Return(
+		&engine.State{Exited: true}, nil)
+
+	run := New(
+		WithEngine(mock),
+		WithConfig(conf),
+	)
+	if err := run.Run(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRunGraph verifies the runtime executes independent
+// steps concurrently, and executes dependent steps after
+// their dependencies complete.
+func TestRunGraph(t *testing.T) {
+	conf := &engine.Spec{
+		Steps: []*engine.Step{
+			{Metadata: engine.Metadata{UID: "uid_clone", Name: "clone"}},
+			{Metadata: engine.Metadata{UID: "uid_backend", Name: "backend"}, DependsOn: []string{"clone"}},
+			{Metadata: engine.Metadata{UID: "uid_frontend", Name: "frontend"}, DependsOn: []string{"clone"}},
+			{Metadata: engine.Metadata{UID: "uid_publish", Name: "publish"}, DependsOn: []string{"backend", "frontend"}},
+		},
+	}
+
+	// the backend and frontend steps wait for each other
+	// to start, which deadlocks unless they execute in
+	// parallel.
+	eng := &graphEngine{
+		started: map[string]chan struct{}{
+			"backend":  make(chan struct{}),
+			"frontend": make(chan struct{}),
+		},
+		peers: map[string]string{
+			"backend":  "frontend",
+			"frontend": "backend",
+		},
+	}
+
+	run := New(
+		WithEngine(eng),
+		WithConfig(conf),
+	)
+	if err := run.Run(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	index := map[string]int{}
+	for i, event := range eng.events {
+		index[event] = i
+	}
+	for _, order := range [][2]string{
+		{"finish clone", "start backend"},
+		{"finish clone", "start frontend"},
+		{"finish backend", "start publish"},
+		{"finish frontend", "start publish"},
+	} {
+		if index[order[0]] > index[order[1]] {
+			t.Errorf("Want %q before %q, got %v", order[0], order[1], eng.events)
+		}
+	}
+}
+
+// TestRunPolicy verifies the runtime evaluates the step
+// run policy against the status of the prior steps.
+func TestRunPolicy(t *testing.T) {
+	tests := []struct {
+		policy engine.RunPolicy
+		failed bool
+		run    bool
+	}{
+		{policy: engine.RunAlways, failed: false, run: true},
+		{policy: engine.RunAlways, failed: true, run: true},
+		{policy: engine.RunOnSuccess, failed: false, run: true},
+		{policy: engine.RunOnSuccess, failed: true, run: false},
+		{policy: engine.RunOnFailure, failed: false, run: false},
+		{policy: engine.RunOnFailure, failed: true, run: true},
+		{policy: engine.RunNever, failed: false, run: false},
+		{policy: engine.RunNever, failed: true, run: false},
+	}
+	for _, test := range tests {
+		conf := &engine.Spec{
+			Steps: []*engine.Step{
+				{Metadata: engine.Metadata{UID: "uid_build", Name: "build"}},
+				{Metadata: engine.Metadata{UID: "uid_cleanup", Name: "cleanup"}, RunPolicy: test.policy},
+			},
+		}
+		eng := &graphEngine{}
+		if test.failed {
+			eng.exit = map[string]int{"build": 1}
+		}
+		New(WithEngine(eng), WithConfig(conf)).Run(context.Background())
+
+		var run bool
+		for _, event := range eng.events {
+			if event == "start cleanup" {
+				run = true
+			}
+		}
+		if run != test.run {
+			t.Errorf("Want step with policy %s run %v when prior step failed %v",
+				test.policy, test.run, test.failed)
+		}
+	}
+}
+
+// TestRunSucceeded verifies the runtime does not fail the
+// pipeline when the engine classifies a non-zero exit code
+// as successful, and reports the exit code unchanged.
+func TestRunSucceeded(t *testing.T) {
+	conf := &engine.Spec{
+		Steps: []*engine.Step{
+			{Metadata: engine.Metadata{UID: "uid_build", Name: "build"}},
+		},
+	}
+	eng := &graphEngine{
+		exit:    map[string]int{"build": 2},
+		success: map[string]bool{"build": true},
+	}
+	var code int
+	hook := &Hook{
+		AfterEach: func(state *State) error {
+			code = state.State.ExitCode
+			return nil
+		},
+	}
+	err := New(WithEngine(eng), WithConfig(conf), WithHooks(hook)).Run(context.Background())
+	if err != nil {
+		t.Errorf("Expect step with successful exit code to pass, got %v", err)
+	}
+	if code != 2 {
+		t.Errorf("Want exit code %d, got %d", 2, code)
+	}
+
+	eng.success = nil
+	err = New(WithEngine(eng), WithConfig(conf), WithHooks(hook)).Run(context.Background())
+	if _, ok := err.(*ExitError); !ok {
+		t.Errorf("Want exit error, got %v", err)
+	}
+}
+
+// TestRunValidate verifies the runtime validates the
+// specification, and fails before the environment is
+// created if the specification is invalid.
+func TestRunValidate(t *testing.T) {
+	tests := []struct {
+		mutate func(*engine.Spec, *engine.Step)
+		err    string
+	}{
+		{
+			mutate: func(spec *engine.Spec, step *engine.Step) {
+				step.Envs = map[string]string{"LARGE": strings.Repeat("x", 200*1024)}
+			},
+			err: "step build: environment size of 204805 bytes exceeds the 131072 byte limit",
+		},
+		{
+			mutate: func(spec *engine.Spec, step *engine.Step) {
+				step.Docker.Service = &engine.ServiceConfig{ExternalName: "db_example.com"}
+			},
+			err: "step build: external name db_example.com is not a valid dns name",
+		},
+		{
+			mutate: func(spec *engine.Spec, step *engine.Step) {
+				step.Docker.Ports = []*engine.Port{{Port: 6379, Host: 70000}}
+			},
+			err: "step build: host port 70000 is out of range",
+		},
+		{
+			mutate: func(spec *engine.Spec, step *engine.Step) {
+				step.Docker.SupplementalGroups = []int64{-1}
+			},
+			err: "step build: invalid supplemental group -1",
+		},
+		{
+			mutate: func(spec *engine.Spec, step *engine.Step) {
+				step.Resources = &engine.Resources{Extended: map[string]int64{"fpga": 1}}
+			},
+			err: "step build: extended resource fpga must be prefixed with a domain",
+		},
+		{
+			mutate: func(spec *engine.Spec, step *engine.Step) {
+				spec.ShellFlags = []string{"-eo"}
+			},
+			err: `shell flag "-eo" requires an option name`,
+		},
+		{
+			mutate: func(spec *engine.Spec, step *engine.Step) {
+				step.Docker.SecretFiles = []*engine.SecretFile{{Name: "token", Path: "/run/secrets/token"}}
+			},
+			err: "step build: unknown secret token",
+		},
+	}
+	for _, test := range tests {
+		step := &engine.Step{
+			Metadata: engine.Metadata{UID: "uid_build", Name: "build"},
+			Docker:   &engine.DockerStep{Image: "golang:1.11"},
+		}
+		conf := &engine.Spec{Steps: []*engine.Step{step}}
+		test.mutate(conf, step)
+
+		eng := &graphEngine{}
+		err := New(WithEngine(eng), WithConfig(conf)).Run(context.Background())
+		if _, ok := err.(*engine.ValidationError); !ok {
+			t.Errorf("Want validation error %q, got %v", test.err, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Want validation error %q, got %q", test.err, err)
+		}
+		if len(eng.events) != 0 {
+			t.Errorf("Want environment not created, got %v", eng.events)
+		}
+	}
+}
+
+// graphEngine is an Engine that records the order in which
+// steps start and finish, for testing purposes.
+type graphEngine struct {
+	sync.Mutex
+	events  []string
+	started map[string]chan struct{}
+	peers   map[string]string
+	exit    map[string]int
+	success map[string]bool
+}
+
+func (e *graphEngine) record(event string) {
+	e.Lock()
+	e.events = append(e.events, event)
+	e.Unlock()
+}
+
+func (e *graphEngine) Create(context.Context, *engine.Spec, *engine.Step) error { return nil }
+func (e *graphEngine) Destroy(context.Context, *engine.Spec) error              { return nil }
+
+func (e *graphEngine) Setup(context.Context, *engine.Spec) error {
+	e.record("setup")
+	return nil
+}
+
+func (e *graphEngine) Start(_ context.Context, _ *engine.Spec, step *engine.Step) error {
+	e.record("start " + step.Metadata.Name)
+	if c, ok := e.started[step.Metadata.Name]; ok {
+		close(c)
+	}
+	return nil
+}
+
+func (e *graphEngine) Wait(_ context.Context, _ *engine.Spec, step *engine.Step) (*engine.State, error) {
+	if peer, ok := e.peers[step.Metadata.Name]; ok {
+		select {
+		case <-e.started[peer]:
+		case <-time.After(time.Second):
+			return nil, errors.New("timeout waiting for parallel step " + peer)
+		}
+	}
+	e.record("finish " + step.Metadata.Name)
+	return &engine.State{
+		Exited:    true,
+		ExitCode:  e.exit[step.Metadata.Name],
+		Succeeded: e.success[step.Metadata.Name],
+	}, nil
+}
+
+func (e *graphEngine) Tail(context.Context, *engine.Spec, *engine.Step) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewBufferString("")), nil
+}
+
 // import (
 // 	"bytes"
 // 	"context"
@@ -100,45 +414,6 @@ package runtime
 // 	t.Skip()
 // }
 
-// // TestRunDetached verifies the runtime executes a container in the background
-// // and does not wait for it to execute when the detached flag is true.
-// func TestRunDetached(t *testing.T) {
-// 	c := gomock.NewController(t)
-// 	defer c.Finish()
-
-// 	conf := &engine.Config{
-// 		Stages: []*engine.Stage{
-// 			{
-// 				Name: "stage_0",
-// 				Steps: []*engine.Step{
-// 					{Name: "step_0", OnSuccess: true, Detached: true},
-// 				},
-// 			},
-// 		},
-// 	}
-
-// 	buf := ioutil.NopCloser(bytes.NewBufferString(""))
-
-// 	ctx := context.TODO()
-
-// 	mock := mocks.NewMockEngine(c)
-// 	mock.EXPECT().Setup(ctx, conf)
-// 	mock.EXPECT().Destroy(ctx, conf)
-// 	mock.EXPECT().Tail(ctx, conf.Stages[0].Steps[0]).Return(buf, nil)
-// 	mock.EXPECT().Create(ctx, conf.Stages[0].Steps[0])
-// 	mock.EXPECT().Start(ctx, conf.Stages[0].Steps[0])
-
-// 	run := New(
-// 		WithEngine(mock),
-// 		WithConfig(conf),
-// 	)
-
-// 	err := run.Run(context.Background())
-// 	if err != nil {
-// 		t.Error(err)
-// 	}
-// }
-
 // // TestRunError verifies the runtime exits when the docker engine returns an
 // // error doing a routine operation, like waiting for a container to exit.
 // func TestRunError(t *testing.T) {